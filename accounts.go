@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/99designs/iamy/iamy"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/fatih/color"
+)
+
+// defaultAccountsConfigFile is the accounts config read from the yaml directory
+// when --all-accounts is used without --accounts-config
+const defaultAccountsConfigFile = "iamy-accounts.yaml"
+
+// AllAccountsInput configures pulling or pushing every account in an accounts
+// config, or with OrgRole, every account in the organization
+type AllAccountsInput struct {
+	ConfigFile  string
+	OrgRole     string
+	Parallelism int
+}
+
+// skippedAccountError is returned for an account that was skipped, rather than failed
+type skippedAccountError struct {
+	err error
+}
+
+func (e skippedAccountError) Error() string {
+	return "Skipped, " + e.err.Error()
+}
+
+func readAccountsConfig(ui Ui, dir string, input *AllAccountsInput) []iamy.AccountConfig {
+	if input.OrgRole != "" {
+		accounts, err := iamy.OrganizationAccounts(input.OrgRole)
+		if err != nil {
+			ui.Fatal(err)
+		}
+		return accounts
+	}
+
+	configFile := input.ConfigFile
+	if configFile == "" {
+		configFile = filepath.Join(dir, defaultAccountsConfigFile)
+	}
+
+	config, err := iamy.ReadAccountsConfig(configFile)
+	if err != nil {
+		ui.Fatal(err)
+	}
+
+	return config.Accounts
+}
+
+// accountClients are the clients used for an account instead of the clients
+// for its session, where they're set. They're replaced with fakes in tests
+var accountClients = func(account iamy.AccountConfig) iamy.Clients {
+	return iamy.Clients{}
+}
+
+// forEachAccount calls f with a session for each account, running at most
+// parallelism at once, and returns the error for each account
+func forEachAccount(accounts []iamy.AccountConfig, parallelism int, f func(i int, sess *session.Session) error) []error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	errs := make([]error, len(accounts))
+	sem := make(chan struct{}, parallelism)
+	done := make(chan struct{})
+	for i := range accounts {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			sess, err := accounts[i].Session()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = f(i, sess)
+		}(i)
+	}
+	for range accounts {
+		<-done
+	}
+
+	return errs
+}
+
+// fetchAccount fetches an account, checking the credentials are for the expected account
+func fetchAccount(aws *iamy.AwsFetcher, account iamy.AccountConfig, sess *session.Session) (*iamy.AccountData, error) {
+	aws.Session = sess
+	aws.Clients = accountClients(account)
+	data, err := aws.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	if data.Account.Id != account.Id {
+		return nil, fmt.Errorf("Credentials are for AWS Account ID %s, not %s", data.Account.Id, account.Id)
+	}
+
+	return data, nil
+}
+
+// printSummary prints a line for each account
+func printSummary(ui Ui, accounts []iamy.AccountConfig, names []string, summaries []string, errs []error) {
+	ui.Println("\nSummary:")
+	for i, account := range accounts {
+		name := account.Id
+		if names[i] != "" {
+			name = names[i]
+		}
+		if _, skipped := errs[i].(skippedAccountError); skipped {
+			ui.Println("      " + color.YellowString("%s: %s", name, errs[i]))
+		} else if errs[i] != nil {
+			ui.Println("      " + color.RedString("%s: %s", name, errs[i]))
+		} else {
+			ui.Printf("      %s: %s", name, summaries[i])
+		}
+	}
+}
+
+// printAccountErrors prints the accounts that failed or were skipped to stderr,
+// for when stdout is json
+func printAccountErrors(ui Ui, accounts []iamy.AccountConfig, errs []error) {
+	for i, err := range errs {
+		if err != nil {
+			ui.Error.Printf("%s: %s", accounts[i].Id, err)
+		}
+	}
+}
+
+// anyError is true if any account failed, ignoring skipped accounts
+func anyError(errs []error) bool {
+	for _, err := range errs {
+		if _, skipped := err.(skippedAccountError); err != nil && !skipped {
+			return true
+		}
+	}
+	return false
+}
+
+// PullAllAccounts pulls each account in the accounts config or organization
+// into dir. Organization accounts whose role can't be assumed are skipped
+func PullAllAccounts(ui Ui, input PullCommandInput) {
+	accounts := readAccountsConfig(ui, input.Dir, input.AllAccounts)
+	declared := loadDeclared(ui, input.Dir)
+	names := make([]string, len(accounts))
+	summaries := make([]string, len(accounts))
+	outputs := make([]*pullOutput, len(accounts))
+
+	errs := forEachAccount(accounts, input.AllAccounts.Parallelism, func(i int, sess *session.Session) error {
+		if input.AllAccounts.OrgRole != "" && accounts[i].RoleArn != "" {
+			if _, err := sess.Config.Credentials.Get(); err != nil {
+				return skippedAccountError{fmt.Errorf("couldn't assume %s: %s", accounts[i].RoleArn, err)}
+			}
+		}
+
+		regions := input.Regions
+		if len(regions) == 0 {
+			regions = []string{iamy.SessionRegion(sess)}
+		}
+		data, err := fetchAccount(&iamy.AwsFetcher{
+			Debug:                ui.Debug,
+			HeuristicCfnMatching: input.HeuristicCfnMatching,
+			Regions:              regions,
+			CredentialsNeeded:    iamy.CredentialsNeededToPull(declared),
+			SSHPublicKeysNeeded:  iamy.SSHPublicKeysNeededFor(declared),
+		}, accounts[i], sess)
+		if err != nil {
+			return err
+		}
+		data.KeepCredentialExpectations(declared)
+		names[i] = data.Account.String()
+
+		yaml := iamy.YamlLoadDumper{
+			Dir: input.Dir,
+		}
+		if err = yaml.Dump(data, input.CanDelete); err != nil {
+			return err
+		}
+
+		o := newPullOutput(input.Dir, data)
+		outputs[i] = &o
+		summaries[i] = fmt.Sprintf("%d resources", len(outputs[i].Resources))
+		return nil
+	})
+
+	if *output == outputJson {
+		pulled := []*pullOutput{}
+		for _, o := range outputs {
+			if o != nil {
+				pulled = append(pulled, o)
+			}
+		}
+		printJson(ui, pulled)
+		printAccountErrors(ui, accounts, errs)
+	} else {
+		printSummary(ui, accounts, names, summaries, errs)
+	}
+	if anyError(errs) {
+		ui.Exit(exitCodeError)
+	}
+}
+
+// PushAllAccounts generates plans for each account in the accounts config
+// concurrently, then shows and runs them one account at a time. An account
+// that fails doesn't stop the others, and is reported in the summary
+func PushAllAccounts(ui Ui, input PushCommandInput) {
+	if input.DetailedExitCode {
+		defer exitOnPanic(ui)
+	}
+
+	yaml := iamy.YamlLoadDumper{
+		Dir: input.Dir,
+	}
+	allDataFromYaml, err := yaml.Load()
+	if err != nil {
+		ui.Fatal(err)
+	}
+
+	accounts := readAccountsConfig(ui, input.Dir, input.AllAccounts)
+	names := make([]string, len(accounts))
+	sessions := make([]*session.Session, len(accounts))
+	awsData := make([]*iamy.AccountData, len(accounts))
+	yamlData := make([]*iamy.AccountData, len(accounts))
+	plans := make([]*iamy.Plan, len(accounts))
+
+	errs := forEachAccount(accounts, input.AllAccounts.Parallelism, func(i int, sess *session.Session) error {
+		var dataFromYaml *iamy.AccountData
+		for j := range allDataFromYaml {
+			if allDataFromYaml[j].Account.Id == accounts[i].Id {
+				dataFromYaml = &allDataFromYaml[j]
+			}
+		}
+		if dataFromYaml == nil {
+			return fmt.Errorf("No files found for AWS Account ID %s", accounts[i].Id)
+		}
+
+		// only fetch resource policies from the regions that are in yaml, so that
+		// resources in other regions aren't deleted
+		data, err := fetchAccount(&iamy.AwsFetcher{
+			SkipFetchingPolicyDescriptions: true,
+			Debug:                          ui.Debug,
+			Regions:                        dataFromYaml.Regions(),
+			CredentialsNeeded:              iamy.CredentialsNeededFor(allDataFromYaml),
+			SSHPublicKeysNeeded:            iamy.SSHPublicKeysNeededFor(allDataFromYaml),
+		}, accounts[i], sess)
+		if err != nil {
+			return err
+		}
+
+		names[i] = data.Account.String()
+		plan, err := iamy.PlanForSync(data, dataFromYaml)
+		if err != nil {
+			return err
+		}
+
+		sessions[i] = sess
+		awsData[i] = data
+		yamlData[i] = dataFromYaml
+		plans[i] = plan
+		return nil
+	})
+
+	if *output == outputJson {
+		outputs := []planOutput{}
+		for i, plan := range plans {
+			if plan != nil {
+				outputs = append(outputs, newPlanOutput(awsData[i].Account, plan))
+			}
+		}
+		printJson(ui, outputs)
+		printAccountErrors(ui, accounts, errs)
+	} else {
+		summaries := make([]string, len(accounts))
+		for i, plan := range plans {
+			if plan == nil {
+				continue
+			}
+			ui.Printf("\n== %s ==", names[i])
+			printPlan(awsData[i].Account, plan, ui)
+			summaries[i] = "Already up to date"
+			if plan.Count() > 0 {
+				summaries[i] = fmt.Sprintf("%d changes (%d destructive)", plan.Count(), plan.CountDestructive())
+				if *dryRun {
+					ui.Println("Dry-run mode not running aws commands")
+				} else {
+					clients := accountClients(accounts[i])
+					rb := rollbackForAccount(ui, plan, sessions[i], clients, awsData[i], yamlData[i])
+					errs[i] = promptAndExec(plan, iamy.NewExecutorWithClients(sessions[i], clients), rb, ui)
+				}
+			}
+		}
+		printSummary(ui, accounts, names, summaries, errs)
+	}
+
+	if anyError(errs) {
+		ui.Exit(exitCodeError)
+		return
+	}
+	if input.DetailedExitCode {
+		for _, plan := range plans {
+			if plan.Count() > 0 {
+				ui.Exit(exitCodeChanges)
+				return
+			}
+		}
+	}
+}
+
+// rollbackForAccount saves the plan to undo a push to one of many accounts
+func rollbackForAccount(ui Ui, plan *iamy.Plan, sess *session.Session, clients iamy.Clients, before, expected *iamy.AccountData) *rollback {
+	newFetcher := rollbackFetcher(ui, expected.Regions(), iamy.CredentialsNeededFor([]iamy.AccountData{*expected}), iamy.SSHPublicKeysNeededFor([]iamy.AccountData{*expected}))
+	return newRollback(plan, before, expected, func() *iamy.AwsFetcher {
+		aws := newFetcher()
+		aws.Session = sess
+		aws.Clients = clients
+		return aws
+	}, true)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/99designs/iamy/iamy"
+	"github.com/99designs/iamy/iamy/memaws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func backendClients(b *memaws.Backend) iamy.Clients {
+	return iamy.Clients{
+		IAM:            b.IAM(),
+		S3:             b.S3(),
+		CloudFormation: b.CloudFormation(),
+		S3Control:      b.S3Control(),
+		Organizations:  b.Organizations(),
+		STS:            b.STS(),
+	}
+}
+
+// setBackends uses a memaws backend for each account, and answers prompts with answers
+func setBackends(t *testing.T, backends map[string]*memaws.Backend, answers string) {
+	oldClients, oldStdin := accountClients, stdin
+	accountClients = func(account iamy.AccountConfig) iamy.Clients {
+		return backendClients(backends[account.Id])
+	}
+	stdin = bufio.NewReader(strings.NewReader(answers))
+	t.Cleanup(func() { accountClients, stdin = oldClients, oldStdin })
+}
+
+// inTempDir runs the test in a temporary directory, where rollbacks are saved
+func inTempDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// exitCode runs f, returning the code it exits with, or -1 if it doesn't exit
+func exitCode(f func()) (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			code = r.(int)
+		}
+	}()
+	f()
+	return -1
+}
+
+func TestForEachAccountRunsAtMostParallelismAtOnce(t *testing.T) {
+	accounts := make([]iamy.AccountConfig, 6)
+	var running, maxRunning int32
+
+	errs := forEachAccount(accounts, 2, func(i int, sess *session.Session) error {
+		n := atomic.AddInt32(&running, 1)
+		for m := atomic.LoadInt32(&maxRunning); n > m && !atomic.CompareAndSwapInt32(&maxRunning, m, n); m = atomic.LoadInt32(&maxRunning) {
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+
+		if i == 3 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	if maxRunning != 2 {
+		t.Errorf("Expected 2 accounts at once, got %d", maxRunning)
+	}
+	for i, err := range errs {
+		if (i == 3) != (err != nil) {
+			t.Errorf("Expected only account 3 to fail, account %d returned %v", i, err)
+		}
+	}
+}
+
+func TestForEachAccountFetchesEachAccountWithItsClients(t *testing.T) {
+	backends := map[string]*memaws.Backend{
+		"111111111111": memaws.New("111111111111"),
+		// credentials for the wrong account
+		"222222222222": memaws.New("333333333333"),
+	}
+	setBackends(t, backends, "")
+	accounts := []iamy.AccountConfig{{Id: "111111111111"}, {Id: "222222222222"}}
+	ids := make([]string, len(accounts))
+
+	errs := forEachAccount(accounts, 2, func(i int, sess *session.Session) error {
+		data, err := fetchAccount(&iamy.AwsFetcher{}, accounts[i], sess)
+		if err != nil {
+			return err
+		}
+		ids[i] = data.Account.Id
+		return nil
+	})
+
+	if errs[0] != nil || ids[0] != "111111111111" {
+		t.Errorf("Expected the first account to be fetched, got %v", errs[0])
+	}
+	if expected := "Credentials are for AWS Account ID 333333333333, not 222222222222"; errs[1] == nil || errs[1].Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, errs[1])
+	}
+}
+
+func TestPushAllAccountsCarriesOnAfterAnAccountFails(t *testing.T) {
+	setFlags(t, outputText, false)
+	inTempDir(t)
+	ui, stdout := newTestUi()
+	backends := map[string]*memaws.Backend{
+		"111111111111": memaws.New("111111111111"),
+		"222222222222": memaws.New("222222222222"),
+		"333333333333": memaws.New("333333333333"),
+	}
+	setBackends(t, backends, "y\ny\n")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		defaultAccountsConfigFile:          "Accounts:\n- Id: \"111111111111\"\n- Id: \"222222222222\"\n- Id: \"333333333333\"\n",
+		"111111111111/iam/user/alice.yaml": "{}\n",
+		// the policy doesn't exist, so attaching it fails
+		"222222222222/iam/user/bob.yaml": "Policies:\n- Missing\n",
+	})
+
+	code := exitCode(func() {
+		PushAllAccounts(ui, PushCommandInput{Dir: dir, AllAccounts: &AllAccountsInput{Parallelism: 2}})
+	})
+
+	if code != exitCodeError {
+		t.Errorf("Expected exit code %d, got %d", exitCodeError, code)
+	}
+	if calls := backends["111111111111"].Calls(); len(calls) != 1 || calls[0] != "CreateUser" {
+		t.Errorf("Expected alice to be created in the first account, got %v", calls)
+	}
+	if calls := backends["222222222222"].Calls(); len(calls) == 0 || calls[0] != "CreateUser" {
+		t.Errorf("Expected bob to be created in the second account, got %v", calls)
+	}
+	for _, expected := range []string{
+		"111111111111: 1 changes (0 destructive)",
+		"222222222222: Step 2 (attach iam/user bob) failed",
+		"333333333333: No files found for AWS Account ID 333333333333",
+	} {
+		if !bytes.Contains(stdout.Bytes(), []byte(expected)) {
+			t.Errorf("Expected the summary to have %q, got:\n%s", expected, stdout)
+		}
+	}
+}
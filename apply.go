@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/99designs/iamy/iamy"
+)
+
+type ApplyCommandInput struct {
+	PlanFile string
+	Resume   bool
+}
+
+func ApplyCommand(ui Ui, input ApplyCommandInput) {
+	planFile, err := iamy.ReadPlanFile(input.PlanFile)
+	if err != nil {
+		ui.Fatal(err)
+	}
+
+	journalPath := iamy.JournalPath(input.PlanFile)
+	journal, err := iamy.ReadJournal(journalPath)
+	if err != nil {
+		ui.Fatal(err)
+	}
+	if journal != nil && !journal.IsFor(planFile) {
+		ui.Fatalf("Refusing to apply %s: %s records the progress of a different plan", input.PlanFile, journalPath)
+	}
+
+	newFetcher := rollbackFetcher(ui, planFile.Regions, planFile.CredentialsNeeded(), planFile.SSHPublicKeysNeeded())
+	awsData, err := newFetcher().Fetch()
+	if err != nil {
+		ui.Fatal(err)
+	}
+
+	plan := planFile.Plan
+	if input.Resume {
+		if journal == nil {
+			ui.Fatalf("Can't resume %s: no progress recorded in %s", input.PlanFile, journalPath)
+		}
+		if planFile.Account.Id != awsData.Account.Id {
+			ui.Fatalf("Refusing to apply %s: plan is for AWS Account ID %s, but the current account is %s", input.PlanFile, planFile.Account.Id, awsData.Account.Id)
+		}
+
+		// the account has changed by the steps that succeeded, so
+		// check the remaining steps instead of the fingerprint
+		plan = journal.Remaining(plan)
+		if err = iamy.ValidateRemaining(plan, awsData); err != nil {
+			ui.Fatalf("Refusing to resume %s: %s", input.PlanFile, err)
+		}
+		if journal.Failed != nil {
+			ui.Printf("Resuming from step %d, which failed: %s\n", journal.Failed.Step, journal.Failed.Error)
+		}
+	} else {
+		if journal != nil {
+			ui.Fatalf("Refusing to apply %s: it has been partly applied, run with --resume to continue", input.PlanFile)
+		}
+		if err = planFile.Validate(awsData); err != nil {
+			ui.Fatalf("Refusing to apply %s: %s", input.PlanFile, err)
+		}
+		if planFile.IsPending() {
+			ui.Printf("%s was saved from the account before its push, so only the resources it changes have been checked\n", input.PlanFile)
+		}
+		journal = iamy.NewJournal(planFile)
+	}
+
+	if plan.Count() == 0 {
+		ui.Println("Nothing to apply")
+		removeJournal(ui, journalPath)
+		return
+	}
+
+	ui.Println("Commands to push changes to AWS:")
+	printCommands("      ", plan, ui)
+	printWarnings(plan, ui)
+
+	if *dryRun {
+		ui.Println("Dry-run mode not running aws commands")
+		return
+	}
+
+	r, err := prompt(fmt.Sprintf("\nRun %d aws commands (%d destructive)? (y/N) ", plan.Count(), plan.CountDestructive()))
+	if err != nil {
+		ui.Fatal(err)
+	}
+	if r != "y" {
+		ui.Println("Not running aws commands")
+		return
+	}
+
+	rb := newRollback(plan, awsData, nil, newFetcher, false)
+	if err = rb.savePending(); err != nil {
+		ui.Fatal(err)
+	}
+
+	executor := iamy.NewExecutor()
+	var failed *iamy.Step
+	for _, step := range plan.Steps {
+		ui.Println("\n>", step)
+		if err = executor.Exec(step.Cmd); err != nil {
+			failed = step
+			journal.Fail(step, err)
+			writeJournal(ui, journal, journalPath)
+			break
+		}
+		journal.Complete(step)
+		writeJournal(ui, journal, journalPath)
+	}
+	rb.save(ui)
+
+	if failed != nil {
+		ui.Fatalf("Step %d (%s %s %s) failed: %s\nTo continue from this step, run:\n      iamy apply --resume %s", failed.ID, failed.Action, failed.ResourceType, failed.ResourceName, err, input.PlanFile)
+	}
+	removeJournal(ui, journalPath)
+}
+
+func writeJournal(ui Ui, journal *iamy.Journal, path string) {
+	if err := journal.Write(path); err != nil {
+		ui.Fatalf("Error recording progress in %s: %s", path, err)
+	}
+}
+
+func removeJournal(ui Ui, path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		ui.Fatal(err)
+	}
+}
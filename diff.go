@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/99designs/iamy/iamy"
+)
+
+const (
+	exitCodeError   = 1
+	exitCodeChanges = 2
+)
+
+type DiffCommandInput struct {
+	Dir          string
+	SnapshotFile string
+}
+
+// DiffCommand shows the changes push would make, exiting with 0 when there
+// are none, 2 when there are changes and 1 on error
+func DiffCommand(ui Ui, input DiffCommandInput) {
+	defer exitOnPanic(ui)
+
+	yamlData, awsData := loadAndFetch(ui, input.Dir, input.SnapshotFile)
+	if yamlData == nil {
+		ui.Error.Fatal("No files found for AWS Account ID " + awsData.Account.Id)
+	}
+
+	plan, err := iamy.PlanForSync(awsData, yamlData)
+	if err != nil {
+		ui.Error.Fatal(err)
+	}
+	printPlan(awsData.Account, plan, ui)
+
+	if plan.Count() > 0 {
+		ui.Exit(exitCodeChanges)
+	}
+}
+
+// exitOnPanic exits with exitCodeError on a panic, as
+// go uses the same exit code as changes being found
+func exitOnPanic(ui Ui) {
+	if r := recover(); r != nil {
+		ui.Error.Println(r)
+		ui.Exit(exitCodeError)
+	}
+}
@@ -13,6 +13,7 @@ var (
 	Version    string = "dev"
 	defaultDir string
 	dryRun     *bool
+	output     *string
 )
 
 type logWriter struct{ *log.Logger }
@@ -35,10 +36,37 @@ func main() {
 		pullDir   = pull.Flag("dir", "The directory to dump yaml files to").Default(defaultDir).Short('d').String()
 		canDelete = pull.Flag("delete", "Delete extraneous files from destination dir").Bool()
 		lookupCfn = pull.Flag("accurate-cfn", "Fetch all known resource names from cloudformation to get exact filtering").Bool()
+		regions   = pull.Flag("region", "A region to fetch resource policies from, can be repeated. Defaults to the current region").Strings()
+		pullAll   = pull.Flag("all-accounts", "Pull every account in the accounts config").Bool()
+		pullCfg   = pull.Flag("accounts-config", "The accounts config for --all-accounts. Defaults to "+defaultAccountsConfigFile+" in the directory").String()
+		pullOrg   = pull.Flag("org", "Pull every account in the organization, from its management account").Bool()
+		pullRole  = pull.Flag("org-role", "The role to assume in each member account with --org").Default("OrganizationAccountAccessRole").String()
+		pullPar   = pull.Flag("parallel", "The number of accounts to pull at once with --all-accounts or --org").Default("4").Int()
 		push      = kingpin.Command("push", "Syncs IAM users, groups and policies from files to the active AWS account")
 		pushDir   = push.Flag("dir", "The directory to load yaml files from").Default(defaultDir).Short('d').ExistingDir()
+		pushExit  = push.Flag("detailed-exitcode", "With --dry-run, exit with 0 when up to date, 2 when there are changes and 1 on error").Bool()
+		pushAll   = push.Flag("all-accounts", "Push every account in the accounts config").Bool()
+		pushCfg   = push.Flag("accounts-config", "The accounts config for --all-accounts. Defaults to "+defaultAccountsConfigFile+" in the directory").String()
+		pushPar   = push.Flag("parallel", "The number of accounts to fetch at once with --all-accounts").Default("4").Int()
+		pushSnap  = push.Flag("from-snapshot", "Compare with a snapshot instead of fetching from AWS, implies --dry-run").ExistingFile()
+		diff      = kingpin.Command("diff", "Shows the changes push would make, exiting with 0 when up to date, 2 when there are changes and 1 on error")
+		diffDir   = diff.Flag("dir", "The directory to load yaml files from").Default(defaultDir).Short('d').ExistingDir()
+		diffSnap  = diff.Flag("from-snapshot", "Compare with a snapshot instead of fetching from AWS").ExistingFile()
+		verify    = kingpin.Command("verify", "Applies the changes push would make to a simulated copy of the account, and checks none are left, exiting with 0 when they converge, 2 when they don't and 1 on error. Resource policies and Organizations aren't simulated, so changes to them aren't verified. The simulation rewrites account id principals as AWS does, but otherwise keeps policy documents as they're sent, so it doesn't catch other ways AWS rewrites them")
+		verifyDir = verify.Flag("dir", "The directory to load yaml files from").Default(defaultDir).Short('d').ExistingDir()
+		verifySn  = verify.Flag("from-snapshot", "Simulate a snapshot instead of fetching from AWS").ExistingFile()
+		plan      = kingpin.Command("plan", "Saves the commands push would run to a file, to be applied later")
+		planDir   = plan.Flag("dir", "The directory to load yaml files from").Default(defaultDir).Short('d').ExistingDir()
+		planOut   = plan.Flag("out", "The file to save the plan to").Short('o').Required().String()
+		snapshot  = kingpin.Command("snapshot", "Saves the account data fetched from AWS to a file, to push or diff against without AWS")
+		snapOut   = snapshot.Flag("out", "The file to save the snapshot to").Short('o').Required().String()
+		snapRegs  = snapshot.Flag("region", "A region to fetch resource policies from, can be repeated. Defaults to the current region").Strings()
+		apply     = kingpin.Command("apply", "Runs the commands in a saved plan, if the AWS account hasn't changed since")
+		applyFile = apply.Arg("plan", "The plan file to apply").Required().ExistingFile()
+		applyRes  = apply.Flag("resume", "Continue applying a plan from the step that failed, skipping the steps that succeeded").Bool()
 	)
 	dryRun = kingpin.Flag("dry-run", "Show what would happen, but don't prompt to do it").Bool()
+	output = kingpin.Flag("output", "Output format for push --dry-run, pull and verify, text or json").Default(outputText).Enum(outputText, outputJson)
 
 	kingpin.Version(Version)
 	kingpin.CommandLine.Help =
@@ -61,10 +89,59 @@ func main() {
 		log.SetOutput(ioutil.Discard)
 	}
 
+	if *pushSnap != "" {
+		if *pushAll {
+			ui.Error.Fatal("--from-snapshot and --all-accounts can't be used together")
+		}
+		*dryRun = true
+	}
+	if *output == outputJson && cmd == push.FullCommand() && !*dryRun {
+		ui.Error.Fatal("--output json requires --dry-run when pushing")
+	}
+	if *pushExit && !*dryRun {
+		ui.Error.Fatal("--detailed-exitcode requires --dry-run")
+	}
+	if *pullOrg && *pullAll {
+		ui.Error.Fatal("--org and --all-accounts can't be used together")
+	}
+
 	switch cmd {
 	case push.FullCommand():
 		PushCommand(ui, PushCommandInput{
-			Dir: *pushDir,
+			Dir:              *pushDir,
+			DetailedExitCode: *pushExit,
+			AllAccounts:      allAccountsInput(*pushAll, *pushCfg, *pushPar),
+			SnapshotFile:     *pushSnap,
+		})
+
+	case diff.FullCommand():
+		DiffCommand(ui, DiffCommandInput{
+			Dir:          *diffDir,
+			SnapshotFile: *diffSnap,
+		})
+
+	case verify.FullCommand():
+		VerifyCommand(ui, VerifyCommandInput{
+			Dir:          *verifyDir,
+			SnapshotFile: *verifySn,
+		})
+
+	case snapshot.FullCommand():
+		SnapshotCommand(ui, SnapshotCommandInput{
+			OutFile: *snapOut,
+			Regions: *snapRegs,
+		})
+
+	case plan.FullCommand():
+		PlanCommand(ui, PlanCommandInput{
+			Dir:     *planDir,
+			OutFile: *planOut,
+		})
+
+	case apply.FullCommand():
+		ApplyCommand(ui, ApplyCommandInput{
+			PlanFile: *applyFile,
+			Resume:   *applyRes,
 		})
 
 	case pull.FullCommand():
@@ -72,10 +149,32 @@ func main() {
 			Dir:                  *pullDir,
 			CanDelete:            *canDelete,
 			HeuristicCfnMatching: !*lookupCfn,
+			Regions:              *regions,
+			AllAccounts:          pullAccountsInput(*pullAll, *pullCfg, *pullOrg, *pullRole, *pullPar),
 		})
 	}
 }
 
+func pullAccountsInput(all bool, configFile string, org bool, orgRole string, parallelism int) *AllAccountsInput {
+	if org {
+		return &AllAccountsInput{
+			OrgRole:     orgRole,
+			Parallelism: parallelism,
+		}
+	}
+	return allAccountsInput(all, configFile, parallelism)
+}
+
+func allAccountsInput(all bool, configFile string, parallelism int) *AllAccountsInput {
+	if !all {
+		return nil
+	}
+	return &AllAccountsInput{
+		ConfigFile:  configFile,
+		Parallelism: parallelism,
+	}
+}
+
 func init() {
 	dir, err := os.Getwd()
 	if err != nil {
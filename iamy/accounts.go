@@ -0,0 +1,76 @@
+package iamy
+
+import (
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// AccountsConfig lists the accounts to pull and push with --all-accounts
+type AccountsConfig struct {
+	Accounts []AccountConfig `json:"Accounts"`
+}
+
+// An AccountConfig is an account and how to get credentials for it. Profile
+// is an aws config profile to use, and RoleArn a role to assume, from the
+// profile if there is one and the default credentials otherwise
+type AccountConfig struct {
+	Id         string `json:"Id"`
+	Profile    string `json:"Profile,omitempty"`
+	RoleArn    string `json:"RoleArn,omitempty"`
+	ExternalId string `json:"ExternalId,omitempty"`
+}
+
+// ReadAccountsConfig reads an AccountsConfig from a yaml file
+func ReadAccountsConfig(path string) (*AccountsConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c AccountsConfig
+	if err = yaml.Unmarshal(b, &c); err != nil {
+		return nil, errors.Wrapf(err, "Error reading accounts config %s", path)
+	}
+	if len(c.Accounts) == 0 {
+		return nil, errors.Errorf("No accounts in %s", path)
+	}
+	for _, a := range c.Accounts {
+		if !isAccountId(a.Id) {
+			return nil, errors.Errorf("Invalid account id '%s' in %s", a.Id, path)
+		}
+	}
+
+	return &c, nil
+}
+
+// Session creates an AWS session for the account
+func (c AccountConfig) Session() (*session.Session, error) {
+	s := awsSession()
+	if c.Profile != "" {
+		var err error
+		s, err = session.NewSessionWithOptions(session.Options{
+			Profile:           c.Profile,
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error creating a session for profile %s", c.Profile)
+		}
+	}
+
+	if c.RoleArn != "" {
+		creds := stscreds.NewCredentials(s, c.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = "iamy"
+			if c.ExternalId != "" {
+				p.ExternalID = aws.String(c.ExternalId)
+			}
+		})
+		s = s.Copy(aws.NewConfig().WithCredentials(creds))
+	}
+
+	return s, nil
+}
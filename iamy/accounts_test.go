@@ -0,0 +1,28 @@
+package iamy
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadAccountsConfig(t *testing.T) {
+	c, err := ReadAccountsConfig(filepath.Join("testdata", "accounts", "iamy-accounts.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []AccountConfig{
+		{Id: "123456789012", Profile: "prod"},
+		{Id: "210987654321", RoleArn: "arn:aws:iam::210987654321:role/iamy", ExternalId: "example"},
+	}
+	if !reflect.DeepEqual(c.Accounts, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, c.Accounts)
+	}
+}
+
+func TestReadAccountsConfigRejectsInvalidAccountIds(t *testing.T) {
+	if _, err := ReadAccountsConfig(filepath.Join("testdata", "accounts", "invalid-accounts.yaml")); err == nil {
+		t.Error("Expected an error for an invalid account id")
+	}
+}
@@ -7,42 +7,167 @@ import (
 	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/s3control/s3controliface"
 	"github.com/pkg/errors"
 )
 
 // AwsFetcher fetches account data from AWS
 type AwsFetcher struct {
-	// As Policy and Role descriptions are immutable, we can skip fetching them
+	// As Policy descriptions are immutable, we can skip fetching them
 	// when pushing to AWS
-	SkipFetchingPolicyAndRoleDescriptions bool
-	HeuristicCfnMatching                  bool
+	SkipFetchingPolicyDescriptions bool
+	HeuristicCfnMatching           bool
+
+	// Regions are the regions to fetch resource policies from
+	Regions []string
+
+	// Session is the AWS session to fetch with, or nil for the default session
+	Session *session.Session
+
+	// Clients are used instead of the session's clients, where they're set
+	Clients Clients
+
+	// CredentialsNeeded, if set, limits fetching the access keys, MFA devices,
+	// login profile, service specific credentials and ssh public keys of
+	// users, which take several calls for each user, to the users it's true for
+	CredentialsNeeded func(account *Account, u *User) bool
+
+	// SSHPublicKeysNeeded, if set along with CredentialsNeeded, also fetches
+	// just the ssh public keys of the users it's true for
+	SSHPublicKeysNeeded func(account *Account, u *User) bool
 
 	Debug *log.Logger
 
-	iam     *iamClient
-	s3      *s3Client
-	cfn     *cfnClient
-	account *Account
-	data    AccountData
+	iam           *iamClient
+	s3            *s3Client
+	cfn           *cfnClient
+	s3control     s3controliface.S3ControlAPI
+	organizations organizationsiface.OrganizationsAPI
+	regional      *regionalClients
+	account       *Account
+	data          AccountData
+
+	detailFetchWaitGroup sync.WaitGroup
+	detailFetchLimit     chan struct{}
+	detailFetchMutex     sync.Mutex
+	detailFetchError     error
+}
+
+// CredentialsNeededFor is a CredentialsNeeded for pushing the yaml account data
+// in expected, which needs the credentials of users that are deleted or have
+// expectations of their credentials
+func CredentialsNeededFor(expected []AccountData) func(account *Account, u *User) bool {
+	return func(account *Account, u *User) bool {
+		found, expectedUser := findDeclaredUser(expected, account, u)
+		return found && (expectedUser == nil || expectedUser.hasCredentialExpectations())
+	}
+}
+
+// SSHPublicKeysNeededFor is a SSHPublicKeysNeeded for pushing the yaml account
+// data in expected, which needs the ssh public keys of users that declare them
+func SSHPublicKeysNeededFor(expected []AccountData) func(account *Account, u *User) bool {
+	return func(account *Account, u *User) bool {
+		_, expectedUser := findDeclaredUser(expected, account, u)
+		return expectedUser != nil && expectedUser.SSHPublicKeys != nil
+	}
+}
+
+// CredentialsNeededToPull is a CredentialsNeeded for pulling over the yaml
+// account data in declared, which needs the credentials of users whose yaml
+// has expectations of their credentials
+func CredentialsNeededToPull(declared []AccountData) func(account *Account, u *User) bool {
+	return func(account *Account, u *User) bool {
+		_, declaredUser := findDeclaredUser(declared, account, u)
+		return declaredUser != nil && declaredUser.hasCredentialExpectations()
+	}
+}
+
+// findDeclaredUser finds the yaml of user u in the yaml account data in
+// declared. hasAccount is false if declared doesn't have the account, and
+// declaredUser is nil if the account doesn't have the user
+func findDeclaredUser(declared []AccountData, account *Account, u *User) (hasAccount bool, declaredUser *User) {
+	for _, d := range declared {
+		if d.Account.Id != account.Id {
+			continue
+		}
+		_, declaredUser = d.FindUserByName(u.Name, u.Path)
+		return true, declaredUser
+	}
+	return false, nil
+}
+
+// KeepCredentialExpectations sets the expectations of each user's credentials
+// that the yaml account data in declared has to the credentials fetched, so
+// pulling updates the expectations yaml declares without adding any
+func (a *AccountData) KeepCredentialExpectations(declared []AccountData) {
+	for _, u := range a.Users {
+		_, declaredUser := findDeclaredUser(declared, a.Account, u)
+		if declaredUser == nil || u.credentials.SSHPublicKeysOnly {
+			continue
+		}
+		if declaredUser.ConsoleAccess != nil {
+			u.ConsoleAccess = aws.Bool(u.credentials.HasLoginProfile)
+		}
+		if declaredUser.MFARequired {
+			u.MFARequired = len(u.credentials.MFADevices) > 0
+		}
+		if declaredUser.MaxAccessKeys != nil {
+			numAccessKeys := len(u.credentials.AccessKeyIds)
+			u.MaxAccessKeys = &numAccessKeys
+		}
+	}
+}
+
+// maxConcurrentDetailFetches limits the calls fetching the details of each
+// entity that run at once, to stay under the IAM rate limits on large accounts
+const maxConcurrentDetailFetches = 8
+
+// fetchDetailAsync runs fetch in the background, with at most
+// maxConcurrentDetailFetches running at once, keeping the first error
+func (a *AwsFetcher) fetchDetailAsync(fetch func() error) {
+	a.detailFetchWaitGroup.Add(1)
+	go func() {
+		defer a.detailFetchWaitGroup.Done()
+
+		a.detailFetchLimit <- struct{}{}
+		err := fetch()
+		<-a.detailFetchLimit
 
-	descriptionFetchWaitGroup sync.WaitGroup
-	descriptionFetchError     error
+		if err != nil {
+			a.detailFetchMutex.Lock()
+			if a.detailFetchError == nil {
+				a.detailFetchError = err
+			}
+			a.detailFetchMutex.Unlock()
+		}
+	}()
 }
 
 func (a *AwsFetcher) init() error {
 	var err error
 
-	s := awsSession()
-	a.iam = newIamClient(s)
-	a.s3 = newS3Client(s)
-	a.cfn = newCfnClient(s)
+	if a.Session == nil {
+		a.Session = awsSession()
+	}
+
+	s := a.Session
+	a.detailFetchLimit = make(chan struct{}, maxConcurrentDetailFetches)
+	a.iam = a.Clients.iamClient(s)
+	a.s3 = a.Clients.s3Client(s)
+	a.cfn = a.Clients.cfnClient(s)
+	a.s3control = a.Clients.s3ControlClient(s)
+	a.organizations = a.Clients.organizationsClient(s)
+	a.regional = a.Clients.regionalClients(s)
 
 	if a.account, err = a.getAccount(); err != nil {
 		return err
 	}
 	a.data = AccountData{
-		Account: a.account,
+		Account:        a.account,
+		noAccountAlias: a.account.Alias == "",
 	}
 
 	return nil
@@ -62,7 +187,7 @@ func (a *AwsFetcher) Fetch() (*AccountData, error) {
 	}
 
 	var wg sync.WaitGroup
-	var iamErr, s3Err error
+	var iamErr, s3Err, orgErr, regionalErr error
 
 	log.Println("Fetching IAM data")
 	wg.Add(1)
@@ -78,6 +203,23 @@ func (a *AwsFetcher) Fetch() (*AccountData, error) {
 		s3Err = a.fetchS3Data()
 	}()
 
+	log.Println("Fetching Organizations data")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		orgErr = a.fetchOrganizationData()
+	}()
+
+	if len(a.Regions) > 0 {
+		log.Println("Fetching resource policies in", strings.Join(a.Regions, ", "))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.data.ResourcePolicies, regionalErr = fetchResourcePolicies(a.regional, a.Regions)
+		}()
+	}
+	a.data.fetchedRegions = a.Regions
+
 	wg.Wait()
 
 	if iamErr != nil {
@@ -86,32 +228,71 @@ func (a *AwsFetcher) Fetch() (*AccountData, error) {
 	if s3Err != nil {
 		return nil, errors.Wrap(s3Err, "Error fetching S3 data")
 	}
+	if orgErr != nil {
+		return nil, errors.Wrap(orgErr, "Error fetching Organizations data")
+	}
+	if regionalErr != nil {
+		return nil, regionalErr
+	}
 
 	return &a.data, nil
 }
 
+// FetchPolicyDescriptions fetches the descriptions of policies
+// fetched with SkipFetchingPolicyDescriptions
+func (a *AwsFetcher) FetchPolicyDescriptions(policies []*Policy) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	if err := a.init(); err != nil {
+		return errors.Wrap(err, "Error in init")
+	}
+
+	for _, p := range policies {
+		a.marshalPolicyDescriptionAsync(Arn(p, a.account), &p.Description)
+	}
+	a.detailFetchWaitGroup.Wait()
+
+	return errors.Wrap(a.detailFetchError, "Error fetching policy descriptions")
+}
+
 func (a *AwsFetcher) fetchS3Data() error {
+	var err error
+	a.data.PublicAccessBlock, err = getAccountPublicAccessBlock(a.s3control, a.account.Id)
+	if isAccessDenied(err) {
+		log.Printf("Block Public Access settings of account %s are unknown: %s", a.account, err)
+		a.data.publicAccessBlockUnknown = true
+	} else if err != nil {
+		return errors.Wrap(err, "Error fetching account Block Public Access settings")
+	}
+
 	buckets, err := a.s3.listAllBuckets()
 	if err != nil {
 		return errors.Wrap(err, "Error listing buckets")
 	}
 	for _, b := range buckets {
-		if b.policyJson == "" {
+		if b.policyJson == "" && b.publicAccessBlock == nil && !b.publicAccessBlockUnknown {
 			continue
 		}
 		if ok, err := a.isSkippableManagedResource(CfnS3Bucket, b.name); ok {
 			log.Printf(err)
 			continue
 		}
-
-		policyDoc, err := NewPolicyDocumentFromJson(b.policyJson)
-		if err != nil {
-			return errors.Wrap(err, "Error creating Policy document")
+		if b.publicAccessBlockUnknown {
+			a.data.unknownBucketPublicAccessBlocks = append(a.data.unknownBucketPublicAccessBlocks, b.name)
+			if b.policyJson == "" {
+				continue
+			}
 		}
 
 		bp := BucketPolicy{
-			BucketName: b.name,
-			Policy:     policyDoc,
+			BucketName:        b.name,
+			PublicAccessBlock: b.publicAccessBlock,
+		}
+		if b.policyJson != "" {
+			if bp.Policy, err = NewPolicyDocumentFromJson(b.policyJson); err != nil {
+				return errors.Wrap(err, "Error creating Policy document")
+			}
 		}
 
 		a.data.BucketPolicies = append(a.data.BucketPolicies, &bp)
@@ -120,6 +301,19 @@ func (a *AwsFetcher) fetchS3Data() error {
 	return nil
 }
 
+func (a *AwsFetcher) fetchOrganizationData() error {
+	org, err := fetchOrganizationData(a.organizations, a.account.Id)
+	if err != nil || org == nil {
+		return err
+	}
+
+	a.data.organizationRootId = org.rootId
+	a.data.OrganizationalUnits = org.units
+	a.data.ServiceControlPolicies = org.policies
+
+	return nil
+}
+
 func (a *AwsFetcher) fetchIamData() error {
 	var populateIamDataErr error
 	var populateInstanceProfileErr error
@@ -161,9 +355,90 @@ func (a *AwsFetcher) fetchIamData() error {
 	if err != nil {
 		return err
 	}
+
+	if err = a.fetchIdentityProviders(); err != nil {
+		return err
+	}
+
+	if a.data.PasswordPolicy, err = a.iam.getAccountPasswordPolicy(); err != nil {
+		return errors.Wrap(err, "Error fetching account password policy")
+	}
+	a.data.noPasswordPolicy = a.data.PasswordPolicy == nil
+
+	a.detailFetchWaitGroup.Wait()
+
+	return a.detailFetchError
+}
+
+func (a *AwsFetcher) fetchIdentityProviders() error {
+	samlResp, err := a.iam.ListSAMLProviders(&iam.ListSAMLProvidersInput{})
+	if err != nil {
+		return errors.Wrap(err, "Error listing SAML providers")
+	}
+	for _, p := range samlResp.SAMLProviderList {
+		provider := SamlProvider{iamService: iamService{
+			Name: arnResourceName(*p.Arn, "saml-provider/"),
+			Path: "/",
+		}}
+		a.marshalSamlProviderAsync(*p.Arn, &provider)
+		a.data.addSamlProvider(&provider)
+	}
+
+	oidcResp, err := a.iam.ListOpenIDConnectProviders(&iam.ListOpenIDConnectProvidersInput{})
+	if err != nil {
+		return errors.Wrap(err, "Error listing OIDC providers")
+	}
+	for _, p := range oidcResp.OpenIDConnectProviderList {
+		provider := OidcProvider{
+			iamService: oidcProviderNameAndPath(arnResourceName(*p.Arn, "oidc-provider/")),
+		}
+		a.marshalOidcProviderAsync(*p.Arn, &provider)
+		a.data.addOidcProvider(&provider)
+	}
+
 	return nil
 }
 
+// arnResourceName is the part of arn following resourceType
+func arnResourceName(arn, resourceType string) string {
+	i := strings.Index(arn, resourceType)
+	if i == -1 {
+		return arn
+	}
+	return arn[i+len(resourceType):]
+}
+
+func (a *AwsFetcher) marshalSamlProviderAsync(arn string, target *SamlProvider) {
+	a.fetchDetailAsync(func() error {
+		log.Println("Fetching SAML provider", arn)
+
+		resp, err := a.iam.getSamlProvider(arn)
+		if err != nil {
+			return err
+		}
+		target.SAMLMetadataDocument = aws.StringValue(resp.SAMLMetadataDocument)
+		target.Tags = map[string]string{}
+		addTagsToMap(resp.Tags, target.Tags)
+		return nil
+	})
+}
+
+func (a *AwsFetcher) marshalOidcProviderAsync(arn string, target *OidcProvider) {
+	a.fetchDetailAsync(func() error {
+		log.Println("Fetching OIDC provider", arn)
+
+		resp, err := a.iam.getOidcProvider(arn)
+		if err != nil {
+			return err
+		}
+		target.ClientIDList = aws.StringValueSlice(resp.ClientIDList)
+		target.ThumbprintList = aws.StringValueSlice(resp.ThumbprintList)
+		target.Tags = map[string]string{}
+		addTagsToMap(resp.Tags, target.Tags)
+		return nil
+	})
+}
+
 func (a *AwsFetcher) populateInlinePolicies(source []*iam.PolicyDetail, target *[]InlinePolicy) error {
 	for _, ip := range source {
 		doc, err := NewPolicyDocumentFromEncodedJson(*ip.PolicyDocument)
@@ -180,31 +455,79 @@ func (a *AwsFetcher) populateInlinePolicies(source []*iam.PolicyDetail, target *
 }
 
 func (a *AwsFetcher) marshalPolicyDescriptionAsync(policyArn string, target *string) {
-	a.descriptionFetchWaitGroup.Add(1)
-	go func() {
-		defer a.descriptionFetchWaitGroup.Done()
+	a.fetchDetailAsync(func() (err error) {
 		log.Println("Fetching policy description for", policyArn)
 
-		var err error
 		*target, err = a.iam.getPolicyDescription(policyArn)
+		return err
+	})
+}
+
+// marshalRoleDetailsAsync fetches the role fields that
+// GetAccountAuthorizationDetails doesn't include
+func (a *AwsFetcher) marshalRoleDetailsAsync(role *Role) {
+	a.fetchDetailAsync(func() error {
+		log.Println("Fetching role details for", role.Name)
+
+		r, err := a.iam.getRole(role.Name)
 		if err != nil {
-			a.descriptionFetchError = err
+			return err
 		}
-	}()
+		if r.Description != nil {
+			role.Description = *r.Description
+		}
+		if r.MaxSessionDuration != nil && *r.MaxSessionDuration != DefaultMaxSessionDuration {
+			role.MaxSessionDuration = *r.MaxSessionDuration
+		}
+		return nil
+	})
 }
 
-func (a *AwsFetcher) marshalRoleDescriptionAsync(roleName string, target *string) {
-	a.descriptionFetchWaitGroup.Add(1)
-	go func() {
-		defer a.descriptionFetchWaitGroup.Done()
-		log.Println("Fetching role description for", roleName)
+// marshalUserCredentialsAsync fetches the state of a user's credentials, and
+// records the user's ssh public keys. Only the ssh public keys are fetched
+// unless CredentialsNeeded is true for the user, and nothing unless
+// SSHPublicKeysNeeded is either
+func (a *AwsFetcher) marshalUserCredentialsAsync(user *User) {
+	all := a.CredentialsNeeded == nil || a.CredentialsNeeded(a.account, user)
+	sshPublicKeys := a.SSHPublicKeysNeeded == nil || a.SSHPublicKeysNeeded(a.account, user)
+	if !all && !sshPublicKeys {
+		user.credentials = userCredentials{SSHPublicKeysOnly: true, SSHPublicKeysSkipped: true}
+		return
+	}
 
-		var err error
-		*target, err = a.iam.getRoleDescription(roleName)
+	a.fetchDetailAsync(func() error {
+		log.Println("Fetching credentials for", user.Name)
+
+		creds, err := a.iam.getUserCredentials(user.Name, all, sshPublicKeys)
 		if err != nil {
-			a.descriptionFetchError = err
+			return err
 		}
-	}()
+
+		user.credentials = creds
+		if !creds.SSHPublicKeysSkipped {
+			keys := sortedKeys(creds.SSHPublicKeyIds)
+			user.SSHPublicKeys = &keys
+		}
+		return nil
+	})
+}
+
+func (a *AwsFetcher) marshalPolicyTagsAsync(policyArn string, target *map[string]string) {
+	a.fetchDetailAsync(func() (err error) {
+		log.Println("Fetching policy tags for", policyArn)
+
+		*target, err = a.iam.getPolicyTags(policyArn)
+		return err
+	})
+}
+
+func (a *AwsFetcher) marshalInstanceProfileTagsAsync(name string, target *map[string]string) {
+	a.fetchDetailAsync(func() (err error) {
+		log.Println("Fetching instance profile tags for", name)
+
+		*target, err = a.iam.getInstanceProfileTags(name)
+		return err
+	})
 }
 
 func (a *AwsFetcher) populateInstanceProfileData(resp *iam.ListInstanceProfilesOutput) error {
@@ -222,6 +545,7 @@ func (a *AwsFetcher) populateInstanceProfileData(resp *iam.ListInstanceProfilesO
 			role := *(roleResp.RoleName)
 			profile.Roles = append(profile.Roles, role)
 		}
+		a.marshalInstanceProfileTagsAsync(profile.Name, &profile.Tags)
 		a.data.InstanceProfiles = append(a.data.InstanceProfiles, &profile)
 	}
 	return nil
@@ -251,9 +575,10 @@ func (a *AwsFetcher) populateIamData(resp *iam.GetAccountAuthorizationDetailsOut
 		if err := a.populateInlinePolicies(userResp.UserPolicyList, &user.InlinePolicies); err != nil {
 			return err
 		}
-		for _, t := range userResp.Tags {
-			user.Tags[*t.Key] = *t.Value
-		}
+		addTagsToMap(userResp.Tags, user.Tags)
+		user.PermissionsBoundary = a.permissionsBoundary(userResp.PermissionsBoundary)
+
+		a.marshalUserCredentialsAsync(&user)
 
 		a.data.Users = append(a.data.Users, &user)
 	}
@@ -285,14 +610,17 @@ func (a *AwsFetcher) populateIamData(resp *iam.GetAccountAuthorizationDetailsOut
 			continue
 		}
 
-		role := Role{iamService: iamService{
-			Name: *roleResp.RoleName,
-			Path: *roleResp.Path,
-		}}
-
-		if !a.SkipFetchingPolicyAndRoleDescriptions {
-			a.marshalRoleDescriptionAsync(*roleResp.RoleName, &role.Description)
+		role := Role{
+			iamService: iamService{
+				Name: *roleResp.RoleName,
+				Path: *roleResp.Path,
+			},
+			Tags: make(map[string]string),
 		}
+		addTagsToMap(roleResp.Tags, role.Tags)
+		role.PermissionsBoundary = a.permissionsBoundary(roleResp.PermissionsBoundary)
+
+		a.marshalRoleDetailsAsync(&role)
 
 		var err error
 		role.AssumeRolePolicyDocument, err = NewPolicyDocumentFromEncodedJson(*roleResp.AssumeRolePolicyDocument)
@@ -329,19 +657,28 @@ func (a *AwsFetcher) populateIamData(resp *iam.GetAccountAuthorizationDetailsOut
 			oldestVersionId:      findOldestPolicyVersionId(policyResp.PolicyVersionList),
 			numberOfVersions:     len(policyResp.PolicyVersionList),
 			nondefaultVersionIds: findNonDefaultPolicyVersionIds(policyResp.PolicyVersionList),
+			defaultVersionId:     *defaultPolicyVersion.VersionId,
 			Policy:               doc,
 		}
 
-		if !a.SkipFetchingPolicyAndRoleDescriptions {
+		if !a.SkipFetchingPolicyDescriptions {
 			a.marshalPolicyDescriptionAsync(*policyResp.Arn, &p.Description)
 		}
+		a.marshalPolicyTagsAsync(*policyResp.Arn, &p.Tags)
 
 		a.data.addPolicy(&p)
 	}
 
-	a.descriptionFetchWaitGroup.Wait()
+	a.detailFetchWaitGroup.Wait()
+
+	return a.detailFetchError
+}
 
-	return a.descriptionFetchError
+func (a *AwsFetcher) permissionsBoundary(pb *iam.AttachedPermissionsBoundary) string {
+	if pb == nil || pb.PermissionsBoundaryArn == nil {
+		return ""
+	}
+	return a.account.normalisePolicyArn(*pb.PermissionsBoundaryArn)
 }
 
 func findDefaultPolicyVersion(versions []*iam.PolicyVersion) *iam.PolicyVersion {
@@ -377,7 +714,7 @@ func (a *AwsFetcher) getAccount() (*Account, error) {
 	var err error
 	acct := Account{}
 
-	acct.Id, err = GetAwsAccountId(awsSession(), a.Debug)
+	acct.Id, err = a.Clients.accountId(a.Session, a.Debug)
 	if err == aws.ErrMissingRegion {
 		return nil, errors.New("Error determining the AWS account id - check the AWS_REGION environment variable is set")
 	}
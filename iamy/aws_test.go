@@ -1,7 +1,16 @@
 package iamy
 
 import (
+	"reflect"
 	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"github.com/aws/aws-sdk-go/service/s3control/s3controliface"
 )
 
 func TestIsSkippableManagedResource(t *testing.T) {
@@ -47,3 +56,193 @@ func TestIsSkippableManagedResource(t *testing.T) {
 		})
 	}
 }
+
+func TestCredentialsAreOnlyFetchedWhenNeeded(t *testing.T) {
+	_, c := newRoundTripBackend(t)
+	for _, name := range []string{"bob", "carol"} {
+		if _, err := c.IAM.CreateUser(&iam.CreateUserInput{UserName: aws.String(name)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.IAM.CreateAccessKey(&iam.CreateAccessKeyInput{UserName: aws.String(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// alice has expectations, bob has none and carol isn't in yaml, so is deleted
+	expected := AccountData{
+		Account: &Account{Id: "123456789012"},
+		Users: []*User{
+			{iamService: iamService{Name: "alice", Path: "/staff/"}, ConsoleAccess: aws.Bool(false)},
+			{iamService: iamService{Name: "bob", Path: "/"}},
+		},
+	}
+	f := AwsFetcher{Clients: c, CredentialsNeeded: CredentialsNeededFor([]AccountData{expected})}
+	data, err := f.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, path string
+		needed     bool
+	}{
+		{"alice", "/staff/", true},
+		{"bob", "/", false},
+		{"carol", "/", true},
+	} {
+		_, u := data.FindUserByName(tt.name, tt.path)
+		if u.credentials.SSHPublicKeysOnly == tt.needed {
+			t.Errorf("Expected fetching all credentials of %s to be %t", tt.name, tt.needed)
+		}
+		if !tt.needed && (u.ConsoleAccess != nil || u.MaxAccessKeys != nil) {
+			t.Errorf("Expected no expectations of %s's credentials", tt.name)
+		}
+	}
+
+	if _, carol := data.FindUserByName("carol", "/"); len(carol.credentials.AccessKeyIds) != 1 {
+		t.Error("Expected carol's access key to be fetched, to delete it")
+	}
+}
+
+func TestSSHPublicKeysAreOnlyFetchedWhenDeclared(t *testing.T) {
+	_, c := newRoundTripBackend(t)
+	for _, name := range []string{"bob", "carol"} {
+		if _, err := c.IAM.CreateUser(&iam.CreateUserInput{UserName: aws.String(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if _, err := c.IAM.UploadSSHPublicKey(&iam.UploadSSHPublicKeyInput{UserName: aws.String(name), SSHPublicKeyBody: aws.String("ssh-ed25519 AAAA" + name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// alice declares her keys, bob doesn't and carol isn't in yaml, so is deleted
+	expected := []AccountData{{
+		Account: &Account{Id: "123456789012"},
+		Users: []*User{
+			{iamService: iamService{Name: "alice", Path: "/staff/"}, SSHPublicKeys: &[]string{}},
+			{iamService: iamService{Name: "bob", Path: "/"}},
+		},
+	}}
+	f := AwsFetcher{Clients: c, CredentialsNeeded: CredentialsNeededFor(expected), SSHPublicKeysNeeded: SSHPublicKeysNeededFor(expected)}
+	data, err := f.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, path string
+		keys       *[]string
+	}{
+		{"alice", "/staff/", &[]string{"ssh-ed25519 AAAAalice"}},
+		{"bob", "/", nil},
+		{"carol", "/", &[]string{"ssh-ed25519 AAAAcarol"}},
+	} {
+		_, u := data.FindUserByName(tt.name, tt.path)
+		if !reflect.DeepEqual(u.SSHPublicKeys, tt.keys) {
+			t.Errorf("Expected ssh public keys %v for %s, got %v", tt.keys, tt.name, u.SSHPublicKeys)
+		}
+	}
+	if expected := []string{"alice", "carol"}; !reflect.DeepEqual(data.sshPublicKeyUsers(), expected) {
+		t.Errorf("Expected ssh public keys of %v to be fetched, got %v", expected, data.sshPublicKeyUsers())
+	}
+}
+
+func TestPullKeepsDeclaredCredentialExpectations(t *testing.T) {
+	_, c := newRoundTripBackend(t)
+	seed := []error{}
+	add := func(_ interface{}, err error) { seed = append(seed, err) }
+	for _, name := range []string{"bob", "carol"} {
+		add(c.IAM.CreateUser(&iam.CreateUserInput{UserName: aws.String(name)}))
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		add(c.IAM.CreateLoginProfile(&iam.CreateLoginProfileInput{UserName: aws.String(name), Password: aws.String("secret")}))
+		add(c.IAM.CreateAccessKey(&iam.CreateAccessKeyInput{UserName: aws.String(name)}))
+	}
+	for _, err := range seed {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// alice declares expectations, bob doesn't and carol isn't in yaml yet
+	noKeys := 0
+	declared := []AccountData{{
+		Account: &Account{Id: "123456789012"},
+		Users: []*User{
+			{iamService: iamService{Name: "alice", Path: "/staff/"}, ConsoleAccess: aws.Bool(false), MaxAccessKeys: &noKeys},
+			{iamService: iamService{Name: "bob", Path: "/"}},
+		},
+	}}
+	f := AwsFetcher{Clients: c, CredentialsNeeded: CredentialsNeededToPull(declared), SSHPublicKeysNeeded: SSHPublicKeysNeededFor(declared)}
+	data, err := f.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.KeepCredentialExpectations(declared)
+
+	oneKey := 1
+	for _, tt := range []struct {
+		name, path    string
+		consoleAccess *bool
+		maxAccessKeys *int
+	}{
+		{"alice", "/staff/", aws.Bool(true), &oneKey},
+		{"bob", "/", nil, nil},
+		{"carol", "/", nil, nil},
+	} {
+		_, u := data.FindUserByName(tt.name, tt.path)
+		if !reflect.DeepEqual(u.ConsoleAccess, tt.consoleAccess) || !reflect.DeepEqual(u.MaxAccessKeys, tt.maxAccessKeys) || u.MFARequired {
+			t.Errorf("Unexpected expectations of %s: ConsoleAccess %v, MaxAccessKeys %v, MFARequired %t", tt.name, u.ConsoleAccess, u.MaxAccessKeys, u.MFARequired)
+		}
+		if expected := tt.name == "alice"; u.credentials.SSHPublicKeysOnly == expected {
+			t.Errorf("Expected fetching all credentials of %s to be %t", tt.name, expected)
+		}
+	}
+}
+
+// deniedBucketPublicAccessBlocks and deniedAccountPublicAccessBlock deny
+// reading Block Public Access settings
+type deniedBucketPublicAccessBlocks struct {
+	s3iface.S3API
+}
+
+func (deniedBucketPublicAccessBlocks) GetPublicAccessBlock(*s3.GetPublicAccessBlockInput) (*s3.GetPublicAccessBlockOutput, error) {
+	return nil, awserr.New(accessDeniedErrCode, "Access Denied", nil)
+}
+
+type deniedAccountPublicAccessBlock struct {
+	s3controliface.S3ControlAPI
+}
+
+func (deniedAccountPublicAccessBlock) GetPublicAccessBlock(*s3control.GetPublicAccessBlockInput) (*s3control.GetPublicAccessBlockOutput, error) {
+	return nil, awserr.New(accessDeniedErrCode, "Access Denied", nil)
+}
+
+func TestAccessDeniedPublicAccessBlocksAreUnknown(t *testing.T) {
+	_, c := newRoundTripBackend(t)
+	if _, err := c.S3.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("public-bucket")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.S3.PutBucketPolicy(&s3.PutBucketPolicyInput{Bucket: aws.String("public-bucket"), Policy: aws.String(roundTripPolicy)}); err != nil {
+		t.Fatal(err)
+	}
+	c.S3 = deniedBucketPublicAccessBlocks{c.S3}
+	c.S3Control = deniedAccountPublicAccessBlock{c.S3Control}
+
+	data := fetchRoundTrip(t, c)
+
+	if !data.publicAccessBlockUnknown || data.PublicAccessBlock != nil {
+		t.Errorf("Expected the account's Block Public Access settings to be unknown, got %#v", data.PublicAccessBlock)
+	}
+	if expected := []string{"example-bucket", "public-bucket"}; !reflect.DeepEqual(data.unknownBucketPublicAccessBlocks, expected) {
+		t.Errorf("Expected unknown buckets %#v, got %#v", expected, data.unknownBucketPublicAccessBlocks)
+	}
+	if found, _ := data.FindBucketPolicyByBucketName("example-bucket"); found {
+		t.Error("Expected example-bucket without a policy to be left out")
+	}
+	if found, bp := data.FindBucketPolicyByBucketName("public-bucket"); !found || bp.Policy == nil {
+		t.Error("Expected the policy of public-bucket to be fetched")
+	}
+}
@@ -3,16 +3,30 @@ package iamy
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"github.com/pkg/errors"
 )
 
 // MaxAllowedPolicyVersions are the number of Versions of a managed policy that can be stored
 // See http://docs.aws.amazon.com/IAM/latest/UserGuide/reference_iam-limits.html
 const MaxAllowedPolicyVersions = 5
 
+// A Cmd is a step's change to AWS. Input is the SDK input of the Operation of
+// Service, which is what's executed, and Name and Args describe it as an aws
+// cli command for display
 type Cmd struct {
-	Name string
-	Args []string
+	Name      string
+	Args      []string
+	Service   string      `json:",omitempty"`
+	Region    string      `json:",omitempty"`
+	Operation string      `json:",omitempty"`
+	Input     interface{} `json:",omitempty"`
 }
 
 func (c Cmd) String() string {
@@ -30,21 +44,30 @@ func (c Cmd) String() string {
 	return strings.Join(parts, " ")
 }
 
-// IsDestructive indicates if the aws command is destructive
-func (c Cmd) IsDestructive() bool {
-	if len(c.Args) >= 2 {
-		a := c.Args[1]
-		if strings.HasPrefix(a, "de") || strings.HasPrefix(a, "remove") {
-			return true
+// Abbreviated renders the command with any json document arguments elided
+func (c Cmd) Abbreviated() string {
+	args := []string{}
+	for _, a := range c.Args {
+		if strings.HasPrefix(a, "{") {
+			a = "<document>"
 		}
+		args = append(args, a)
 	}
-	return false
+	return Cmd{Name: c.Name, Args: args}.String()
+}
+
+// operation is the service and operation of the command, eg "iam create-user"
+func (c Cmd) operation() string {
+	if len(c.Args) < 2 {
+		return c.Name
+	}
+	return strings.Join(c.Args[:2], " ")
 }
 
 type CmdList []Cmd
 
 func (cc *CmdList) Add(name string, args ...string) {
-	*cc = append(*cc, Cmd{name, args})
+	*cc = append(*cc, Cmd{Name: name, Args: args})
 }
 
 func (cc CmdList) String() string {
@@ -60,16 +83,6 @@ func (cc CmdList) Count() int {
 	return len(cc)
 }
 
-func (cc CmdList) CountDestructive() int {
-	count := 0
-	for _, c := range cc {
-		if c.IsDestructive() {
-			count++
-		}
-	}
-	return count
-}
-
 func path(v string) string {
 	if v == "" {
 		return "/"
@@ -78,28 +91,94 @@ func path(v string) string {
 	return v
 }
 
-func mapTagsToString(tags map[string]string) string {
-	var result []string
-	for k, v := range tags {
-		result = append(result, "Key="+k+",Value="+v)
+// iamTags are the tags for iam operations, sorted by key
+func iamTags(tags map[string]string) []*iam.Tag {
+	result := []*iam.Tag{}
+	for _, k := range sortedKeys(tags) {
+		result = append(result, &iam.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return result
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedValues(m map[string]string) []string {
+	values := []string{}
+	for _, k := range sortedKeys(m) {
+		values = append(values, m[k])
+	}
+	return values
+}
+
+// withTags appends the --tags arg to args when there are tags
+func withTags(args []interface{}, tags map[string]string) []interface{} {
+	if len(tags) == 0 {
+		return args
+	}
+	return append(args, "--tags", iamTags(tags))
+}
+
+// cliEntityNames are the names the aws cli uses for resource types
+// where they differ from the name of the resource type
+var cliEntityNames = map[string]string{
+	"oidc-provider": "open-id-connect-provider",
+}
+
+func cliEntityName(r AwsResource) string {
+	if name, ok := cliEntityNames[r.ResourceType()]; ok {
+		return name
 	}
-	return strings.Join(result, ",")
+	return r.ResourceType()
 }
 
 type awsSyncCmdGenerator struct {
 	from, to *AccountData
-	cmds     CmdList
+	plan     Plan
+	// err is the first step that couldn't be added
+	err error
+	// rollback restores the default version a policy had in to, which is kept
+	// when the policy is updated, rather than creating a new version
+	rollback bool
 }
 
-func (a *awsSyncCmdGenerator) deleteOldEntities() {
-	iam := newIamClient(awsSession())
+// add appends a step to the plan, with args being the aws cli service,
+// operation and option pairs of newCmd
+func (a *awsSyncCmdGenerator) add(action Action, r AwsResource, target string, before, after interface{}, args ...interface{}) {
+	cmd, err := newCmd(args[0].(string), args[1].(string), args[2:]...)
+	if err != nil {
+		if a.err == nil {
+			a.err = errors.Wrapf(err, "Error planning %s %s %s", action, ResourceTypeOf(r), r.ResourceName())
+		}
+		return
+	}
+	a.plan.add(&Step{
+		Action:       action,
+		ResourceType: ResourceTypeOf(r),
+		ResourceName: r.ResourceName(),
+		ResourcePath: r.ResourcePath(),
+		Target:       target,
+		Before:       before,
+		After:        after,
+		Cmd:          cmd,
+	})
+}
 
+func (a *awsSyncCmdGenerator) deleteOldEntities() {
 	for _, fromInstanceProfile := range a.from.InstanceProfiles {
 		if found, _ := a.to.FindInstanceProfileByName(fromInstanceProfile.Name, fromInstanceProfile.Path); !found {
 			for _, roleName := range fromInstanceProfile.Roles {
-				a.cmds.Add("aws", "iam", "remove-role-from-instance-profile", "--instance-profile-name", fromInstanceProfile.Name, "--role-name", roleName)
+				a.add(ActionDetach, fromInstanceProfile, roleName, nil, nil,
+					"iam", "remove-role-from-instance-profile", "--instance-profile-name", fromInstanceProfile.Name, "--role-name", roleName)
 			}
-			a.cmds.Add("aws", "iam", "delete-instance-profile",
+			a.add(ActionDelete, fromInstanceProfile, "", fromInstanceProfile, nil,
+				"iam", "delete-instance-profile",
 				"--instance-profile-name", fromInstanceProfile.Name)
 		}
 	}
@@ -107,69 +186,97 @@ func (a *awsSyncCmdGenerator) deleteOldEntities() {
 		if found, _ := a.to.FindRoleByName(fromRole.Name, fromRole.Path); !found {
 			// detach managed policies
 			for _, p := range fromRole.Policies {
-				a.cmds.Add("aws", "iam", "detach-role-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionDetach, fromRole, policyArn, nil, nil,
+					"iam", "detach-role-policy",
 					"--role-name", fromRole.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
 			// remove inline policies
 			for _, ip := range fromRole.InlinePolicies {
-				a.cmds.Add("aws", "iam", "delete-role-policy",
+				a.add(ActionDelete, fromRole, ip.Name, ip.Policy, nil,
+					"iam", "delete-role-policy",
 					"--role-name", fromRole.Name,
 					"--policy-name", ip.Name)
 			}
 			// remove role
-			a.cmds.Add("aws", "iam", "delete-role",
+			a.add(ActionDelete, fromRole, "", fromRole, nil,
+				"iam", "delete-role",
 				"--role-name", fromRole.Name)
 		}
 	}
 	for _, fromUser := range a.from.Users {
 		if found, _ := a.to.FindUserByName(fromUser.Name, fromUser.Path); !found {
 			// remove access keys
-			accessKeys, mfaDevices, hasLoginProfile := iam.MustGetSecurityCredsForUser(fromUser.Name)
-			for _, keyId := range accessKeys {
-				a.cmds.Add("aws", "iam", "delete-access-key",
+			for _, keyId := range fromUser.credentials.AccessKeyIds {
+				a.add(ActionDelete, fromUser, keyId, nil, nil,
+					"iam", "delete-access-key",
 					"--user-name", fromUser.Name,
 					"--access-key-id", keyId)
 			}
 
 			// remove mfa devices
-			for _, mfaId := range mfaDevices {
-				a.cmds.Add("aws", "iam", "deactivate-mfa-device",
+			for _, mfaId := range fromUser.credentials.MFADevices {
+				a.add(ActionDetach, fromUser, mfaId, nil, nil,
+					"iam", "deactivate-mfa-device",
 					"--user-name", fromUser.Name,
 					"--serial-number", mfaId)
-				a.cmds.Add("aws", "iam", "delete-virtual-mfa-device",
+				a.add(ActionDelete, fromUser, mfaId, nil, nil,
+					"iam", "delete-virtual-mfa-device",
 					"--serial-number", mfaId)
 			}
 
+			// remove ssh public keys
+			for _, keyId := range sortedValues(fromUser.credentials.SSHPublicKeyIds) {
+				a.add(ActionDelete, fromUser, keyId, nil, nil,
+					"iam", "delete-ssh-public-key",
+					"--user-name", fromUser.Name,
+					"--ssh-public-key-id", keyId)
+			}
+
+			// remove service specific credentials
+			for _, credId := range fromUser.credentials.ServiceSpecificCredentialIds {
+				a.add(ActionDelete, fromUser, credId, nil, nil,
+					"iam", "delete-service-specific-credential",
+					"--user-name", fromUser.Name,
+					"--service-specific-credential-id", credId)
+			}
+
 			// remove password
-			if hasLoginProfile {
-				a.cmds.Add("aws", "iam", "delete-login-profile",
+			if fromUser.credentials.HasLoginProfile {
+				a.add(ActionDelete, fromUser, "login-profile", nil, nil,
+					"iam", "delete-login-profile",
 					"--user-name", fromUser.Name)
 			}
 
 			// remove from groups
 			for _, g := range fromUser.Groups {
-				a.cmds.Add("aws", "iam", "remove-user-from-group",
+				a.add(ActionDetach, fromUser, g, nil, nil,
+					"iam", "remove-user-from-group",
 					"--user-name", fromUser.Name,
 					"--group-name", g)
 			}
 
 			// detach managed policies
 			for _, p := range fromUser.Policies {
-				a.cmds.Add("aws", "iam", "detach-user-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionDetach, fromUser, policyArn, nil, nil,
+					"iam", "detach-user-policy",
 					"--user-name", fromUser.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
 
 			// remove inline policies
 			for _, ip := range fromUser.InlinePolicies {
-				a.cmds.Add("aws", "iam", "delete-user-policy",
+				a.add(ActionDelete, fromUser, ip.Name, ip.Policy, nil,
+					"iam", "delete-user-policy",
 					"--user-name", fromUser.Name,
 					"--policy-name", ip.Name)
 			}
 
 			// remove user
-			a.cmds.Add("aws", "iam", "delete-user",
+			a.add(ActionDelete, fromUser, "", fromUser, nil,
+				"iam", "delete-user",
 				"--user-name", fromUser.Name)
 		}
 	}
@@ -177,32 +284,53 @@ func (a *awsSyncCmdGenerator) deleteOldEntities() {
 		if found, _ := a.to.FindGroupByName(fromGroup.Name, fromGroup.Path); !found {
 			// detach managed policies
 			for _, p := range fromGroup.Policies {
-				a.cmds.Add("aws", "iam", "detach-group-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionDetach, fromGroup, policyArn, nil, nil,
+					"iam", "detach-group-policy",
 					"--group-name", fromGroup.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
 			// remove inline policies
 			for _, ip := range fromGroup.InlinePolicies {
-				a.cmds.Add("aws", "iam", "delete-group-policy",
+				a.add(ActionDelete, fromGroup, ip.Name, ip.Policy, nil,
+					"iam", "delete-group-policy",
 					"--group-name", fromGroup.Name,
 					"--policy-name", ip.Name)
 			}
 			// remove group
-			a.cmds.Add("aws", "iam", "delete-group",
+			a.add(ActionDelete, fromGroup, "", fromGroup, nil,
+				"iam", "delete-group",
 				"--group-name", fromGroup.Name)
 		}
 	}
 	for _, fromPolicy := range a.from.Policies {
 		if found, _ := a.to.FindPolicyByName(fromPolicy.Name, fromPolicy.Path); !found {
 			for _, v := range fromPolicy.nondefaultVersionIds {
-				a.cmds.Add("aws", "iam", "delete-policy-version",
+				a.add(ActionDelete, fromPolicy, v, nil, nil,
+					"iam", "delete-policy-version",
 					"--version-id", v,
 					"--policy-arn", Arn(fromPolicy, a.to.Account))
 			}
-			a.cmds.Add("aws", "iam", "delete-policy",
+			a.add(ActionDelete, fromPolicy, "", fromPolicy, nil,
+				"iam", "delete-policy",
 				"--policy-arn", Arn(fromPolicy, a.to.Account))
 		}
 	}
+	// identity providers are removed after the roles that trust them
+	for _, fromProvider := range a.from.SamlProviders {
+		if found, _ := a.to.FindSamlProviderByName(fromProvider.Name, fromProvider.Path); !found {
+			a.add(ActionDelete, fromProvider, "", fromProvider, nil,
+				"iam", "delete-saml-provider",
+				"--saml-provider-arn", Arn(fromProvider, a.to.Account))
+		}
+	}
+	for _, fromProvider := range a.from.OidcProviders {
+		if found, _ := a.to.FindOidcProviderByName(fromProvider.Name, fromProvider.Path); !found {
+			a.add(ActionDelete, fromProvider, "", fromProvider, nil,
+				"iam", "delete-open-id-connect-provider",
+				"--open-id-connect-provider-arn", Arn(fromProvider, a.to.Account))
+		}
+	}
 }
 
 func (a *awsSyncCmdGenerator) updatePolicies() {
@@ -210,23 +338,32 @@ func (a *awsSyncCmdGenerator) updatePolicies() {
 	for _, toPolicy := range a.to.Policies {
 		if found, fromPolicy := a.from.FindPolicyByName(toPolicy.Name, toPolicy.Path); found {
 			// Update policy
-			if fromPolicy.Policy.JsonString() != toPolicy.Policy.JsonString() {
+			if fromPolicy.Policy.JsonString() != toPolicy.Policy.JsonString() && a.rollback && toPolicy.defaultVersionId != "" {
+				a.add(ActionUpdate, toPolicy, "", fromPolicy.Policy, toPolicy.Policy,
+					"iam", "set-default-policy-version",
+					"--policy-arn", Arn(toPolicy, a.to.Account),
+					"--version-id", toPolicy.defaultVersionId)
+			} else if fromPolicy.Policy.JsonString() != toPolicy.Policy.JsonString() {
 
 				if fromPolicy.numberOfVersions >= MaxAllowedPolicyVersions {
-					a.cmds.Add("aws", "iam", "delete-policy-version",
+					a.add(ActionDelete, toPolicy, fromPolicy.oldestVersionId, nil, nil,
+						"iam", "delete-policy-version",
 						"--policy-arn", Arn(toPolicy, a.to.Account),
 						"--version-id", fromPolicy.oldestVersionId)
 				}
 
-				a.cmds.Add("aws", "iam", "create-policy-version",
+				a.add(ActionUpdate, toPolicy, "", fromPolicy.Policy, toPolicy.Policy,
+					"iam", "create-policy-version",
 					"--policy-arn", Arn(toPolicy, a.to.Account),
-					"--set-as-default",
+					"--set-as-default", true,
 					"--policy-document", toPolicy.Policy.JsonString(),
 				)
 			}
+
+			a.updateTags(toPolicy, fromPolicy.Tags, toPolicy.Tags, "--policy-arn", Arn(toPolicy, a.to.Account))
 		} else {
 			// Create policy
-			args := []string{
+			args := []interface{}{
 				"iam", "create-policy",
 				"--policy-name", toPolicy.Name,
 				"--path", path(toPolicy.Path),
@@ -234,13 +371,26 @@ func (a *awsSyncCmdGenerator) updatePolicies() {
 			if toPolicy.Description != "" {
 				args = append(args, "--description", toPolicy.Description)
 			}
+			args = withTags(args, toPolicy.Tags)
 			// document last, for easier reading by end-user
 			args = append(args, "--policy-document", toPolicy.Policy.JsonString())
-			a.cmds.Add("aws", args...)
+			a.add(ActionCreate, toPolicy, "", nil, toPolicy, args...)
 		}
 	}
 }
 
+// isRoleRecreated is true if the role is deleted and created again with a new path
+func (a *awsSyncCmdGenerator) isRoleRecreated(name string) bool {
+	for _, fromRole := range a.from.Roles {
+		for _, toRole := range a.to.Roles {
+			if fromRole.Name == name && toRole.Name == name {
+				return fromRole.Path != toRole.Path
+			}
+		}
+	}
+	return false
+}
+
 func (a *awsSyncCmdGenerator) updateRoles() {
 
 	// update roles
@@ -248,43 +398,53 @@ func (a *awsSyncCmdGenerator) updateRoles() {
 		if found, fromRole := a.from.FindRoleByName(toRole.Name, toRole.Path); found {
 			// Update role
 			if !reflect.DeepEqual(fromRole.AssumeRolePolicyDocument, toRole.AssumeRolePolicyDocument) {
-				a.cmds.Add("aws", "iam", "update-assume-role-policy",
+				a.add(ActionUpdate, toRole, "", fromRole.AssumeRolePolicyDocument, toRole.AssumeRolePolicyDocument,
+					"iam", "update-assume-role-policy",
 					"--role-name", toRole.Name,
 					"--policy-document", toRole.AssumeRolePolicyDocument.JsonString())
 			}
 
-			// remove old inline policies
-			for _, ip := range inlinePolicySetDifference(fromRole.InlinePolicies, toRole.InlinePolicies) {
-				a.cmds.Add("aws", "iam", "delete-role-policy",
+			if fromRole.Description != toRole.Description {
+				a.add(ActionUpdate, toRole, "", fromRole.Description, toRole.Description,
+					"iam", "update-role-description",
 					"--role-name", toRole.Name,
-					"--policy-name", ip.Name)
+					"--description", toRole.Description)
 			}
 
-			// add new inline policies
-			for _, ip := range inlinePolicySetDifference(toRole.InlinePolicies, fromRole.InlinePolicies) {
-				a.cmds.Add("aws", "iam", "put-role-policy",
+			if fromRole.maxSessionDuration() != toRole.maxSessionDuration() {
+				a.add(ActionUpdate, toRole, "", fromRole.maxSessionDuration(), toRole.maxSessionDuration(),
+					"iam", "update-role",
 					"--role-name", toRole.Name,
-					"--policy-name", ip.Name,
-					"--policy-document", ip.Policy.JsonString())
+					"--max-session-duration", toRole.maxSessionDuration())
 			}
 
+			a.updateInlinePolicies(toRole, fromRole.InlinePolicies, toRole.InlinePolicies)
+
+			a.updatePermissionsBoundary(toRole, fromRole.PermissionsBoundary, toRole.PermissionsBoundary)
+
+			a.updateTags(toRole, fromRole.Tags, toRole.Tags, "--role-name", toRole.Name)
+
 			// detach old managed policies
 			for _, p := range stringSetDifference(fromRole.Policies, toRole.Policies) {
-				a.cmds.Add("aws", "iam", "detach-role-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionDetach, toRole, policyArn, nil, nil,
+					"iam", "detach-role-policy",
 					"--role-name", toRole.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
 
 			// attach new managed policies
 			for _, p := range stringSetDifference(toRole.Policies, fromRole.Policies) {
-				a.cmds.Add("aws", "iam", "attach-role-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionAttach, toRole, policyArn, nil, nil,
+					"iam", "attach-role-policy",
 					"--role-name", toRole.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
 
 		} else {
 			// Create role
-			args := []string{
+			args := []interface{}{
 				"iam", "create-role",
 				"--role-name", toRole.Name,
 				"--path", path(toRole.Path),
@@ -293,11 +453,19 @@ func (a *awsSyncCmdGenerator) updateRoles() {
 			if toRole.Description != "" {
 				args = append(args, "--description", toRole.Description)
 			}
-			a.cmds.Add("aws", args...)
+			if toRole.MaxSessionDuration != 0 {
+				args = append(args, "--max-session-duration", toRole.MaxSessionDuration)
+			}
+			if toRole.PermissionsBoundary != "" {
+				args = append(args, "--permissions-boundary", a.to.Account.policyArnFromString(toRole.PermissionsBoundary))
+			}
+			args = withTags(args, toRole.Tags)
+			a.add(ActionCreate, toRole, "", nil, toRole, args...)
 
 			// add new inline policies
 			for _, ip := range toRole.InlinePolicies {
-				a.cmds.Add("aws", "iam", "put-role-policy",
+				a.add(ActionAttach, toRole, ip.Name, nil, ip.Policy,
+					"iam", "put-role-policy",
 					"--role-name", toRole.Name,
 					"--policy-name", ip.Name,
 					"--policy-document", ip.Policy.JsonString())
@@ -305,12 +473,115 @@ func (a *awsSyncCmdGenerator) updateRoles() {
 
 			// attach new managed policies
 			for _, p := range toRole.Policies {
-				a.cmds.Add("aws", "iam", "attach-role-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionAttach, toRole, policyArn, nil, nil,
+					"iam", "attach-role-policy",
 					"--role-name", toRole.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
+			}
+		}
+	}
+}
+
+// updateInlinePolicies syncs the inline policies of a role, group or user
+func (a *awsSyncCmdGenerator) updateInlinePolicies(r AwsResource, fromPolicies, toPolicies []InlinePolicy) {
+	entity := r.ResourceType()
+
+	// remove old inline policies
+	for _, ip := range fromPolicies {
+		if found, _ := findInlinePolicyByName(toPolicies, ip.Name); !found {
+			a.add(ActionDelete, r, ip.Name, ip.Policy, nil,
+				"iam", "delete-"+entity+"-policy",
+				"--"+entity+"-name", r.ResourceName(),
+				"--policy-name", ip.Name)
+		}
+	}
+
+	// add new and update changed inline policies
+	for _, ip := range toPolicies {
+		found, fromIp := findInlinePolicyByName(fromPolicies, ip.Name)
+		if found && reflect.DeepEqual(fromIp, ip) {
+			continue
+		}
+
+		action, before := ActionAttach, interface{}(nil)
+		if found {
+			action, before = ActionUpdate, fromIp.Policy
+		}
+		a.add(action, r, ip.Name, before, ip.Policy,
+			"iam", "put-"+entity+"-policy",
+			"--"+entity+"-name", r.ResourceName(),
+			"--policy-name", ip.Name,
+			"--policy-document", ip.Policy.JsonString())
+	}
+}
+
+// updatePermissionsBoundary syncs the permissions boundary of a role or user
+func (a *awsSyncCmdGenerator) updatePermissionsBoundary(r AwsResource, fromBoundary, toBoundary string) {
+	if fromBoundary == toBoundary {
+		return
+	}
+	entity := r.ResourceType()
+
+	if toBoundary == "" {
+		// removing a boundary widens permissions, so it's treated as destructive
+		fromArn := a.to.Account.policyArnFromString(fromBoundary)
+		a.add(ActionDetach, r, fromArn, fromArn, nil,
+			"iam", "delete-"+entity+"-permissions-boundary",
+			"--"+entity+"-name", r.ResourceName())
+		return
+	}
+
+	var before interface{}
+	if fromBoundary != "" {
+		before = a.to.Account.policyArnFromString(fromBoundary)
+	}
+	toArn := a.to.Account.policyArnFromString(toBoundary)
+	a.add(ActionAttach, r, toArn, before, toArn,
+		"iam", "put-"+entity+"-permissions-boundary",
+		"--"+entity+"-name", r.ResourceName(),
+		"--permissions-boundary", toArn)
+}
+
+// updateTags syncs the tags of a resource, with id being the
+// cli args identifying the resource
+func (a *awsSyncCmdGenerator) updateTags(r AwsResource, fromTags, toTags map[string]string, id ...interface{}) {
+	entity := cliEntityName(r)
+
+	// remove old tags
+	for _, tagKey := range sortedKeys(fromTags) {
+		if _, ok := toTags[tagKey]; !ok {
+			a.add(ActionUntag, r, tagKey, fromTags[tagKey], nil,
+				append([]interface{}{"iam", "untag-" + entity}, append(id, "--tag-keys", []string{tagKey})...)...)
+		}
+	}
+
+	// add new and changed tags
+	for _, tagKey := range sortedKeys(toTags) {
+		fromValue, found := fromTags[tagKey]
+		if found && fromValue == toTags[tagKey] {
+			continue
+		}
+
+		var before interface{}
+		if found {
+			before = fromValue
+		}
+		a.add(ActionTag, r, tagKey, before, toTags[tagKey],
+			append([]interface{}{"iam", "tag-" + entity}, append(id, "--tags", iamTags(map[string]string{tagKey: toTags[tagKey]}))...)...)
+	}
+}
+
+// isGroupRecreated is true if the group is deleted and created again with a new path
+func (a *awsSyncCmdGenerator) isGroupRecreated(name string) bool {
+	for _, fromGroup := range a.from.Groups {
+		for _, toGroup := range a.to.Groups {
+			if fromGroup.Name == name && toGroup.Name == name {
+				return fromGroup.Path != toGroup.Path
 			}
 		}
 	}
+	return false
 }
 
 func (a *awsSyncCmdGenerator) updateGroups() {
@@ -318,51 +589,46 @@ func (a *awsSyncCmdGenerator) updateGroups() {
 	for _, toGroup := range a.to.Groups {
 		if found, fromGroup := a.from.FindGroupByName(toGroup.Name, toGroup.Path); found {
 
-			// remove old inline policies
-			for _, ip := range inlinePolicySetDifference(fromGroup.InlinePolicies, toGroup.InlinePolicies) {
-				a.cmds.Add("aws", "iam", "delete-group-policy",
-					"--group-name", toGroup.Name,
-					"--policy-name", ip.Name)
-			}
-
-			// add new inline policies
-			for _, ip := range inlinePolicySetDifference(toGroup.InlinePolicies, fromGroup.InlinePolicies) {
-				a.cmds.Add("aws", "iam", "put-group-policy",
-					"--group-name", toGroup.Name,
-					"--policy-name", ip.Name,
-					"--policy-document", ip.Policy.JsonString())
-			}
+			a.updateInlinePolicies(toGroup, fromGroup.InlinePolicies, toGroup.InlinePolicies)
 
 			// detach old managed policies
 			for _, p := range stringSetDifference(fromGroup.Policies, toGroup.Policies) {
-				a.cmds.Add("aws", "iam", "detach-group-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionDetach, toGroup, policyArn, nil, nil,
+					"iam", "detach-group-policy",
 					"--group-name", toGroup.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
 
 			// attach new managed policies
 			for _, p := range stringSetDifference(toGroup.Policies, fromGroup.Policies) {
-				a.cmds.Add("aws", "iam", "attach-group-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionAttach, toGroup, policyArn, nil, nil,
+					"iam", "attach-group-policy",
 					"--group-name", toGroup.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
 
 		} else {
 			// Create group
-			a.cmds.Add("aws", "iam", "create-group",
+			a.add(ActionCreate, toGroup, "", nil, toGroup,
+				"iam", "create-group",
 				"--group-name", toGroup.Name,
 				"--path", path(toGroup.Path))
 
 			for _, ip := range toGroup.InlinePolicies {
-				a.cmds.Add("aws", "iam", "put-group-policy",
+				a.add(ActionAttach, toGroup, ip.Name, nil, ip.Policy,
+					"iam", "put-group-policy",
 					"--group-name", toGroup.Name, "--policy-name", ip.Name,
 					"--policy-document", ip.Policy.JsonString())
 			}
 
 			for _, p := range toGroup.Policies {
-				a.cmds.Add("aws", "iam", "attach-group-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionAttach, toGroup, policyArn, nil, nil,
+					"iam", "attach-group-policy",
 					"--group-name", toGroup.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
 
 		}
@@ -375,86 +641,83 @@ func (a *awsSyncCmdGenerator) updateUsers() {
 	for _, toUser := range a.to.Users {
 		if found, fromUser := a.from.FindUserByName(toUser.Name, toUser.Path); found {
 
+			// groups that are created again with a new path lose their users,
+			// so the user is removed from the old group and added to the new one
+			recreatedGroups := []string{}
+			for _, g := range toUser.Groups {
+				if containsString(fromUser.Groups, g) && a.isGroupRecreated(g) {
+					recreatedGroups = append(recreatedGroups, g)
+				}
+			}
+
 			// remove old groups
-			for _, g := range stringSetDifference(fromUser.Groups, toUser.Groups) {
-				a.cmds.Add("aws", "iam", "remove-user-from-group",
+			for _, g := range append(stringSetDifference(fromUser.Groups, toUser.Groups), recreatedGroups...) {
+				a.add(ActionDetach, toUser, g, nil, nil,
+					"iam", "remove-user-from-group",
 					"--user-name", toUser.Name,
 					"--group-name", g)
 			}
 
 			// add new groups
-			for _, g := range stringSetDifference(toUser.Groups, fromUser.Groups) {
-				a.cmds.Add("aws", "iam", "add-user-to-group",
+			for _, g := range append(stringSetDifference(toUser.Groups, fromUser.Groups), recreatedGroups...) {
+				a.add(ActionAttach, toUser, g, nil, nil,
+					"iam", "add-user-to-group",
 					"--user-name", toUser.Name,
 					"--group-name", g)
 			}
 
-			// remove old inline policies
-			for _, ip := range inlinePolicySetDifference(fromUser.InlinePolicies, toUser.InlinePolicies) {
-				a.cmds.Add("aws", "iam", "delete-user-policy",
-					"--user-name", toUser.Name,
-					"--policy-name", ip.Name)
-			}
-
-			// add new inline policies
-			for _, ip := range inlinePolicySetDifference(toUser.InlinePolicies, fromUser.InlinePolicies) {
-				a.cmds.Add("aws", "iam", "put-user-policy",
-					"--user-name", toUser.Name,
-					"--policy-name", ip.Name,
-					"--policy-document", ip.Policy.JsonString())
-			}
+			a.updateInlinePolicies(toUser, fromUser.InlinePolicies, toUser.InlinePolicies)
 
 			// detach old managed policies
 			for _, p := range stringSetDifference(fromUser.Policies, toUser.Policies) {
-				a.cmds.Add("aws", "iam", "detach-user-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionDetach, toUser, policyArn, nil, nil,
+					"iam", "detach-user-policy",
 					"--user-name", toUser.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
 
 			// attach new managed policies
 			for _, p := range stringSetDifference(toUser.Policies, fromUser.Policies) {
-				a.cmds.Add("aws", "iam", "attach-user-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionAttach, toUser, policyArn, nil, nil,
+					"iam", "attach-user-policy",
 					"--user-name", toUser.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
 
-			// remove old tags
-			for tagKey, _ := range mapStringSetDifference(fromUser.Tags, toUser.Tags) {
-				a.cmds.Add("aws", "iam", "untag-user",
-					"--user-name", toUser.Name,
-					"--tag-keys", tagKey)
-			}
+			a.updatePermissionsBoundary(toUser, fromUser.PermissionsBoundary, toUser.PermissionsBoundary)
 
-			// attach new tags
-			for tagKey, tagValue := range mapStringSetDifference(toUser.Tags, fromUser.Tags) {
-				a.cmds.Add("aws", "iam", "tag-user",
-					"--user-name", toUser.Name,
-					"--tags", "Key="+tagKey+",Value="+tagValue)
-			}
+			a.updateTags(toUser, fromUser.Tags, toUser.Tags, "--user-name", toUser.Name)
+
+			a.updateSSHPublicKeys(toUser, fromUser.credentials)
+
+			a.checkUserCredentials(toUser, fromUser.credentials)
 
 		} else {
 			// Create user
-			if len(toUser.Tags) == 0 {
-				a.cmds.Add("aws", "iam", "create-user",
-					"--user-name", toUser.Name,
-					"--path", path(toUser.Path))
-			} else {
-				a.cmds.Add("aws", "iam", "create-user",
-					"--user-name", toUser.Name,
-					"--path", path(toUser.Path),
-					"--tags", mapTagsToString(toUser.Tags))
+			args := []interface{}{
+				"iam", "create-user",
+				"--user-name", toUser.Name,
+				"--path", path(toUser.Path),
 			}
+			if toUser.PermissionsBoundary != "" {
+				args = append(args, "--permissions-boundary", a.to.Account.policyArnFromString(toUser.PermissionsBoundary))
+			}
+			a.add(ActionCreate, toUser, "", nil, toUser, withTags(args, toUser.Tags)...)
 
 			// add new groups
 			for _, g := range toUser.Groups {
-				a.cmds.Add("aws", "iam", "add-user-to-group",
+				a.add(ActionAttach, toUser, g, nil, nil,
+					"iam", "add-user-to-group",
 					"--user-name", toUser.Name,
 					"--group-name", g)
 			}
 
 			// add new inline policies
 			for _, ip := range toUser.InlinePolicies {
-				a.cmds.Add("aws", "iam", "put-user-policy",
+				a.add(ActionAttach, toUser, ip.Name, nil, ip.Policy,
+					"iam", "put-user-policy",
 					"--user-name", toUser.Name,
 					"--policy-name", ip.Name,
 					"--policy-document", ip.Policy.JsonString())
@@ -462,37 +725,123 @@ func (a *awsSyncCmdGenerator) updateUsers() {
 
 			// attach new managed policies
 			for _, p := range toUser.Policies {
-				a.cmds.Add("aws", "iam", "attach-user-policy",
+				policyArn := a.to.Account.policyArnFromString(p)
+				a.add(ActionAttach, toUser, policyArn, nil, nil,
+					"iam", "attach-user-policy",
 					"--user-name", toUser.Name,
-					"--policy-arn", a.to.Account.policyArnFromString(p))
+					"--policy-arn", policyArn)
 			}
+
+			a.updateSSHPublicKeys(toUser, userCredentials{})
+
+			a.checkUserCredentials(toUser, userCredentials{})
 		}
 	}
 }
+
+// updateSSHPublicKeys syncs the ssh public keys of a user that declares them,
+// uploading keys that aren't in AWS and deleting keys that aren't declared
+func (a *awsSyncCmdGenerator) updateSSHPublicKeys(u *User, creds userCredentials) {
+	if u.SSHPublicKeys == nil {
+		return
+	}
+
+	toKeys := map[string]bool{}
+	for _, key := range *u.SSHPublicKeys {
+		toKeys[normaliseSSHPublicKey(key)] = true
+	}
+
+	for _, key := range sortedKeys(creds.SSHPublicKeyIds) {
+		if !toKeys[key] {
+			keyId := creds.sshPublicKeyId(key)
+			a.add(ActionDelete, u, keyId, key, nil,
+				"iam", "delete-ssh-public-key",
+				"--user-name", u.Name,
+				"--ssh-public-key-id", keyId)
+		}
+	}
+
+	for _, key := range *u.SSHPublicKeys {
+		if creds.sshPublicKeyId(key) == "" {
+			a.add(ActionAttach, u, "ssh-public-key", nil, key,
+				"iam", "upload-ssh-public-key",
+				"--user-name", u.Name,
+				"--ssh-public-key-body", key)
+		}
+	}
+}
+
+// checkUserCredentials compares the credentials of a user with the user's
+// expectations. Console access can be removed, but otherwise changing
+// credentials needs secrets, so any difference is a warning
+func (a *awsSyncCmdGenerator) checkUserCredentials(u *User, creds userCredentials) {
+	hasConsoleAccess := creds.HasLoginProfile
+
+	if u.ConsoleAccess != nil {
+		if !*u.ConsoleAccess && creds.HasLoginProfile {
+			a.add(ActionDelete, u, "login-profile", nil, nil,
+				"iam", "delete-login-profile",
+				"--user-name", u.Name)
+			hasConsoleAccess = false
+		}
+		if *u.ConsoleAccess && !creds.HasLoginProfile {
+			a.plan.warn("User %s is expected to have console access but has no password, create one with: aws iam create-login-profile --user-name %s", u.Name, u.Name)
+		}
+	}
+
+	if u.MFARequired && len(creds.MFADevices) == 0 {
+		if hasConsoleAccess {
+			a.plan.warn("User %s has console access but no MFA device", u.Name)
+		} else {
+			a.plan.warn("User %s is expected to have an MFA device but has none", u.Name)
+		}
+	}
+
+	if u.MaxAccessKeys != nil && len(creds.AccessKeyIds) > *u.MaxAccessKeys {
+		a.plan.warn("User %s has %d access keys, more than the maximum of %d: %s",
+			u.Name, len(creds.AccessKeyIds), *u.MaxAccessKeys, strings.Join(creds.AccessKeyIds, ", "))
+	}
+}
 func (a *awsSyncCmdGenerator) updateInstanceProfiles() {
 	// update instance profiles
 	for _, toInstanceProfile := range a.to.InstanceProfiles {
 		if found, fromInstanceProfile := a.from.FindInstanceProfileByName(toInstanceProfile.Name, toInstanceProfile.Path); found {
+			// roles that are created again with a new path are removed
+			// from the instance profile, then added back
+			recreatedRoles := []string{}
+			for _, role := range toInstanceProfile.Roles {
+				if containsString(fromInstanceProfile.Roles, role) && a.isRoleRecreated(role) {
+					recreatedRoles = append(recreatedRoles, role)
+				}
+			}
+
 			// remove old roles from instance profile
-			for _, role := range stringSetDifference(fromInstanceProfile.Roles, toInstanceProfile.Roles) {
-				a.cmds.Add("aws", "iam", "remove-role-from-instance-profile",
+			for _, role := range append(stringSetDifference(fromInstanceProfile.Roles, toInstanceProfile.Roles), recreatedRoles...) {
+				a.add(ActionDetach, toInstanceProfile, role, nil, nil,
+					"iam", "remove-role-from-instance-profile",
 					"--instance-profile-name", toInstanceProfile.Name,
 					"--role-name", role)
 			}
 
 			// add new roles to instance profile
-			for _, role := range stringSetDifference(toInstanceProfile.Roles, fromInstanceProfile.Roles) {
-				a.cmds.Add("aws", "iam", "add-role-to-instance-profile",
+			for _, role := range append(stringSetDifference(toInstanceProfile.Roles, fromInstanceProfile.Roles), recreatedRoles...) {
+				a.add(ActionAttach, toInstanceProfile, role, nil, nil,
+					"iam", "add-role-to-instance-profile",
 					"--instance-profile-name", toInstanceProfile.Name,
 					"--role-name", role)
 			}
+
+			a.updateTags(toInstanceProfile, fromInstanceProfile.Tags, toInstanceProfile.Tags, "--instance-profile-name", toInstanceProfile.Name)
 		} else {
 			// Create instance profile
-			a.cmds.Add("aws", "iam", "create-instance-profile",
+			a.add(ActionCreate, toInstanceProfile, "", nil, toInstanceProfile, withTags([]interface{}{
+				"iam", "create-instance-profile",
 				"--instance-profile-name", toInstanceProfile.Name,
-				"--path", path(toInstanceProfile.Path))
+				"--path", path(toInstanceProfile.Path),
+			}, toInstanceProfile.Tags)...)
 			for _, role := range toInstanceProfile.Roles {
-				a.cmds.Add("aws", "iam", "add-role-to-instance-profile",
+				a.add(ActionAttach, toInstanceProfile, role, nil, nil,
+					"iam", "add-role-to-instance-profile",
 					"--instance-profile-name", toInstanceProfile.Name,
 					"--role-name", role)
 			}
@@ -500,44 +849,651 @@ func (a *awsSyncCmdGenerator) updateInstanceProfiles() {
 	}
 }
 
+func (a *awsSyncCmdGenerator) updateIdentityProviders() {
+	for _, toProvider := range a.to.SamlProviders {
+		arn := Arn(toProvider, a.to.Account)
+		if found, fromProvider := a.from.FindSamlProviderByName(toProvider.Name, toProvider.Path); found {
+			if fromProvider.SAMLMetadataDocument != toProvider.SAMLMetadataDocument {
+				a.add(ActionUpdate, toProvider, "", fromProvider.SAMLMetadataDocument, toProvider.SAMLMetadataDocument,
+					"iam", "update-saml-provider",
+					"--saml-provider-arn", arn,
+					"--saml-metadata-document", toProvider.SAMLMetadataDocument)
+			}
+
+			a.updateTags(toProvider, fromProvider.Tags, toProvider.Tags, "--saml-provider-arn", arn)
+		} else {
+			a.add(ActionCreate, toProvider, "", nil, toProvider, withTags([]interface{}{
+				"iam", "create-saml-provider",
+				"--name", toProvider.Name,
+				"--saml-metadata-document", toProvider.SAMLMetadataDocument,
+			}, toProvider.Tags)...)
+		}
+	}
+
+	for _, toProvider := range a.to.OidcProviders {
+		arn := Arn(toProvider, a.to.Account)
+		if found, fromProvider := a.from.FindOidcProviderByName(toProvider.Name, toProvider.Path); found {
+			// remove old client ids
+			for _, id := range stringSetDifference(fromProvider.ClientIDList, toProvider.ClientIDList) {
+				a.add(ActionDetach, toProvider, id, nil, nil,
+					"iam", "remove-client-id-from-open-id-connect-provider",
+					"--open-id-connect-provider-arn", arn,
+					"--client-id", id)
+			}
+
+			// add new client ids
+			for _, id := range stringSetDifference(toProvider.ClientIDList, fromProvider.ClientIDList) {
+				a.add(ActionAttach, toProvider, id, nil, nil,
+					"iam", "add-client-id-to-open-id-connect-provider",
+					"--open-id-connect-provider-arn", arn,
+					"--client-id", id)
+			}
+
+			// thumbprints are replaced as a list
+			if len(stringSetDifference(fromProvider.ThumbprintList, toProvider.ThumbprintList)) > 0 ||
+				len(stringSetDifference(toProvider.ThumbprintList, fromProvider.ThumbprintList)) > 0 {
+				a.add(ActionUpdate, toProvider, "", fromProvider.ThumbprintList, toProvider.ThumbprintList,
+					"iam", "update-open-id-connect-provider-thumbprint",
+					"--open-id-connect-provider-arn", arn,
+					"--thumbprint-list", toProvider.ThumbprintList)
+			}
+
+			a.updateTags(toProvider, fromProvider.Tags, toProvider.Tags, "--open-id-connect-provider-arn", arn)
+		} else {
+			args := []interface{}{
+				"iam", "create-open-id-connect-provider",
+				"--url", toProvider.Url(),
+			}
+			if len(toProvider.ClientIDList) > 0 {
+				args = append(args, "--client-id-list", toProvider.ClientIDList)
+			}
+			if len(toProvider.ThumbprintList) > 0 {
+				args = append(args, "--thumbprint-list", toProvider.ThumbprintList)
+			}
+			a.add(ActionCreate, toProvider, "", nil, toProvider, withTags(args, toProvider.Tags)...)
+		}
+	}
+}
+
 func (a *awsSyncCmdGenerator) updateBucketPolicies() {
 	for _, fromBucketPolicy := range a.from.BucketPolicies {
 		if found, _ := a.to.FindBucketPolicyByBucketName(fromBucketPolicy.BucketName); !found {
-			// remove bucket policy
-			a.cmds.Add("aws", "s3api", "delete-bucket-policy",
-				"--bucket", fromBucketPolicy.BucketName)
+			a.updateBucketPolicy(fromBucketPolicy, fromBucketPolicy.Policy, nil)
+			a.updateBucketPublicAccessBlock(fromBucketPolicy, fromBucketPolicy.PublicAccessBlock, nil)
 		}
 	}
 
 	for _, toBucketPolicy := range a.to.BucketPolicies {
-		isToAccountUpToDate := false
-		if found, fromBucketPolicy := a.from.FindBucketPolicyByBucketName(toBucketPolicy.BucketName); found {
-			if fromBucketPolicy.Policy.JsonString() == toBucketPolicy.Policy.JsonString() {
-				isToAccountUpToDate = true
+		fromBucketPolicy := &BucketPolicy{}
+		if found, bp := a.from.FindBucketPolicyByBucketName(toBucketPolicy.BucketName); found {
+			fromBucketPolicy = bp
+		}
+		a.updateBucketPolicy(toBucketPolicy, fromBucketPolicy.Policy, toBucketPolicy.Policy)
+		a.updateBucketPublicAccessBlock(toBucketPolicy, fromBucketPolicy.PublicAccessBlock, toBucketPolicy.PublicAccessBlock)
+	}
+}
+
+func (a *awsSyncCmdGenerator) updateBucketPolicy(bp *BucketPolicy, fromPolicy, toPolicy *PolicyDocument) {
+	switch {
+	case fromPolicy == nil && toPolicy == nil:
+	case toPolicy == nil:
+		a.add(ActionDelete, bp, "", fromPolicy, nil,
+			"s3api", "delete-bucket-policy",
+			"--bucket", bp.BucketName)
+	case fromPolicy == nil:
+		a.add(ActionCreate, bp, "", nil, toPolicy,
+			"s3api", "put-bucket-policy",
+			"--bucket", bp.BucketName,
+			"--policy", toPolicy.JsonString())
+	case fromPolicy.JsonString() != toPolicy.JsonString():
+		a.add(ActionUpdate, bp, "", fromPolicy, toPolicy,
+			"s3api", "put-bucket-policy",
+			"--bucket", bp.BucketName,
+			"--policy", toPolicy.JsonString())
+	}
+}
+
+// publicAccessBlockConfiguration is the PublicAccessBlockConfiguration of pab
+// for a bucket
+func publicAccessBlockConfiguration(pab *PublicAccessBlock) *s3.PublicAccessBlockConfiguration {
+	return &s3.PublicAccessBlockConfiguration{
+		BlockPublicAcls:       aws.Bool(pab.BlockPublicAcls),
+		BlockPublicPolicy:     aws.Bool(pab.BlockPublicPolicy),
+		IgnorePublicAcls:      aws.Bool(pab.IgnorePublicAcls),
+		RestrictPublicBuckets: aws.Bool(pab.RestrictPublicBuckets),
+	}
+}
+
+// accountPublicAccessBlockConfiguration is the PublicAccessBlockConfiguration
+// of pab for an account
+func accountPublicAccessBlockConfiguration(pab *PublicAccessBlock) *s3control.PublicAccessBlockConfiguration {
+	return &s3control.PublicAccessBlockConfiguration{
+		BlockPublicAcls:       aws.Bool(pab.BlockPublicAcls),
+		BlockPublicPolicy:     aws.Bool(pab.BlockPublicPolicy),
+		IgnorePublicAcls:      aws.Bool(pab.IgnorePublicAcls),
+		RestrictPublicBuckets: aws.Bool(pab.RestrictPublicBuckets),
+	}
+}
+
+// updatePublicAccessBlock syncs Block Public Access settings, with
+// service and id being the aws cli service and option identifying the bucket or account.
+// Settings left out of the yaml are left as they are, as removing them widens access
+func (a *awsSyncCmdGenerator) updatePublicAccessBlock(r AwsResource, fromPab, toPab *PublicAccessBlock, service string, id ...interface{}) {
+	if toPab == nil || reflect.DeepEqual(fromPab, toPab) {
+		return
+	}
+
+	var config interface{} = publicAccessBlockConfiguration(toPab)
+	if service == "s3control" {
+		config = accountPublicAccessBlockConfiguration(toPab)
+	}
+
+	action, before := ActionCreate, interface{}(nil)
+	if fromPab != nil {
+		action, before = ActionUpdate, fromPab
+	}
+	a.add(action, r, "public-access-block", before, toPab,
+		append(append([]interface{}{service, "put-public-access-block"}, id...),
+			"--public-access-block-configuration", config)...)
+}
+
+func (a *awsSyncCmdGenerator) updateBucketPublicAccessBlock(bp *BucketPolicy, fromPab, toPab *PublicAccessBlock) {
+	if containsString(a.from.unknownBucketPublicAccessBlocks, bp.BucketName) {
+		if toPab != nil {
+			a.plan.warn("Block Public Access settings of bucket %s can't be read, so they're left as they are", bp.BucketName)
+		}
+		return
+	}
+	a.updatePublicAccessBlock(bp, fromPab, toPab, "s3api", "--bucket", bp.BucketName)
+}
+
+func (a *awsSyncCmdGenerator) updateAccountPublicAccessBlock() {
+	if a.from.publicAccessBlockUnknown {
+		if a.to.PublicAccessBlock != nil {
+			a.plan.warn("Block Public Access settings of account %s can't be read, so they're left as they are", a.to.Account)
+		}
+		return
+	}
+	var fromPab, toPab *PublicAccessBlock
+	if a.from.PublicAccessBlock != nil {
+		fromPab = &a.from.PublicAccessBlock.PublicAccessBlock
+	}
+	if a.to.PublicAccessBlock != nil {
+		toPab = &a.to.PublicAccessBlock.PublicAccessBlock
+	}
+	a.updatePublicAccessBlock(AccountPublicAccessBlock{}, fromPab, toPab, "s3control", "--account-id", a.to.Account.Id)
+}
+
+// checkPublicBucketPolicies warns of public bucket policies that
+// Block Public Access settings make ineffective
+func (a *awsSyncCmdGenerator) checkPublicBucketPolicies() {
+	for _, bp := range a.to.BucketPolicies {
+		if !isPublicPolicy(bp.Policy) {
+			continue
+		}
+		if bp.PublicAccessBlock.blocksPublicPolicies() {
+			a.plan.warn("The policy of bucket %s allows public access, but is ineffective as the bucket blocks public policies", bp.BucketName)
+		} else if a.to.PublicAccessBlock != nil && a.to.PublicAccessBlock.blocksPublicPolicies() {
+			a.plan.warn("The policy of bucket %s allows public access, but is ineffective as the account blocks public policies", bp.BucketName)
+		}
+	}
+}
+
+// isPublicPolicy indicates that a policy allows anyone access
+// without conditions
+func isPublicPolicy(p *PolicyDocument) bool {
+	for _, s := range statementList(policyDocumentMap(p)["Statement"]) {
+		if s["Effect"] != "Allow" || s["Condition"] != nil {
+			continue
+		}
+		principal := s["Principal"]
+		if m, ok := principal.(map[string]interface{}); ok {
+			principal = m["AWS"]
+		}
+		for _, v := range flattenPolicyValue(principal, "") {
+			if v == "*" {
+				return true
 			}
 		}
+	}
+	return false
+}
 
-		if !isToAccountUpToDate {
-			a.cmds.Add("aws", "s3api", "put-bucket-policy",
-				"--bucket", toBucketPolicy.BucketName,
-				"--policy", toBucketPolicy.Policy.JsonString())
+func (a *awsSyncCmdGenerator) updateAccountAlias() {
+	fromAlias, toAlias := a.from.Account.Alias, a.to.Account.Alias
+	if fromAlias == toAlias {
+		return
+	}
+	if toAlias == "" {
+		if a.to.noAccountAlias {
+			a.add(ActionDelete, AccountAlias{fromAlias}, "", fromAlias, nil,
+				"iam", "delete-account-alias",
+				"--account-alias", fromAlias)
 		}
+		return
 	}
+
+	// an account has at most one alias, and creating an alias replaces the existing one
+	action, before := ActionCreate, interface{}(nil)
+	if fromAlias != "" {
+		action, before = ActionUpdate, fromAlias
+	}
+	a.add(action, AccountAlias{toAlias}, "", before, toAlias,
+		"iam", "create-account-alias",
+		"--account-alias", toAlias)
 }
 
-func (a *awsSyncCmdGenerator) GenerateCmds() CmdList {
+func (a *awsSyncCmdGenerator) updateAccountPasswordPolicy() {
+	fromPolicy, toPolicy := a.from.PasswordPolicy, a.to.PasswordPolicy
+	if toPolicy == nil {
+		if fromPolicy != nil && a.to.noPasswordPolicy {
+			a.add(ActionDelete, fromPolicy, "", fromPolicy, nil,
+				"iam", "delete-account-password-policy")
+		}
+		return
+	}
+	if fromPolicy != nil && fromPolicy.withDefaults() == toPolicy.withDefaults() {
+		return
+	}
+
+	// update-account-password-policy resets any option not given to its default,
+	// so all options are given
+	args := []interface{}{
+		"iam", "update-account-password-policy",
+		"--require-symbols", toPolicy.RequireSymbols,
+		"--require-numbers", toPolicy.RequireNumbers,
+		"--require-uppercase-characters", toPolicy.RequireUppercaseCharacters,
+		"--require-lowercase-characters", toPolicy.RequireLowercaseCharacters,
+		"--allow-users-to-change-password", toPolicy.AllowUsersToChangePassword,
+		"--hard-expiry", toPolicy.HardExpiry,
+	}
+	if toPolicy.MinimumPasswordLength != 0 {
+		args = append(args, "--minimum-password-length", toPolicy.MinimumPasswordLength)
+	}
+	if toPolicy.MaxPasswordAge != 0 {
+		args = append(args, "--max-password-age", toPolicy.MaxPasswordAge)
+	}
+	if toPolicy.PasswordReusePrevention != 0 {
+		args = append(args, "--password-reuse-prevention", toPolicy.PasswordReusePrevention)
+	}
+
+	action, before := ActionCreate, interface{}(nil)
+	if fromPolicy != nil {
+		action, before = ActionUpdate, fromPolicy
+	}
+	a.add(action, toPolicy, "", before, toPolicy, args...)
+}
+
+// updateOrganization syncs the OU tree, account placement and service control
+// policies of an organization, each only when the yaml has its directory.
+// Steps that need the id of an OU or policy created by the same push are left
+// for the next push, as the id is only known once it's created
+func (a *awsSyncCmdGenerator) updateOrganization() {
+	units, policies := a.to.managesOrganizationalUnits(), a.to.managesServiceControlPolicies()
+	if !units && !policies {
+		return
+	}
+	if a.from.organizationRootId == "" {
+		a.plan.warn("Account %s isn't the management account of an organization, so its organizations files are ignored", a.to.Account)
+		return
+	}
+
+	if units {
+		a.createOrganizationalUnits()
+	}
+	if policies {
+		a.updateServiceControlPolicies()
+	}
+	if units {
+		a.moveAccounts()
+	}
+	if policies {
+		a.deleteServiceControlPolicies()
+	}
+	if units {
+		a.deleteOrganizationalUnits()
+	}
+}
+
+// sortedByDepth returns the OUs with parents before their children
+func sortedByDepth(units []*OrganizationalUnit) []*OrganizationalUnit {
+	sorted := append([]*OrganizationalUnit{}, units...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.Count(sorted[i].Path, "/") < strings.Count(sorted[j].Path, "/")
+	})
+	return sorted
+}
+
+func (a *awsSyncCmdGenerator) createOrganizationalUnits() {
+	for _, toOu := range sortedByDepth(a.to.OrganizationalUnits) {
+		if found, _ := a.from.FindOrganizationalUnitByPath(toOu.OuPath()); found {
+			continue
+		}
+
+		parentId := a.from.organizationTargetId(toOu.parentOuPath())
+		if parentId == "" {
+			a.plan.warn("Organizational unit %s can't be created until %s exists, run push again to create it", toOu.OuPath(), toOu.parentOuPath())
+			continue
+		}
+		a.add(ActionCreate, toOu, "", nil, toOu,
+			"organizations", "create-organizational-unit",
+			"--parent-id", parentId,
+			"--name", toOu.Name)
+	}
+}
+
+func (a *awsSyncCmdGenerator) updateServiceControlPolicies() {
+	for _, toPolicy := range a.to.ServiceControlPolicies {
+		found, fromPolicy := a.from.FindServiceControlPolicyByName(toPolicy.Name)
+		if !found {
+			args := []interface{}{
+				"organizations", "create-policy",
+				"--name", toPolicy.Name,
+				"--type", "SERVICE_CONTROL_POLICY",
+			}
+			if toPolicy.Description != "" {
+				args = append(args, "--description", toPolicy.Description)
+			}
+			// document last, for easier reading by end-user
+			args = append(args, "--content", toPolicy.Policy.JsonString())
+			a.add(ActionCreate, toPolicy, "", nil, toPolicy.Policy, args...)
+
+			if len(toPolicy.Targets) > 0 {
+				a.plan.warn("Service control policy %s can't be attached until it exists, run push again to attach it", toPolicy.Name)
+			}
+			continue
+		}
+
+		if fromPolicy.Policy.JsonString() != toPolicy.Policy.JsonString() {
+			a.add(ActionUpdate, toPolicy, "", fromPolicy.Policy, toPolicy.Policy,
+				"organizations", "update-policy",
+				"--policy-id", fromPolicy.id,
+				"--content", toPolicy.Policy.JsonString())
+		}
+		if fromPolicy.Description != toPolicy.Description {
+			a.add(ActionUpdate, toPolicy, "", fromPolicy.Description, toPolicy.Description,
+				"organizations", "update-policy",
+				"--policy-id", fromPolicy.id,
+				"--description", toPolicy.Description)
+		}
+
+		// attach before detaching, as a target must always have a policy attached
+		for _, target := range toPolicy.Targets {
+			if containsString(fromPolicy.Targets, target) {
+				continue
+			}
+			targetId := a.from.organizationTargetId(target)
+			if targetId == "" {
+				a.plan.warn("Service control policy %s can't be attached to %s until it exists, run push again to attach it", toPolicy.Name, target)
+				continue
+			}
+			a.add(ActionAttach, toPolicy, target, nil, target,
+				"organizations", "attach-policy",
+				"--policy-id", fromPolicy.id,
+				"--target-id", targetId)
+		}
+		for _, target := range fromPolicy.Targets {
+			if !containsString(toPolicy.Targets, target) {
+				a.detachServiceControlPolicy(fromPolicy, target)
+			}
+		}
+	}
+}
+
+func (a *awsSyncCmdGenerator) detachServiceControlPolicy(p *ServiceControlPolicy, target string) {
+	a.add(ActionDetach, p, target, target, nil,
+		"organizations", "detach-policy",
+		"--policy-id", p.id,
+		"--target-id", a.from.organizationTargetId(target))
+}
+
+// moveAccounts moves accounts into the OU whose file lists them, and
+// accounts that no OU lists into the root
+func (a *awsSyncCmdGenerator) moveAccounts() {
+	moved := map[string]bool{}
+	for _, toOu := range a.to.OrganizationalUnits {
+		for _, accountId := range toOu.Accounts {
+			moved[accountId] = true
+			destinationId := a.from.organizationTargetId(toOu.OuPath())
+			if destinationId == "" {
+				a.plan.warn("Account %s can't be moved to %s until it exists, run push again to move it", accountId, toOu.OuPath())
+				continue
+			}
+			a.moveAccount(toOu, accountId, destinationId)
+		}
+	}
+
+	for _, fromOu := range a.from.OrganizationalUnits {
+		for _, accountId := range fromOu.Accounts {
+			if !moved[accountId] {
+				a.moveAccount(fromOu, accountId, a.from.organizationRootId)
+			}
+		}
+	}
+}
+
+func (a *awsSyncCmdGenerator) moveAccount(ou *OrganizationalUnit, accountId, destinationId string) {
+	sourceId := a.from.organizationParentId(accountId)
+	if sourceId == destinationId {
+		return
+	}
+	a.add(ActionUpdate, ou, accountId, sourceId, destinationId,
+		"organizations", "move-account",
+		"--account-id", accountId,
+		"--source-parent-id", sourceId,
+		"--destination-parent-id", destinationId)
+}
+
+func (a *awsSyncCmdGenerator) deleteServiceControlPolicies() {
+	for _, fromPolicy := range a.from.ServiceControlPolicies {
+		if found, _ := a.to.FindServiceControlPolicyByName(fromPolicy.Name); found {
+			continue
+		}
+		for _, target := range fromPolicy.Targets {
+			a.detachServiceControlPolicy(fromPolicy, target)
+		}
+		a.add(ActionDelete, fromPolicy, "", fromPolicy.Policy, nil,
+			"organizations", "delete-policy",
+			"--policy-id", fromPolicy.id)
+	}
+}
+
+// deleteOrganizationalUnits deletes OUs, children first, once their accounts have been moved out
+func (a *awsSyncCmdGenerator) deleteOrganizationalUnits() {
+	units := sortedByDepth(a.from.OrganizationalUnits)
+	for i := len(units) - 1; i >= 0; i-- {
+		fromOu := units[i]
+		if found, _ := a.to.FindOrganizationalUnitByPath(fromOu.OuPath()); found {
+			continue
+		}
+		a.add(ActionDelete, fromOu, "", fromOu, nil,
+			"organizations", "delete-organizational-unit",
+			"--organizational-unit-id", fromOu.id)
+	}
+}
+
+// regionalArgs are the aws cli args for an operation on a resource policy in its region
+func regionalArgs(rp *ResourcePolicy, operation string, args ...interface{}) []interface{} {
+	return append([]interface{}{rp.ServiceName, operation, "--region", rp.Region}, args...)
+}
+
+// putResourcePolicyArgs are the aws cli args that set the resource policy of rp
+func (a *awsSyncCmdGenerator) putResourcePolicyArgs(rp *ResourcePolicy) ([]interface{}, error) {
+	policy := compactJson(rp.Policy)
+
+	switch rp.ServiceName {
+	case "sqs":
+		url, err := queueUrl(rp.Region, a.to.Account.Id, rp.Name)
+		if err != nil {
+			return nil, err
+		}
+		return regionalArgs(rp, "set-queue-attributes",
+			"--queue-url", url,
+			"--attributes", map[string]string{"Policy": policy}), nil
+	case "sns":
+		return regionalArgs(rp, "set-topic-attributes",
+			"--topic-arn", fmt.Sprintf("arn:%s:sns:%s:%s:%s", regionPartition(rp.Region), rp.Region, a.to.Account.Id, rp.Name),
+			"--attribute-name", "Policy",
+			"--attribute-value", policy), nil
+	case "kms":
+		return regionalArgs(rp, "put-key-policy",
+			"--key-id", rp.Name,
+			"--policy-name", "default",
+			"--policy", policy), nil
+	case "ecr":
+		return regionalArgs(rp, "set-repository-policy",
+			"--repository-name", rp.Name,
+			"--policy-text", policy), nil
+	case "secretsmanager":
+		return regionalArgs(rp, "put-resource-policy",
+			"--secret-id", rp.Name,
+			"--resource-policy", policy), nil
+	}
+	return nil, errors.Errorf("unsupported service %s", rp.ServiceName)
+}
+
+// deleteResourcePolicyArgs are the aws cli args that remove the resource policy of rp,
+// or nil if the service doesn't allow a resource to be without a policy
+func (a *awsSyncCmdGenerator) deleteResourcePolicyArgs(rp *ResourcePolicy) ([]interface{}, error) {
+	switch rp.ServiceName {
+	case "sqs":
+		url, err := queueUrl(rp.Region, a.to.Account.Id, rp.Name)
+		if err != nil {
+			return nil, err
+		}
+		return regionalArgs(rp, "set-queue-attributes",
+			"--queue-url", url,
+			"--attributes", map[string]string{"Policy": ""}), nil
+	case "ecr":
+		return regionalArgs(rp, "delete-repository-policy",
+			"--repository-name", rp.Name), nil
+	case "secretsmanager":
+		return regionalArgs(rp, "delete-resource-policy",
+			"--secret-id", rp.Name), nil
+	}
+	return nil, nil
+}
+
+func (a *awsSyncCmdGenerator) updateResourcePolicies() {
+	for _, fromPolicy := range a.from.ResourcePolicies {
+		if found, _ := a.to.FindResourcePolicy(fromPolicy.ServiceName, fromPolicy.Region, fromPolicy.Name); found {
+			continue
+		}
+
+		if fromPolicy.ServiceName == "lambda" {
+			a.updateLambdaPermissions(fromPolicy, fromPolicy.Policy, nil)
+		} else if args, err := a.deleteResourcePolicyArgs(fromPolicy); err != nil {
+			a.plan.warn("Can't remove the policy of %s %s in %s: %s", fromPolicy.ServiceName, fromPolicy.Name, fromPolicy.Region, err)
+		} else if args != nil {
+			a.add(ActionDelete, fromPolicy, "", fromPolicy.Policy, nil, args...)
+		} else {
+			a.plan.warn("The policy of %s %s in %s can't be removed, so is left as it is", fromPolicy.ServiceName, fromPolicy.Name, fromPolicy.Region)
+		}
+	}
+
+	for _, toPolicy := range a.to.ResourcePolicies {
+		found, fromPolicy := a.from.FindResourcePolicy(toPolicy.ServiceName, toPolicy.Region, toPolicy.Name)
+		if found && fromPolicy.Policy.JsonString() == toPolicy.Policy.JsonString() {
+			continue
+		}
+
+		if toPolicy.ServiceName == "lambda" {
+			var before *PolicyDocument
+			if found {
+				before = fromPolicy.Policy
+			}
+			a.updateLambdaPermissions(toPolicy, before, toPolicy.Policy)
+			continue
+		}
+
+		args, err := a.putResourcePolicyArgs(toPolicy)
+		if err != nil {
+			a.plan.warn("Can't set the policy of %s %s in %s: %s", toPolicy.ServiceName, toPolicy.Name, toPolicy.Region, err)
+		} else if found {
+			a.add(ActionUpdate, toPolicy, "", fromPolicy.Policy, toPolicy.Policy, args...)
+		} else {
+			a.add(ActionCreate, toPolicy, "", nil, toPolicy.Policy, args...)
+		}
+	}
+}
+
+// updateLambdaPermissions syncs the policy of a lambda function, which can
+// only be changed a statement at a time, by its Sid
+func (a *awsSyncCmdGenerator) updateLambdaPermissions(rp *ResourcePolicy, fromPolicy, toPolicy *PolicyDocument) {
+	fromStatements := statementList(policyDocumentMap(fromPolicy)["Statement"])
+	toStatements := statementList(policyDocumentMap(toPolicy)["Statement"])
+
+	findBySid := func(statements []map[string]interface{}, s map[string]interface{}) map[string]interface{} {
+		for _, st := range statements {
+			if sid(st) == sid(s) {
+				return st
+			}
+		}
+		return nil
+	}
+
+	// remove old and changed permissions
+	for _, s := range fromStatements {
+		if to := findBySid(toStatements, s); to != nil && reflect.DeepEqual(s, to) {
+			continue
+		}
+		a.add(ActionDetach, rp, sid(s), s, nil,
+			regionalArgs(rp, "remove-permission",
+				"--function-name", rp.Name,
+				"--statement-id", sid(s))...)
+	}
+
+	// add new and changed permissions
+	for _, s := range toStatements {
+		if from := findBySid(fromStatements, s); from != nil && reflect.DeepEqual(s, from) {
+			continue
+		}
+		args, err := lambdaPermissionArgs(s)
+		if err != nil {
+			a.plan.warn("Can't add a permission to lambda function %s in %s: %s", rp.Name, rp.Region, err)
+			continue
+		}
+		a.add(ActionAttach, rp, sid(s), nil, s,
+			regionalArgs(rp, "add-permission", append([]interface{}{"--function-name", rp.Name}, args...)...)...)
+	}
+}
+
+func (a *awsSyncCmdGenerator) GeneratePlan() (*Plan, error) {
 	a.updatePolicies()
+	a.updateIdentityProviders()
 	a.updateRoles()
 	a.updateGroups()
 	a.updateUsers()
 	a.updateInstanceProfiles()
 	a.updateBucketPolicies()
+	a.updateAccountPublicAccessBlock()
+	a.checkPublicBucketPolicies()
+	a.updateResourcePolicies()
+	a.updateAccountAlias()
+	a.updateAccountPasswordPolicy()
+	a.updateOrganization()
 	a.deleteOldEntities()
+	if a.err != nil {
+		return nil, a.err
+	}
+	if err := a.orderSteps(); err != nil {
+		return nil, err
+	}
 
-	return a.cmds
+	return &a.plan, nil
 }
 
-func AwsCliCmdsForSync(from, to *AccountData) CmdList {
-	a := awsSyncCmdGenerator{from, to, CmdList{}}
-	return a.GenerateCmds()
+// PlanForSync generates the Plan to sync AWS account data from one state to another
+func PlanForSync(from, to *AccountData) (*Plan, error) {
+	a := awsSyncCmdGenerator{from: from, to: to}
+	return a.GeneratePlan()
+}
+
+// AwsCliCmdsForSync generates the aws cli commands to sync AWS account data from one state to another
+func AwsCliCmdsForSync(from, to *AccountData) (CmdList, error) {
+	plan, err := PlanForSync(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return plan.CmdList(), nil
 }
@@ -3,8 +3,11 @@ package iamy
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
 )
 
 func loadDataFrom(p string) *AccountData {
@@ -24,10 +27,37 @@ func loadDataFrom(p string) *AccountData {
 	return &dd[0]
 }
 
+func planForSync(t *testing.T, from, to *AccountData) *Plan {
+	t.Helper()
+	plan, err := PlanForSync(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func cmdsForSync(t *testing.T, from, to *AccountData) CmdList {
+	t.Helper()
+	return planForSync(t, from, to).CmdList()
+}
+
+func TestAStepThatCantBePlannedIsAnError(t *testing.T) {
+	a := awsSyncCmdGenerator{from: &AccountData{Account: &Account{Id: "123"}}, to: &AccountData{Account: &Account{Id: "123"}}}
+	user := &User{iamService: iamService{Name: "billy", Path: "/"}}
+	a.add(ActionDelete, user, "", nil, nil, "iam", "delete-user", "--colour", "blue")
+
+	_, err := a.GeneratePlan()
+
+	expected := "Error planning delete iam/user billy: Unknown option --colour of iam delete-user"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+}
+
 func TestPolicyIsDetachedFromRoleBeforeUpdate(t *testing.T) {
 	localData := loadDataFrom("testcase1-local")
 	remoteData := loadDataFrom("testcase1-remote")
-	awsCmds := AwsCliCmdsForSync(remoteData, localData)
+	awsCmds := cmdsForSync(t, remoteData, localData)
 
 	expected := strings.Join([]string{
 		"aws iam detach-role-policy --role-name testrole --policy-arn arn:aws:iam::123:policy/test",
@@ -44,3 +74,690 @@ Actual:
 
 	}
 }
+
+func TestPlanStepsDescribeChanges(t *testing.T) {
+	localData := loadDataFrom("testcase1-local")
+	remoteData := loadDataFrom("testcase1-remote")
+	plan := planForSync(t, remoteData, localData)
+
+	expected := []Step{
+		{
+			ID:              1,
+			Action:          ActionDetach,
+			ResourceType:    "iam/role",
+			ResourceName:    "testrole",
+			ResourcePath:    "/",
+			Target:          "arn:aws:iam::123:policy/test",
+			Destructiveness: Revokes,
+		},
+		{
+			ID:              2,
+			Action:          ActionDelete,
+			ResourceType:    "iam/policy",
+			ResourceName:    "test",
+			ResourcePath:    "/",
+			Destructiveness: Deletes,
+			DependsOn:       []int{1},
+		},
+	}
+
+	if len(plan.Steps) != len(expected) {
+		t.Fatalf("Expected %d steps, got %d", len(expected), len(plan.Steps))
+	}
+	for i, step := range plan.Steps {
+		actual := *step
+		actual.Before, actual.After, actual.Cmd = nil, nil, Cmd{}
+		if !reflect.DeepEqual(actual, expected[i]) {
+			t.Errorf("Step %d: expected %#v, got %#v", i+1, expected[i], actual)
+		}
+	}
+
+	if plan.CountDestructive() != 2 {
+		t.Errorf("Expected 2 destructive steps, got %d", plan.CountDestructive())
+	}
+}
+
+func TestDeletingInlinePoliciesIsCountedAsDeletes(t *testing.T) {
+	doc, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inline := []InlinePolicy{{Name: "read", Policy: doc}}
+	from := &AccountData{
+		Account: &Account{Id: "123"},
+		Users:   []*User{{iamService: iamService{Name: "alice", Path: "/"}, InlinePolicies: inline}},
+		Groups:  []*Group{{iamService: iamService{Name: "developers", Path: "/"}, InlinePolicies: inline}},
+		Roles:   []*Role{{iamService: iamService{Name: "worker", Path: "/"}, AssumeRolePolicyDocument: doc, InlinePolicies: inline}},
+	}
+	to := &AccountData{
+		Account: &Account{Id: "123"},
+		Users:   []*User{{iamService: iamService{Name: "alice", Path: "/"}}},
+		Groups:  []*Group{{iamService: iamService{Name: "developers", Path: "/"}}},
+		Roles:   []*Role{{iamService: iamService{Name: "worker", Path: "/"}, AssumeRolePolicyDocument: doc}},
+	}
+
+	plan := planForSync(t, from, to)
+	if len(plan.Steps) != 3 {
+		t.Fatalf("Expected 3 steps, got %d", len(plan.Steps))
+	}
+	for _, s := range plan.Steps {
+		if s.Action != ActionDelete || s.Destructiveness != Deletes {
+			t.Errorf("Expected %s to be a delete, got %s (%s)", s.Cmd, s.Action, s.Destructiveness)
+		}
+	}
+}
+
+func TestTagsAreSyncedOnRolesPoliciesAndInstanceProfiles(t *testing.T) {
+	doc := &PolicyDocument{data: map[string]interface{}{"Version": "2012-10-17"}}
+	from := &AccountData{
+		Account:          &Account{Id: "123"},
+		Roles:            []*Role{{iamService: iamService{Name: "r", Path: "/"}, AssumeRolePolicyDocument: doc, Tags: map[string]string{"owner": "a", "old": "x"}}},
+		Policies:         []*Policy{{iamService: iamService{Name: "p", Path: "/"}, Policy: doc}},
+		InstanceProfiles: []*InstanceProfile{{iamService: iamService{Name: "i", Path: "/"}, Tags: map[string]string{"owner": "a"}}},
+	}
+	to := &AccountData{
+		Account:          &Account{Id: "123"},
+		Roles:            []*Role{{iamService: iamService{Name: "r", Path: "/"}, AssumeRolePolicyDocument: doc, Tags: map[string]string{"owner": "b"}}},
+		Policies:         []*Policy{{iamService: iamService{Name: "p", Path: "/"}, Policy: doc, Tags: map[string]string{"owner": "c"}}},
+		InstanceProfiles: []*InstanceProfile{{iamService: iamService{Name: "i", Path: "/"}, Tags: map[string]string{"owner": "a"}}},
+	}
+
+	expected := strings.Join([]string{
+		"aws iam tag-policy --policy-arn arn:aws:iam::123:policy/p --tags Key=owner,Value=c",
+		"aws iam untag-role --role-name r --tag-keys old",
+		"aws iam tag-role --role-name r --tags Key=owner,Value=b",
+	}, "\n")
+	actual := cmdsForSync(t, from, to).String()
+
+	if actual != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestPermissionsBoundaryRemovalIsDestructive(t *testing.T) {
+	from := &AccountData{
+		Account: &Account{Id: "123"},
+		Users: []*User{
+			{iamService: iamService{Name: "a", Path: "/"}, PermissionsBoundary: "boundary"},
+			{iamService: iamService{Name: "b", Path: "/"}},
+		},
+	}
+	to := &AccountData{
+		Account: &Account{Id: "123"},
+		Users: []*User{
+			{iamService: iamService{Name: "a", Path: "/"}},
+			{iamService: iamService{Name: "b", Path: "/"}, PermissionsBoundary: "arn:aws:iam::aws:policy/PowerUserAccess"},
+		},
+	}
+
+	plan := planForSync(t, from, to)
+	expected := strings.Join([]string{
+		"aws iam delete-user-permissions-boundary --user-name a",
+		"aws iam put-user-permissions-boundary --user-name b --permissions-boundary arn:aws:iam::aws:policy/PowerUserAccess",
+	}, "\n")
+
+	if plan.String() != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, plan.String())
+	}
+	if !plan.Steps[0].IsDestructive() || plan.Steps[1].IsDestructive() {
+		t.Error("Expected only the boundary removal to be destructive")
+	}
+}
+
+func TestRoleDescriptionAndMaxSessionDurationAreSynced(t *testing.T) {
+	doc := &PolicyDocument{data: map[string]interface{}{"Version": "2012-10-17"}}
+	from := &AccountData{
+		Account: &Account{Id: "123"},
+		Roles: []*Role{
+			{iamService: iamService{Name: "a", Path: "/"}, AssumeRolePolicyDocument: doc, Description: "old"},
+			{iamService: iamService{Name: "b", Path: "/"}, AssumeRolePolicyDocument: doc, MaxSessionDuration: 7200},
+		},
+	}
+	to := &AccountData{
+		Account: &Account{Id: "123"},
+		Roles: []*Role{
+			{iamService: iamService{Name: "a", Path: "/"}, AssumeRolePolicyDocument: doc, Description: "new", MaxSessionDuration: DefaultMaxSessionDuration},
+			{iamService: iamService{Name: "b", Path: "/"}, AssumeRolePolicyDocument: doc},
+		},
+	}
+
+	expected := strings.Join([]string{
+		"aws iam update-role-description --role-name a --description new",
+		"aws iam update-role --role-name b --max-session-duration 3600",
+	}, "\n")
+	actual := cmdsForSync(t, from, to).String()
+
+	if actual != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestAccountPasswordPolicyIsSynced(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinimumPasswordLength: 14,
+		RequireSymbols:        true,
+		MaxPasswordAge:        90,
+	}
+	updateCmd := "aws iam update-account-password-policy --require-symbols --no-require-numbers --no-require-uppercase-characters --no-require-lowercase-characters --no-allow-users-to-change-password --no-hard-expiry --minimum-password-length 14 --max-password-age 90"
+
+	tests := []struct {
+		name           string
+		from, to       *PasswordPolicy
+		noPolicy       bool
+		expectedAction Action
+		expected       string
+	}{
+		{"create", nil, policy, false, ActionCreate, updateCmd},
+		{"update", &PasswordPolicy{MinimumPasswordLength: 8}, policy, false, ActionUpdate, updateCmd},
+		{"unchanged", policy, policy, false, "", ""},
+		{"default minimum length", &PasswordPolicy{MinimumPasswordLength: DefaultMinimumPasswordLength}, &PasswordPolicy{}, false, "", ""},
+		{"options left out", &PasswordPolicy{RequireNumbers: true}, &PasswordPolicy{}, false, ActionUpdate, "aws iam update-account-password-policy --no-require-symbols --no-require-numbers --no-require-uppercase-characters --no-require-lowercase-characters --no-allow-users-to-change-password --no-hard-expiry"},
+		{"delete", policy, nil, true, ActionDelete, "aws iam delete-account-password-policy"},
+		{"delete when there is none", nil, nil, true, "", ""},
+		{"unmanaged", policy, nil, false, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := &Account{Id: "123"}
+			from := &AccountData{Account: account, PasswordPolicy: tt.from}
+			to := &AccountData{Account: account, PasswordPolicy: tt.to, noPasswordPolicy: tt.noPolicy}
+
+			plan := planForSync(t, from, to)
+			if actual := plan.CmdList().String(); actual != tt.expected {
+				t.Errorf("Expected:\n%s\nActual:\n%s", tt.expected, actual)
+			}
+			if len(plan.Steps) == 1 && plan.Steps[0].Action != tt.expectedAction {
+				t.Errorf("Expected action %s, got %s", tt.expectedAction, plan.Steps[0].Action)
+			}
+		})
+	}
+}
+
+func TestIdentityProvidersAreSynced(t *testing.T) {
+	account := &Account{Id: "123"}
+	github := iamService{Name: "token.actions.githubusercontent.com", Path: "/"}
+	from := &AccountData{
+		Account: account,
+		Roles: []*Role{
+			{iamService: iamService{Name: "deploy", Path: "/"}, AssumeRolePolicyDocument: &PolicyDocument{}},
+		},
+		SamlProviders: []*SamlProvider{
+			{iamService: iamService{Name: "Okta", Path: "/"}, SAMLMetadataDocument: "<old/>"},
+			{iamService: iamService{Name: "Legacy", Path: "/"}, SAMLMetadataDocument: "<legacy/>"},
+		},
+		OidcProviders: []*OidcProvider{
+			{iamService: github, ClientIDList: []string{"sigstore"}, ThumbprintList: []string{"aaaa"}},
+		},
+	}
+	to := &AccountData{
+		Account: account,
+		SamlProviders: []*SamlProvider{
+			{iamService: iamService{Name: "Okta", Path: "/"}, SAMLMetadataDocument: "<new/>"},
+		},
+		OidcProviders: []*OidcProvider{
+			{iamService: github, ClientIDList: []string{"sts.amazonaws.com"}, ThumbprintList: []string{"aaaa", "bbbb"}, Tags: map[string]string{"team": "ops"}},
+		},
+	}
+
+	expected := strings.Join([]string{
+		"aws iam update-saml-provider --saml-provider-arn arn:aws:iam::123:saml-provider/Okta --saml-metadata-document <new/>",
+		"aws iam remove-client-id-from-open-id-connect-provider --open-id-connect-provider-arn arn:aws:iam::123:oidc-provider/token.actions.githubusercontent.com --client-id sigstore",
+		"aws iam add-client-id-to-open-id-connect-provider --open-id-connect-provider-arn arn:aws:iam::123:oidc-provider/token.actions.githubusercontent.com --client-id sts.amazonaws.com",
+		"aws iam update-open-id-connect-provider-thumbprint --open-id-connect-provider-arn arn:aws:iam::123:oidc-provider/token.actions.githubusercontent.com --thumbprint-list aaaa bbbb",
+		"aws iam tag-open-id-connect-provider --open-id-connect-provider-arn arn:aws:iam::123:oidc-provider/token.actions.githubusercontent.com --tags Key=team,Value=ops",
+		"aws iam delete-role --role-name deploy",
+		"aws iam delete-saml-provider --saml-provider-arn arn:aws:iam::123:saml-provider/Legacy",
+	}, "\n")
+	if actual := cmdsForSync(t, from, to).String(); actual != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestAccountAliasIsSynced(t *testing.T) {
+	tests := []struct {
+		name           string
+		from, to       string
+		noAlias        bool
+		expectedAction Action
+		expected       string
+	}{
+		{"unchanged", "myalias", "myalias", false, "", ""},
+		{"create", "", "myalias", false, ActionCreate, "aws iam create-account-alias --account-alias myalias"},
+		{"update", "oldalias", "myalias", false, ActionUpdate, "aws iam create-account-alias --account-alias myalias"},
+		{"unmanaged", "myalias", "", false, "", ""},
+		{"delete", "myalias", "", true, ActionDelete, "aws iam delete-account-alias --account-alias myalias"},
+		{"delete when there is none", "", "", true, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := &AccountData{Account: &Account{Id: "123", Alias: tt.from}}
+			to := &AccountData{Account: &Account{Id: "123", Alias: tt.to}, noAccountAlias: tt.noAlias}
+
+			plan := planForSync(t, from, to)
+			if actual := plan.CmdList().String(); actual != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, actual)
+			}
+			if len(plan.Steps) == 1 && plan.Steps[0].Action != tt.expectedAction {
+				t.Errorf("Expected action %s, got %s", tt.expectedAction, plan.Steps[0].Action)
+			}
+		})
+	}
+}
+
+func TestUserCredentialExpectations(t *testing.T) {
+	account := &Account{Id: "123"}
+	noKeys := 0
+	from := &AccountData{
+		Account: account,
+		Users: []*User{
+			{iamService: iamService{Name: "alice", Path: "/"}, credentials: userCredentials{HasLoginProfile: true}},
+			{iamService: iamService{Name: "bob", Path: "/"}, credentials: userCredentials{HasLoginProfile: true, AccessKeyIds: []string{"AKIA1"}}},
+		},
+	}
+	to := &AccountData{
+		Account: account,
+		Users: []*User{
+			{iamService: iamService{Name: "alice", Path: "/"}, ConsoleAccess: aws.Bool(true), MFARequired: true},
+			{iamService: iamService{Name: "bob", Path: "/"}, ConsoleAccess: aws.Bool(false), MaxAccessKeys: &noKeys},
+		},
+	}
+	plan := planForSync(t, from, to)
+
+	if actual := plan.String(); actual != "aws iam delete-login-profile --user-name bob" {
+		t.Errorf("Expected the login profile to be deleted, got:\n%s", actual)
+	}
+
+	expectedWarnings := []string{
+		"User alice has console access but no MFA device",
+		"User bob has 1 access keys, more than the maximum of 0: AKIA1",
+	}
+	if !reflect.DeepEqual(plan.Warnings, expectedWarnings) {
+		t.Errorf("Expected warnings %#v, got %#v", expectedWarnings, plan.Warnings)
+	}
+}
+
+func TestDeletingUserRemovesCredentials(t *testing.T) {
+	account := &Account{Id: "123"}
+	from := &AccountData{
+		Account: account,
+		Users: []*User{
+			{iamService: iamService{Name: "alice", Path: "/"}, credentials: userCredentials{
+				AccessKeyIds:    []string{"AKIA1"},
+				HasLoginProfile: true,
+			}},
+		},
+	}
+	to := &AccountData{Account: account}
+
+	expected := strings.Join([]string{
+		"aws iam delete-access-key --user-name alice --access-key-id AKIA1",
+		"aws iam delete-login-profile --user-name alice",
+		"aws iam delete-user --user-name alice",
+	}, "\n")
+	if actual := cmdsForSync(t, from, to).String(); actual != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestSSHPublicKeysAreSynced(t *testing.T) {
+	keys := func(kk ...string) *[]string { return &kk }
+	fetched := userCredentials{SSHPublicKeyIds: map[string]string{
+		"ssh-ed25519 AAAAold":  "APKAOLD",
+		"ssh-ed25519 AAAAkept": "APKAKEPT",
+	}}
+
+	tests := []struct {
+		name     string
+		exists   bool
+		keys     *[]string
+		expected []string
+	}{
+		{"unmanaged", true, nil, nil},
+		{"delete all", true, keys(), []string{
+			"aws iam delete-ssh-public-key --user-name alice --ssh-public-key-id APKAKEPT",
+			"aws iam delete-ssh-public-key --user-name alice --ssh-public-key-id APKAOLD",
+		}},
+		{"update", true, keys("ssh-ed25519 AAAAkept alice@laptop", "ssh-ed25519 AAAAnew alice@desktop"), []string{
+			"aws iam delete-ssh-public-key --user-name alice --ssh-public-key-id APKAOLD",
+			"aws iam upload-ssh-public-key --user-name alice --ssh-public-key-body 'ssh-ed25519 AAAAnew alice@desktop'",
+		}},
+		{"unchanged", true, keys("ssh-ed25519 AAAAold", "ssh-ed25519 AAAAkept"), nil},
+		{"create user", false, keys("ssh-ed25519 AAAAnew"), []string{
+			"aws iam create-user --user-name alice --path /",
+			"aws iam upload-ssh-public-key --user-name alice --ssh-public-key-body 'ssh-ed25519 AAAAnew'",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := &Account{Id: "123"}
+			from := &AccountData{Account: account}
+			if tt.exists {
+				from.Users = []*User{{iamService: iamService{Name: "alice", Path: "/"}, credentials: fetched}}
+			}
+			to := &AccountData{
+				Account: account,
+				Users:   []*User{{iamService: iamService{Name: "alice", Path: "/"}, SSHPublicKeys: tt.keys}},
+			}
+
+			if actual, expected := cmdsForSync(t, from, to).String(), strings.Join(tt.expected, "\n"); actual != expected {
+				t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestQueueAndTopicPoliciesUseTheirRegionsPartition(t *testing.T) {
+	policy := &PolicyDocument{data: map[string]interface{}{"Statement": []interface{}{}}}
+	tests := []struct {
+		region   string
+		expected []string
+	}{
+		{"us-east-1", []string{
+			`aws sqs set-queue-attributes --region us-east-1 --queue-url https://sqs.us-east-1.amazonaws.com/123/jobs --attributes {"Policy":""}`,
+			`aws sns set-topic-attributes --region us-east-1 --topic-arn arn:aws:sns:us-east-1:123:events --attribute-name Policy --attribute-value {"Statement":[]}`,
+		}},
+		{"cn-north-1", []string{
+			`aws sqs set-queue-attributes --region cn-north-1 --queue-url https://sqs.cn-north-1.amazonaws.com.cn/123/jobs --attributes {"Policy":""}`,
+			`aws sns set-topic-attributes --region cn-north-1 --topic-arn arn:aws-cn:sns:cn-north-1:123:events --attribute-name Policy --attribute-value {"Statement":[]}`,
+		}},
+		{"us-gov-west-1", []string{
+			`aws sqs set-queue-attributes --region us-gov-west-1 --queue-url https://sqs.us-gov-west-1.amazonaws.com/123/jobs --attributes {"Policy":""}`,
+			`aws sns set-topic-attributes --region us-gov-west-1 --topic-arn arn:aws-us-gov:sns:us-gov-west-1:123:events --attribute-name Policy --attribute-value {"Statement":[]}`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			account := &Account{Id: "123"}
+			from := &AccountData{
+				Account:          account,
+				ResourcePolicies: []*ResourcePolicy{{ServiceName: "sqs", Region: tt.region, Name: "jobs", Policy: policy}},
+			}
+			to := &AccountData{
+				Account:          account,
+				ResourcePolicies: []*ResourcePolicy{{ServiceName: "sns", Region: tt.region, Name: "events", Policy: policy}},
+			}
+
+			if actual, expected := cmdsForSync(t, from, to).String(), strings.Join(tt.expected, "\n"); actual != expected {
+				t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestResourcePoliciesAreSynced(t *testing.T) {
+	localData := loadDataFrom("resourcepolicies-local")
+	remoteData := &AccountData{
+		Account: localData.Account,
+		ResourcePolicies: []*ResourcePolicy{
+			{ServiceName: "sqs", Region: "us-east-1", Name: "jobs", Policy: &PolicyDocument{data: map[string]interface{}{"Statement": []interface{}{}}}},
+			{ServiceName: "kms", Region: "us-east-1", Name: "1234abcd", Policy: &PolicyDocument{data: map[string]interface{}{"Statement": []interface{}{}}}},
+		},
+	}
+	plan := planForSync(t, remoteData, localData)
+
+	expected := strings.Join([]string{
+		"aws lambda add-permission --region us-east-1 --function-name thumbnailer --statement-id s3-invoke --action lambda:InvokeFunction --principal s3.amazonaws.com --source-arn arn:aws:s3:::uploads --source-account 123",
+		`aws secretsmanager put-resource-policy --region us-east-1 --secret-id prod/db/password --resource-policy {"Statement":[{"Action":"secretsmanager:DeleteSecret","Effect":"Deny","Principal":"*","Resource":"*"}],"Version":"2012-10-17"}`,
+		`aws sqs set-queue-attributes --region us-east-1 --queue-url https://sqs.us-east-1.amazonaws.com/123/jobs --attributes {"Policy":"{\"Statement\":[{\"Action\":\"sqs:SendMessage\",\"Effect\":\"Allow\",\"Principal\":{\"Service\":\"sns.amazonaws.com\"},\"Resource\":\"arn:aws:sqs:us-east-1:123:jobs\",\"Sid\":\"AllowSns\"}],\"Version\":\"2012-10-17\"}"}`,
+	}, "\n")
+	if actual := plan.String(); actual != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+
+	expectedWarnings := []string{"The policy of kms 1234abcd in us-east-1 can't be removed, so is left as it is"}
+	if !reflect.DeepEqual(plan.Warnings, expectedWarnings) {
+		t.Errorf("Expected warnings %#v, got %#v", expectedWarnings, plan.Warnings)
+	}
+
+	if f := ResourceFile(localData.Account, localData.ResourcePolicies[0]); !strings.HasPrefix(f, "myalias-123/") || strings.Contains(f, "//") {
+		t.Errorf("Unexpected resource file %s", f)
+	}
+}
+
+func TestLambdaPermissionArgsRejectsUnsupportedStatements(t *testing.T) {
+	_, err := lambdaPermissionArgs(map[string]interface{}{
+		"Sid":       "a",
+		"Effect":    "Allow",
+		"Principal": map[string]interface{}{"Service": "s3.amazonaws.com"},
+		"Action":    "lambda:InvokeFunction",
+		"Condition": map[string]interface{}{"IpAddress": map[string]interface{}{"aws:SourceIp": "10.0.0.0/8"}},
+	})
+	if err == nil {
+		t.Error("Expected an error for a condition add-permission can't create")
+	}
+}
+
+func TestResourcePolicyOfUnsupportedServiceIsAWarning(t *testing.T) {
+	to := &AccountData{
+		Account: &Account{Id: "123"},
+		ResourcePolicies: []*ResourcePolicy{
+			{ServiceName: "glacier", Region: "us-east-1", Name: "archive", Policy: &PolicyDocument{data: map[string]interface{}{"Statement": []interface{}{}}}},
+		},
+	}
+	plan := planForSync(t, &AccountData{Account: to.Account}, to)
+
+	if plan.Count() != 0 {
+		t.Errorf("Expected no steps, got:\n%s", plan)
+	}
+	expectedWarnings := []string{"Can't set the policy of glacier archive in us-east-1: unsupported service glacier"}
+	if !reflect.DeepEqual(plan.Warnings, expectedWarnings) {
+		t.Errorf("Expected warnings %#v, got %#v", expectedWarnings, plan.Warnings)
+	}
+}
+
+func TestPublicAccessBlockIsSynced(t *testing.T) {
+	publicPolicy, err := NewPolicyDocumentFromJson(`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::site/*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockAll := &PublicAccessBlock{true, true, true, true}
+
+	account := &Account{Id: "123"}
+	from := &AccountData{
+		Account: account,
+		BucketPolicies: []*BucketPolicy{
+			{BucketName: "site", Policy: publicPolicy},
+			{BucketName: "logs", PublicAccessBlock: blockAll},
+		},
+	}
+	to := &AccountData{
+		Account: account,
+		BucketPolicies: []*BucketPolicy{
+			{BucketName: "site", Policy: publicPolicy, PublicAccessBlock: &PublicAccessBlock{BlockPublicAcls: true, IgnorePublicAcls: true}},
+		},
+		PublicAccessBlock: &AccountPublicAccessBlock{PublicAccessBlock{RestrictPublicBuckets: true}},
+	}
+	plan := planForSync(t, from, to)
+
+	expected := strings.Join([]string{
+		"aws s3api put-public-access-block --bucket site --public-access-block-configuration BlockPublicAcls=true,BlockPublicPolicy=false,IgnorePublicAcls=true,RestrictPublicBuckets=false",
+		"aws s3control put-public-access-block --account-id 123 --public-access-block-configuration BlockPublicAcls=false,BlockPublicPolicy=false,IgnorePublicAcls=false,RestrictPublicBuckets=true",
+	}, "\n")
+	if actual := plan.String(); actual != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+	expectedWarnings := []string{"The policy of bucket site allows public access, but is ineffective as the account blocks public policies"}
+	if !reflect.DeepEqual(plan.Warnings, expectedWarnings) {
+		t.Errorf("Expected warnings %#v, got %#v", expectedWarnings, plan.Warnings)
+	}
+}
+
+func TestUnknownPublicAccessBlocksAreWarnings(t *testing.T) {
+	blockAll := &PublicAccessBlock{true, true, true, true}
+	bucketCmd := "aws s3api put-public-access-block --bucket site --public-access-block-configuration BlockPublicAcls=true,BlockPublicPolicy=true,IgnorePublicAcls=true,RestrictPublicBuckets=true"
+	accountCmd := "aws s3control put-public-access-block --account-id 123 --public-access-block-configuration BlockPublicAcls=true,BlockPublicPolicy=true,IgnorePublicAcls=true,RestrictPublicBuckets=true"
+	bucketWarning := "Block Public Access settings of bucket site can't be read, so they're left as they are"
+	accountWarning := "Block Public Access settings of account 123 can't be read, so they're left as they are"
+
+	tests := []struct {
+		name             string
+		accountUnknown   bool
+		unknownBuckets   []string
+		declared         bool
+		expected         []string
+		expectedWarnings []string
+	}{
+		{"known", false, nil, true, []string{bucketCmd, accountCmd}, nil},
+		{"account unknown", true, nil, true, []string{bucketCmd}, []string{accountWarning}},
+		{"bucket unknown", false, []string{"site"}, true, []string{accountCmd}, []string{bucketWarning}},
+		{"other bucket unknown", false, []string{"logs"}, true, []string{bucketCmd, accountCmd}, nil},
+		{"unknown and not declared", true, []string{"site"}, false, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := &Account{Id: "123"}
+			from := &AccountData{
+				Account:                         account,
+				publicAccessBlockUnknown:        tt.accountUnknown,
+				unknownBucketPublicAccessBlocks: tt.unknownBuckets,
+			}
+			to := &AccountData{Account: account}
+			if tt.declared {
+				to.BucketPolicies = []*BucketPolicy{{BucketName: "site", PublicAccessBlock: blockAll}}
+				to.PublicAccessBlock = &AccountPublicAccessBlock{*blockAll}
+			}
+
+			plan := planForSync(t, from, to)
+			if actual, expected := plan.String(), strings.Join(tt.expected, "\n"); actual != expected {
+				t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+			}
+			if !reflect.DeepEqual(plan.Warnings, tt.expectedWarnings) {
+				t.Errorf("Expected warnings %#v, got %#v", tt.expectedWarnings, plan.Warnings)
+			}
+		})
+	}
+}
+
+func TestServiceControlPoliciesAreSynced(t *testing.T) {
+	denyAll, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Action":"*","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denyRegions, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Deny","NotAction":"iam:*","Resource":"*","Condition":{"StringNotEquals":{"aws:RequestedRegion":"us-east-1"}}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account := &Account{Id: "111111111111"}
+	from := &AccountData{
+		Account:            account,
+		organizationRootId: "r-root",
+		OrganizationalUnits: []*OrganizationalUnit{
+			{organizationsService: organizationsService{Name: "Engineering", Path: "/"}, id: "ou-eng", Accounts: []string{"222222222222"}},
+			{organizationsService: organizationsService{Name: "Sandbox", Path: "/"}, id: "ou-sandbox", Accounts: []string{"333333333333"}},
+		},
+		ServiceControlPolicies: []*ServiceControlPolicy{
+			{organizationsService: organizationsService{Name: "DenyRegions", Path: "/"}, id: "p-regions", Policy: denyAll, Targets: []string{"Sandbox"}},
+			{organizationsService: organizationsService{Name: "Old", Path: "/"}, id: "p-old", Policy: denyAll, Targets: []string{"222222222222"}},
+		},
+	}
+	to := &AccountData{
+		Account: account,
+		OrganizationalUnits: []*OrganizationalUnit{
+			{organizationsService: organizationsService{Name: "Engineering", Path: "/"}, Accounts: []string{"222222222222", "333333333333"}},
+			{organizationsService: organizationsService{Name: "Prod", Path: "/Engineering/"}},
+			{organizationsService: organizationsService{Name: "Web", Path: "/Engineering/Prod/"}},
+		},
+		ServiceControlPolicies: []*ServiceControlPolicy{
+			{organizationsService: organizationsService{Name: "DenyRegions", Path: "/"}, Description: "Regions", Policy: denyRegions, Targets: []string{"Engineering", "Root"}},
+			{organizationsService: organizationsService{Name: "DenyAll", Path: "/"}, Policy: denyAll, Targets: []string{"Engineering/Prod"}},
+		},
+	}
+	plan := planForSync(t, from, to)
+
+	expected := strings.Join([]string{
+		"aws organizations create-organizational-unit --parent-id ou-eng --name Prod",
+		`aws organizations update-policy --policy-id p-regions --content '` + denyRegions.JsonString() + `'`,
+		"aws organizations update-policy --policy-id p-regions --description Regions",
+		"aws organizations attach-policy --policy-id p-regions --target-id ou-eng",
+		"aws organizations attach-policy --policy-id p-regions --target-id r-root",
+		"aws organizations detach-policy --policy-id p-regions --target-id ou-sandbox",
+		`aws organizations create-policy --name DenyAll --type SERVICE_CONTROL_POLICY --content '` + denyAll.JsonString() + `'`,
+		"aws organizations move-account --account-id 333333333333 --source-parent-id ou-sandbox --destination-parent-id ou-eng",
+		"aws organizations detach-policy --policy-id p-old --target-id 222222222222",
+		"aws organizations delete-policy --policy-id p-old",
+		"aws organizations delete-organizational-unit --organizational-unit-id ou-sandbox",
+	}, "\n")
+	if actual := plan.String(); actual != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+
+	expectedWarnings := []string{
+		"Organizational unit Engineering/Prod/Web can't be created until Engineering/Prod exists, run push again to create it",
+		"Service control policy DenyAll can't be attached until it exists, run push again to attach it",
+	}
+	if !reflect.DeepEqual(plan.Warnings, expectedWarnings) {
+		t.Errorf("Expected warnings %#v, got %#v", expectedWarnings, plan.Warnings)
+	}
+}
+
+func TestOrganizationIsSyncedByDirectory(t *testing.T) {
+	denyAll, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Action":"*","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := &Account{Id: "111111111111"}
+	from := &AccountData{
+		Account:            account,
+		organizationRootId: "r-root",
+		OrganizationalUnits: []*OrganizationalUnit{
+			{organizationsService: organizationsService{Name: "Engineering", Path: "/"}, id: "ou-eng", Accounts: []string{"222222222222"}},
+		},
+		ServiceControlPolicies: []*ServiceControlPolicy{
+			{organizationsService: organizationsService{Name: "DenyAll", Path: "/"}, id: "p-deny", Policy: denyAll, Targets: []string{"Engineering"}},
+		},
+	}
+	deletePolicy := []string{
+		"aws organizations detach-policy --policy-id p-deny --target-id ou-eng",
+		"aws organizations delete-policy --policy-id p-deny",
+	}
+	deleteUnit := []string{
+		"aws organizations move-account --account-id 222222222222 --source-parent-id ou-eng --destination-parent-id r-root",
+		"aws organizations delete-organizational-unit --organizational-unit-id ou-eng",
+	}
+
+	tests := []struct {
+		name             string
+		from             *AccountData
+		to               *AccountData
+		expected         []string
+		expectedWarnings []string
+	}{
+		{"no directories", from, &AccountData{Account: account}, nil, nil},
+		{"empty policy directory", from, &AccountData{Account: account, hasServiceControlPoliciesDir: true}, deletePolicy, nil},
+		{"empty ou directory", from, &AccountData{Account: account, hasOrganizationalUnitsDir: true}, deleteUnit, nil},
+		{"both empty directories", from, &AccountData{Account: account, hasOrganizationalUnitsDir: true, hasServiceControlPoliciesDir: true}, []string{
+			"aws organizations move-account --account-id 222222222222 --source-parent-id ou-eng --destination-parent-id r-root",
+			"aws organizations detach-policy --policy-id p-deny --target-id ou-eng",
+			"aws organizations delete-policy --policy-id p-deny",
+			"aws organizations delete-organizational-unit --organizational-unit-id ou-eng",
+		}, nil},
+		{"policy without ou directory", from, &AccountData{
+			Account:                from.Account,
+			ServiceControlPolicies: []*ServiceControlPolicy{{organizationsService: organizationsService{Name: "DenyAll", Path: "/"}, Policy: denyAll, Targets: []string{"Engineering"}}},
+		}, nil, nil},
+		{"unit without policy directory", from, &AccountData{
+			Account:             from.Account,
+			OrganizationalUnits: []*OrganizationalUnit{{organizationsService: organizationsService{Name: "Engineering", Path: "/"}}},
+		}, deleteUnit[:1], nil},
+		{"not the management account", &AccountData{Account: account}, &AccountData{Account: account, hasOrganizationalUnitsDir: true}, nil, []string{
+			"Account 111111111111 isn't the management account of an organization, so its organizations files are ignored",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := planForSync(t, tt.from, tt.to)
+			if actual, expected := plan.String(), strings.Join(tt.expected, "\n"); actual != expected {
+				t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+			}
+			if !reflect.DeepEqual(plan.Warnings, tt.expectedWarnings) {
+				t.Errorf("Expected warnings %#v, got %#v", tt.expectedWarnings, plan.Warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package iamy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+)
+
+// An Executor applies Cmds directly with the AWS SDK, calling the
+// operation of each Cmd with the input built when the plan was generated
+type Executor struct {
+	iam      *iamClient
+	s3       *s3Client
+	regional *regionalClients
+}
+
+// NewExecutor returns an Executor using the default AWS session
+func NewExecutor() *Executor {
+	return NewExecutorWithSession(awsSession())
+}
+
+// NewExecutorWithSession returns an Executor using the AWS session s
+func NewExecutorWithSession(s *session.Session) *Executor {
+	return NewExecutorWithClients(s, Clients{})
+}
+
+// NewExecutorWithClients returns an Executor using c instead of
+// the clients for the AWS session s, where they're set
+func NewExecutorWithClients(s *session.Session, c Clients) *Executor {
+	return &Executor{
+		iam:      c.iamClient(s),
+		s3:       c.s3Client(s),
+		regional: c.regionalClients(s),
+	}
+}
+
+// CmdError is returned when executing a Cmd fails
+type CmdError struct {
+	Cmd Cmd
+	Err error
+}
+
+func (e *CmdError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Cmd.operation(), e.Err)
+}
+
+// Cause returns the underlying error
+func (e *CmdError) Cause() error {
+	return e.Err
+}
+
+// Code returns the AWS error code, or an empty string if the
+// failure didn't come from AWS
+func (e *CmdError) Code() string {
+	if awsErr, ok := e.Err.(awserr.Error); ok {
+		return awsErr.Code()
+	}
+	return ""
+}
+
+// Exec executes c against AWS
+func (e *Executor) Exec(c Cmd) error {
+	if err := e.exec(c); err != nil {
+		return &CmdError{Cmd: c, Err: err}
+	}
+	return nil
+}
+
+func (e *Executor) exec(c Cmd) error {
+	if c.Operation == "" {
+		return fmt.Errorf("no AWS operation to run for %s", c)
+	}
+
+	var client interface{}
+	switch c.Service {
+	case "iam":
+		client = e.iam.IAMAPI
+	case "s3api":
+		client = e.s3.S3API
+	default:
+		region := c.Region
+		if region == "" {
+			region = DefaultRegion()
+		}
+		var err error
+		if client, err = e.regional.get(c.Service, region); err != nil {
+			return err
+		}
+	}
+
+	method := reflect.ValueOf(client).MethodByName(c.Operation)
+	if !method.IsValid() || !isOperation(method.Type(), 0) {
+		return fmt.Errorf("unknown operation %s", c.Operation)
+	}
+	input, err := c.input(method.Type().In(0))
+	if err != nil {
+		return err
+	}
+
+	if c.Service == "s3api" {
+		if client, err = e.s3ClientForInput(input); err != nil {
+			return err
+		}
+		method = reflect.ValueOf(client).MethodByName(c.Operation)
+	}
+
+	out := method.Call([]reflect.Value{input})
+	if err, ok := out[1].Interface().(error); ok && err != nil {
+		return err
+	}
+	return nil
+}
+
+// input is the Cmd's Input as the inputType of its operation. Read from a
+// plan file, Input is its decoded json, which is decoded again as inputType,
+// refusing fields the input doesn't have
+func (c Cmd) input(inputType reflect.Type) (reflect.Value, error) {
+	if reflect.TypeOf(c.Input) == inputType {
+		return reflect.ValueOf(c.Input), nil
+	}
+
+	b, err := json.Marshal(c.Input)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	input := reflect.New(inputType.Elem())
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(input.Interface()); err != nil {
+		return reflect.Value{}, errors.Wrapf(err, "Error reading the input of %s", c.Operation)
+	}
+	return input, nil
+}
+
+// inputString is the string field of the Cmd's Input, or an
+// empty string if the input doesn't have it
+func (c Cmd) inputString(field string) string {
+	v := reflect.Indirect(reflect.ValueOf(c.Input))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	f := v.FieldByName(field)
+	if !f.IsValid() {
+		return ""
+	}
+	if s, ok := f.Interface().(*string); ok {
+		return aws.StringValue(s)
+	}
+	return ""
+}
+
+// s3ClientForInput finds the client for the region of the bucket named in input,
+// as S3 refuses bucket operations sent to the wrong region
+func (e *Executor) s3ClientForInput(input reflect.Value) (interface{}, error) {
+	bucket := input.Elem().FieldByName("Bucket")
+	if !bucket.IsValid() || bucket.IsNil() {
+		return e.s3.S3API, nil
+	}
+
+	r, err := e.s3.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: bucket.Interface().(*string)})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error finding bucket location")
+	}
+
+	return e.s3.withRegion(s3.NormalizeBucketLocation(normaliseString(r.LocationConstraint))), nil
+}
+
+// cliServices are the SDK clients of the services iamy changes,
+// named as the aws cli names them
+var cliServices = map[string]reflect.Type{
+	"iam":            reflect.TypeOf(&iam.IAM{}),
+	"s3api":          reflect.TypeOf(&s3.S3{}),
+	"s3control":      reflect.TypeOf(&s3control.S3Control{}),
+	"organizations":  reflect.TypeOf(&organizations.Organizations{}),
+	"sqs":            reflect.TypeOf(&sqs.SQS{}),
+	"sns":            reflect.TypeOf(&sns.SNS{}),
+	"kms":            reflect.TypeOf(&kms.KMS{}),
+	"ecr":            reflect.TypeOf(&ecr.ECR{}),
+	"lambda":         reflect.TypeOf(&lambda.Lambda{}),
+	"secretsmanager": reflect.TypeOf(&secretsmanager.SecretsManager{}),
+}
+
+// newCmd creates the Cmd for an aws cli operation, with options being pairs
+// of an option name and its value. The SDK input of the operation is built
+// from the values as they are, and Args only describe it as an aws cli
+// command. An operation or option the service doesn't have is an error
+func newCmd(service, operation string, options ...interface{}) (Cmd, error) {
+	c := Cmd{
+		Name:    "aws",
+		Args:    []string{service, operation},
+		Service: service,
+	}
+
+	method, err := findOperation(service, strings.Replace(operation, "-", "", -1))
+	if err != nil {
+		return Cmd{}, err
+	}
+	c.Operation = method.Name
+
+	if len(options)%2 != 0 {
+		return Cmd{}, fmt.Errorf("Option without a value in %s %s", service, operation)
+	}
+	input := reflect.New(method.Type.In(1).Elem())
+	for i := 0; i < len(options); i += 2 {
+		name, _ := options[i].(string)
+		if !strings.HasPrefix(name, "--") {
+			return Cmd{}, fmt.Errorf("Expected an option in %s %s but got %v", service, operation, options[i])
+		}
+		if name == "--region" {
+			// a global option of the aws cli, rather than of the operation
+			region, ok := options[i+1].(string)
+			if !ok {
+				return Cmd{}, fmt.Errorf("Expected a region in %s %s but got %v", service, operation, options[i+1])
+			}
+			c.Region = region
+			c.Args = append(c.Args, name, c.Region)
+			continue
+		}
+
+		field := findFieldByCliName(input.Elem(), strings.TrimPrefix(name, "--"))
+		if !field.IsValid() {
+			return Cmd{}, fmt.Errorf("Unknown option %s of %s %s", name, service, operation)
+		}
+		args, err := setCliOption(field, name, options[i+1])
+		if err != nil {
+			return Cmd{}, err
+		}
+		c.Args = append(c.Args, args...)
+	}
+	c.Input = input.Interface()
+
+	return c, nil
+}
+
+// findOperation finds the SDK method of an operation of a service, matching
+// its name case insensitively. Only methods taking an input and returning an
+// output and an error are operations, rather than eg their Request variants
+func findOperation(service, name string) (reflect.Method, error) {
+	clientType, ok := cliServices[service]
+	if !ok {
+		return reflect.Method{}, fmt.Errorf("Unsupported service %s", service)
+	}
+	for i := 0; i < clientType.NumMethod(); i++ {
+		m := clientType.Method(i)
+		if strings.EqualFold(m.Name, name) && isOperation(m.Type, 1) {
+			return m, nil
+		}
+	}
+	return reflect.Method{}, fmt.Errorf("Unknown operation %s %s", service, name)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// isOperation is true for a method type taking an input and returning an output
+// and an error, with the input at argument i, after the receiver if it has one
+func isOperation(t reflect.Type, i int) bool {
+	return t.NumIn() == i+1 && t.In(i).Kind() == reflect.Ptr &&
+		t.NumOut() == 2 && t.Out(1) == errorType
+}
+
+// validate checks a Cmd read from a plan file is an operation of its
+// service, with an input the operation has
+func (c Cmd) validate() error {
+	method, err := findOperation(c.Service, c.Operation)
+	if err != nil {
+		return err
+	}
+	if method.Name != c.Operation {
+		return fmt.Errorf("Unknown operation %s %s", c.Service, c.Operation)
+	}
+	_, err = c.input(method.Type.In(1))
+	return err
+}
+
+func findFieldByCliName(v reflect.Value, name string) reflect.Value {
+	name = strings.Replace(name, "-", "", -1)
+	return v.FieldByNameFunc(func(fieldName string) bool {
+		return strings.EqualFold(fieldName, name)
+	})
+}
+
+// setCliOption sets the input field of the option to value, returning
+// the option as aws cli args
+func setCliOption(field reflect.Value, name string, value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{name, v}, setCliValue(field, name, aws.String(v))
+	case int64:
+		return []string{name, strconv.FormatInt(v, 10)}, setCliValue(field, name, aws.Int64(v))
+	case bool:
+		// boolean options are negated when false, eg --no-require-symbols
+		if !v {
+			return []string{"--no-" + strings.TrimPrefix(name, "--")}, setCliValue(field, name, aws.Bool(v))
+		}
+		return []string{name}, setCliValue(field, name, aws.Bool(v))
+	case []string:
+		return append([]string{name}, v...), setCliValue(field, name, aws.StringSlice(v))
+	case map[string]string:
+		return []string{name, compactJson(v)}, setCliValue(field, name, aws.StringMap(v))
+	}
+
+	// a structure or list of structures, shown in the aws cli shorthand
+	if err := setCliValue(field, name, value); err != nil {
+		return nil, err
+	}
+	return []string{name, cliShorthand(reflect.ValueOf(value))}, nil
+}
+
+func setCliValue(field reflect.Value, name string, value interface{}) error {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || !v.Type().ConvertibleTo(field.Type()) {
+		return fmt.Errorf("Option %s is a %s, not a %T", name, field.Type(), value)
+	}
+	field.Set(v.Convert(field.Type()))
+	return nil
+}
+
+// cliShorthand is the aws cli shorthand of a structure or list of
+// structures, eg Key=k1,Value=v1,Key=k2,Value=v2
+func cliShorthand(v reflect.Value) string {
+	if v.Kind() == reflect.Slice {
+		parts := []string{}
+		for i := 0; i < v.Len(); i++ {
+			parts = append(parts, cliShorthand(v.Index(i)))
+		}
+		return strings.Join(parts, ",")
+	}
+
+	v = reflect.Indirect(v)
+	parts := []string{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if v.Type().Field(i).PkgPath != "" || field.IsNil() {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", v.Type().Field(i).Name, field.Elem().Interface()))
+	}
+	return strings.Join(parts, ",")
+}
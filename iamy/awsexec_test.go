@@ -0,0 +1,260 @@
+package iamy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+type newCmdTest struct {
+	args      []interface{}
+	operation string
+	expected  interface{}
+	cli       []string
+}
+
+var newCmdTests = []newCmdTest{
+	{
+		[]interface{}{"iam", "attach-role-policy", "--role-name", "testrole", "--policy-arn", "arn:aws:iam::123:policy/test"},
+		"AttachRolePolicy",
+		&iam.AttachRolePolicyInput{
+			RoleName:  aws.String("testrole"),
+			PolicyArn: aws.String("arn:aws:iam::123:policy/test"),
+		},
+		[]string{"iam", "attach-role-policy", "--role-name", "testrole", "--policy-arn", "arn:aws:iam::123:policy/test"},
+	},
+	{
+		[]interface{}{"iam", "create-policy-version", "--policy-arn", "arn:aws:iam::123:policy/test", "--set-as-default", true, "--policy-document", `{"Version":"2012-10-17"}`},
+		"CreatePolicyVersion",
+		&iam.CreatePolicyVersionInput{
+			PolicyArn:      aws.String("arn:aws:iam::123:policy/test"),
+			SetAsDefault:   aws.Bool(true),
+			PolicyDocument: aws.String(`{"Version":"2012-10-17"}`),
+		},
+		[]string{"iam", "create-policy-version", "--policy-arn", "arn:aws:iam::123:policy/test", "--set-as-default", "--policy-document", `{"Version":"2012-10-17"}`},
+	},
+	{
+		[]interface{}{"iam", "update-role-description", "--role-name", "testrole", "--description", "--not-an-option"},
+		"UpdateRoleDescription",
+		&iam.UpdateRoleDescriptionInput{
+			RoleName:    aws.String("testrole"),
+			Description: aws.String("--not-an-option"),
+		},
+		[]string{"iam", "update-role-description", "--role-name", "testrole", "--description", "--not-an-option"},
+	},
+	{
+		[]interface{}{"iam", "create-user", "--user-name", "billy", "--path", "/", "--tags", iamTags(map[string]string{"team": "a,b", "query": "x=y"})},
+		"CreateUser",
+		&iam.CreateUserInput{
+			UserName: aws.String("billy"),
+			Path:     aws.String("/"),
+			Tags: []*iam.Tag{
+				{Key: aws.String("query"), Value: aws.String("x=y")},
+				{Key: aws.String("team"), Value: aws.String("a,b")},
+			},
+		},
+		[]string{"iam", "create-user", "--user-name", "billy", "--path", "/", "--tags", "Key=query,Value=x=y,Key=team,Value=a,b"},
+	},
+	{
+		[]interface{}{"iam", "untag-user", "--user-name", "billy", "--tag-keys", []string{"team"}},
+		"UntagUser",
+		&iam.UntagUserInput{
+			UserName: aws.String("billy"),
+			TagKeys:  aws.StringSlice([]string{"team"}),
+		},
+		[]string{"iam", "untag-user", "--user-name", "billy", "--tag-keys", "team"},
+	},
+	{
+		[]interface{}{"iam", "create-open-id-connect-provider", "--url", "https://token.actions.githubusercontent.com", "--client-id-list", []string{"sts.amazonaws.com"}, "--thumbprint-list", []string{"aaaa", "bbbb"}},
+		"CreateOpenIDConnectProvider",
+		&iam.CreateOpenIDConnectProviderInput{
+			Url:            aws.String("https://token.actions.githubusercontent.com"),
+			ClientIDList:   aws.StringSlice([]string{"sts.amazonaws.com"}),
+			ThumbprintList: aws.StringSlice([]string{"aaaa", "bbbb"}),
+		},
+		[]string{"iam", "create-open-id-connect-provider", "--url", "https://token.actions.githubusercontent.com", "--client-id-list", "sts.amazonaws.com", "--thumbprint-list", "aaaa", "bbbb"},
+	},
+	{
+		[]interface{}{"iam", "update-account-password-policy", "--require-symbols", false, "--minimum-password-length", int64(14)},
+		"UpdateAccountPasswordPolicy",
+		&iam.UpdateAccountPasswordPolicyInput{
+			RequireSymbols:        aws.Bool(false),
+			MinimumPasswordLength: aws.Int64(14),
+		},
+		[]string{"iam", "update-account-password-policy", "--no-require-symbols", "--minimum-password-length", "14"},
+	},
+	{
+		[]interface{}{"s3api", "put-public-access-block", "--bucket", "my-bucket", "--public-access-block-configuration", publicAccessBlockConfiguration(&PublicAccessBlock{BlockPublicAcls: true, RestrictPublicBuckets: true})},
+		"PutPublicAccessBlock",
+		&s3.PutPublicAccessBlockInput{
+			Bucket: aws.String("my-bucket"),
+			PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(false),
+				BlockPublicPolicy:     aws.Bool(false),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+		},
+		[]string{"s3api", "put-public-access-block", "--bucket", "my-bucket", "--public-access-block-configuration", "BlockPublicAcls=true,BlockPublicPolicy=false,IgnorePublicAcls=false,RestrictPublicBuckets=true"},
+	},
+	{
+		[]interface{}{"s3control", "put-public-access-block", "--account-id", "123", "--public-access-block-configuration", accountPublicAccessBlockConfiguration(&PublicAccessBlock{BlockPublicPolicy: true})},
+		"PutPublicAccessBlock",
+		&s3control.PutPublicAccessBlockInput{
+			AccountId: aws.String("123"),
+			PublicAccessBlockConfiguration: &s3control.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(false),
+				IgnorePublicAcls:      aws.Bool(false),
+				BlockPublicPolicy:     aws.Bool(true),
+				RestrictPublicBuckets: aws.Bool(false),
+			},
+		},
+		[]string{"s3control", "put-public-access-block", "--account-id", "123", "--public-access-block-configuration", "BlockPublicAcls=false,BlockPublicPolicy=true,IgnorePublicAcls=false,RestrictPublicBuckets=false"},
+	},
+	{
+		[]interface{}{"sqs", "set-queue-attributes", "--region", "us-east-1", "--queue-url", "https://sqs.us-east-1.amazonaws.com/123/q", "--attributes", map[string]string{"Policy": `{"Statement":[]}`}},
+		"SetQueueAttributes",
+		&sqs.SetQueueAttributesInput{
+			QueueUrl:   aws.String("https://sqs.us-east-1.amazonaws.com/123/q"),
+			Attributes: map[string]*string{"Policy": aws.String(`{"Statement":[]}`)},
+		},
+		[]string{"sqs", "set-queue-attributes", "--region", "us-east-1", "--queue-url", "https://sqs.us-east-1.amazonaws.com/123/q", "--attributes", `{"Policy":"{\"Statement\":[]}"}`},
+	},
+	{
+		[]interface{}{"organizations", "create-policy", "--name", "DenyAll", "--type", "SERVICE_CONTROL_POLICY", "--content", `{"Statement":[]}`},
+		"CreatePolicy",
+		&organizations.CreatePolicyInput{
+			Name:    aws.String("DenyAll"),
+			Type:    aws.String("SERVICE_CONTROL_POLICY"),
+			Content: aws.String(`{"Statement":[]}`),
+		},
+		[]string{"organizations", "create-policy", "--name", "DenyAll", "--type", "SERVICE_CONTROL_POLICY", "--content", `{"Statement":[]}`},
+	},
+}
+
+func TestNewCmd(t *testing.T) {
+	for _, tt := range newCmdTests {
+		c, err := newCmd(tt.args[0].(string), tt.args[1].(string), tt.args[2:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(c.operation(), func(t *testing.T) {
+			if c.Operation != tt.operation {
+				t.Errorf("Expected operation %s, got %s", tt.operation, c.Operation)
+			}
+			if !reflect.DeepEqual(c.Input, tt.expected) {
+				t.Errorf("Expected %#v, got %#v", tt.expected, c.Input)
+			}
+			if !reflect.DeepEqual(c.Args, tt.cli) {
+				t.Errorf("Expected args %#v, got %#v", tt.cli, c.Args)
+			}
+		})
+	}
+}
+
+func TestNewCmdRegion(t *testing.T) {
+	c, err := newCmd("kms", "put-key-policy", "--region", "eu-west-1", "--key-id", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Region != "eu-west-1" {
+		t.Errorf("Expected region eu-west-1, got %s", c.Region)
+	}
+	if !reflect.DeepEqual(c.Input, &kms.PutKeyPolicyInput{KeyId: aws.String("abc")}) {
+		t.Errorf("Unexpected input %#v", c.Input)
+	}
+}
+
+func TestNewCmdErrors(t *testing.T) {
+	tests := []struct {
+		args     []interface{}
+		expected string
+	}{
+		{[]interface{}{"ec2", "run-instances"}, "Unsupported service ec2"},
+		{[]interface{}{"iam", "delete-everything"}, "Unknown operation iam deleteeverything"},
+		{[]interface{}{"iam", "delete-user-request", "--user-name", "billy"}, "Unknown operation iam deleteuserrequest"},
+		{[]interface{}{"iam", "delete-user", "--colour", "blue"}, "Unknown option --colour of iam delete-user"},
+		{[]interface{}{"iam", "delete-user", "--user-name"}, "Option without a value in iam delete-user"},
+		{[]interface{}{"iam", "delete-user", "billy", "bob"}, "Expected an option in iam delete-user but got billy"},
+		{[]interface{}{"iam", "delete-user", "--user-name", []int{1}}, "Option --user-name is a *string, not a []int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			_, err := newCmd(tt.args[0].(string), tt.args[1].(string), tt.args[2:]...)
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestCmdFromPlanFileIsValidated(t *testing.T) {
+	valid, err := newCmd("iam", "delete-user", "--user-name", "billy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		edit     func(c *Cmd)
+		expected string
+	}{
+		{"unchanged", func(c *Cmd) {}, ""},
+		{"unsupported service", func(c *Cmd) { c.Service = "ec2" }, "Unsupported service ec2"},
+		{"unknown operation", func(c *Cmd) { c.Operation = "DeleteEverything" }, "Unknown operation iam DeleteEverything"},
+		{"request variant", func(c *Cmd) { c.Operation = "DeleteUserRequest" }, "Unknown operation iam DeleteUserRequest"},
+		{"operation in lower case", func(c *Cmd) { c.Operation = "deleteuser" }, "Unknown operation iam deleteuser"},
+		{"unknown input field", func(c *Cmd) { c.Input = map[string]interface{}{"UserName": "billy", "Colour": "blue"} }, `Error reading the input of DeleteUser: json: unknown field "Colour"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(valid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c Cmd
+			if err = json.Unmarshal(b, &c); err != nil {
+				t.Fatal(err)
+			}
+			tt.edit(&c)
+
+			err = c.validate()
+			if tt.expected == "" && err != nil {
+				t.Errorf("Expected the command to be valid, got %s", err)
+			}
+			if tt.expected != "" && (err == nil || err.Error() != tt.expected) {
+				t.Errorf("Expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestCmdInputFromPlanFile(t *testing.T) {
+	c, err := newCmd("iam", "create-user", "--user-name", "billy", "--tags", iamTags(map[string]string{"team": "a,b"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Cmd
+	if err = json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	input, err := saved.input(reflect.TypeOf(&iam.CreateUserInput{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(input.Interface(), c.Input) {
+		t.Errorf("Expected %#v, got %#v", c.Input, input.Interface())
+	}
+}
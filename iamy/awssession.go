@@ -3,6 +3,7 @@ package iamy
 import (
 	"log"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 )
 
@@ -22,3 +23,13 @@ func awsSession() *session.Session {
 
 	return sess
 }
+
+// DefaultRegion is the region configured for the AWS session
+func DefaultRegion() string {
+	return SessionRegion(awsSession())
+}
+
+// SessionRegion is the region configured for the session s
+func SessionRegion(s *session.Session) string {
+	return aws.StringValue(s.Config.Region)
+}
@@ -0,0 +1,92 @@
+package iamy
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"github.com/aws/aws-sdk-go/service/s3control/s3controliface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// Clients are AWS API clients to use instead of the clients for the AWS
+// session, eg in-memory fakes for testing. Any left nil use the session
+type Clients struct {
+	IAM            iamiface.IAMAPI
+	S3             s3iface.S3API
+	CloudFormation cloudformationiface.CloudFormationAPI
+	S3Control      s3controliface.S3ControlAPI
+	Organizations  organizationsiface.OrganizationsAPI
+	STS            stsiface.STSAPI
+}
+
+func (c Clients) iamClient(s *session.Session) *iamClient {
+	if c.IAM != nil {
+		return &iamClient{c.IAM}
+	}
+	return newIamClient(s)
+}
+
+// s3Client uses the injected client for buckets in every region
+func (c Clients) s3Client(s *session.Session) *s3Client {
+	if c.S3 != nil {
+		return &s3Client{S3API: c.S3}
+	}
+	return newS3Client(s)
+}
+
+func (c Clients) cfnClient(s *session.Session) *cfnClient {
+	if c.CloudFormation != nil {
+		return &cfnClient{CloudFormationAPI: c.CloudFormation}
+	}
+	return newCfnClient(s)
+}
+
+func (c Clients) s3ControlClient(s *session.Session) s3controliface.S3ControlAPI {
+	if c.S3Control != nil {
+		return c.S3Control
+	}
+	return s3control.New(s)
+}
+
+func (c Clients) organizationsClient(s *session.Session) organizationsiface.OrganizationsAPI {
+	if c.Organizations != nil {
+		return c.Organizations
+	}
+	return organizations.New(s)
+}
+
+// accountId finds the account id with the injected STS client, or
+// with the fallbacks of GetAwsAccountId if there isn't one
+func (c Clients) accountId(s *session.Session, debug *log.Logger) (string, error) {
+	if c.STS == nil {
+		return GetAwsAccountId(s, debug)
+	}
+
+	resp, err := c.STS.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.Account), nil
+}
+
+// regionalClients uses the injected S3 Control and Organizations
+// clients for every region
+func (c Clients) regionalClients(s *session.Session) *regionalClients {
+	rc := newRegionalClients(s)
+	rc.injected = map[string]interface{}{}
+	if c.S3Control != nil {
+		rc.injected["s3control"] = c.S3Control
+	}
+	if c.Organizations != nil {
+		rc.injected["organizations"] = c.Organizations
+	}
+	return rc
+}
@@ -2,6 +2,7 @@ package iamy
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/iam/iamiface"
@@ -25,24 +26,100 @@ func (c *iamClient) getPolicyDescription(arn string) (string, error) {
 	return "", err
 }
 
-func (c *iamClient) getRoleDescription(name string) (string, error) {
+func (c *iamClient) getRole(name string) (*iam.Role, error) {
 	resp, err := c.GetRole(&iam.GetRoleInput{RoleName: &name})
-	if err == nil && resp.Role.Description != nil {
-		return *resp.Role.Description, nil
+	if err != nil {
+		return nil, err
+	}
+	return resp.Role, nil
+}
+
+func (c *iamClient) getPolicyTags(arn string) (map[string]string, error) {
+	tags := map[string]string{}
+	err := c.ListPolicyTagsPages(&iam.ListPolicyTagsInput{PolicyArn: &arn},
+		func(resp *iam.ListPolicyTagsOutput, lastPage bool) bool {
+			addTagsToMap(resp.Tags, tags)
+			return true
+		})
+	return tags, err
+}
+
+func (c *iamClient) getInstanceProfileTags(name string) (map[string]string, error) {
+	tags := map[string]string{}
+	err := c.ListInstanceProfileTagsPages(&iam.ListInstanceProfileTagsInput{InstanceProfileName: &name},
+		func(resp *iam.ListInstanceProfileTagsOutput, lastPage bool) bool {
+			addTagsToMap(resp.Tags, tags)
+			return true
+		})
+	return tags, err
+}
+
+func (c *iamClient) getSamlProvider(arn string) (*iam.GetSAMLProviderOutput, error) {
+	return c.GetSAMLProvider(&iam.GetSAMLProviderInput{SAMLProviderArn: &arn})
+}
+
+func (c *iamClient) getOidcProvider(arn string) (*iam.GetOpenIDConnectProviderOutput, error) {
+	return c.GetOpenIDConnectProvider(&iam.GetOpenIDConnectProviderInput{OpenIDConnectProviderArn: &arn})
+}
+
+// getAccountPasswordPolicy returns the account password policy, or nil if the account doesn't have one
+func (c *iamClient) getAccountPasswordPolicy() (*PasswordPolicy, error) {
+	resp, err := c.GetAccountPasswordPolicy(&iam.GetAccountPasswordPolicyInput{})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == iam.ErrCodeNoSuchEntityException {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	pp := resp.PasswordPolicy
+	minimumPasswordLength := aws.Int64Value(pp.MinimumPasswordLength)
+	if minimumPasswordLength == DefaultMinimumPasswordLength {
+		minimumPasswordLength = 0
+	}
+	return &PasswordPolicy{
+		MinimumPasswordLength:      minimumPasswordLength,
+		RequireSymbols:             aws.BoolValue(pp.RequireSymbols),
+		RequireNumbers:             aws.BoolValue(pp.RequireNumbers),
+		RequireUppercaseCharacters: aws.BoolValue(pp.RequireUppercaseCharacters),
+		RequireLowercaseCharacters: aws.BoolValue(pp.RequireLowercaseCharacters),
+		AllowUsersToChangePassword: aws.BoolValue(pp.AllowUsersToChangePassword),
+		MaxPasswordAge:             aws.Int64Value(pp.MaxPasswordAge),
+		PasswordReusePrevention:    aws.Int64Value(pp.PasswordReusePrevention),
+		HardExpiry:                 aws.BoolValue(pp.HardExpiry),
+	}, nil
+}
+
+func addTagsToMap(tags []*iam.Tag, m map[string]string) {
+	for _, t := range tags {
+		m[*t.Key] = *t.Value
 	}
-	return "", err
 }
 
-func (c *iamClient) MustGetSecurityCredsForUser(username string) (accessKeyIds, mfaIds []string, hasLoginProfile bool) {
+// getUserCredentials fetches the state of a user's credentials, or only
+// the user's ssh public keys if sshPublicKeys is true, unless all is true
+func (c *iamClient) getUserCredentials(username string, all, sshPublicKeys bool) (creds userCredentials, err error) {
+	if all || sshPublicKeys {
+		if creds.SSHPublicKeyIds, err = c.getSSHPublicKeyIds(username); err != nil {
+			return creds, err
+		}
+	} else {
+		creds.SSHPublicKeysSkipped = true
+	}
+	if !all {
+		creds.SSHPublicKeysOnly = true
+		return creds, nil
+	}
+
 	// access keys
 	listUsersResp, err := c.ListAccessKeys(&iam.ListAccessKeysInput{
 		UserName: aws.String(username),
 	})
 	if err != nil {
-		panic(err)
+		return creds, err
 	}
 	for _, m := range listUsersResp.AccessKeyMetadata {
-		accessKeyIds = append(accessKeyIds, *m.AccessKeyId)
+		creds.AccessKeyIds = append(creds.AccessKeyIds, *m.AccessKeyId)
 	}
 
 	// mfa devices
@@ -50,10 +127,21 @@ func (c *iamClient) MustGetSecurityCredsForUser(username string) (accessKeyIds,
 		UserName: aws.String(username),
 	})
 	if err != nil {
-		panic(err)
+		return creds, err
 	}
 	for _, m := range mfaResp.MFADevices {
-		mfaIds = append(mfaIds, *m.SerialNumber)
+		creds.MFADevices = append(creds.MFADevices, *m.SerialNumber)
+	}
+
+	// service specific credentials
+	serviceCredsResp, err := c.ListServiceSpecificCredentials(&iam.ListServiceSpecificCredentialsInput{
+		UserName: aws.String(username),
+	})
+	if err != nil {
+		return creds, err
+	}
+	for _, m := range serviceCredsResp.ServiceSpecificCredentials {
+		creds.ServiceSpecificCredentialIds = append(creds.ServiceSpecificCredentialIds, *m.ServiceSpecificCredentialId)
 	}
 
 	// login profile
@@ -61,8 +149,40 @@ func (c *iamClient) MustGetSecurityCredsForUser(username string) (accessKeyIds,
 		UserName: aws.String(username),
 	})
 	if err == nil {
-		hasLoginProfile = true
+		creds.HasLoginProfile = true
+	} else if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != iam.ErrCodeNoSuchEntityException {
+		return creds, err
+	}
+
+	return creds, nil
+}
+
+// getSSHPublicKeyIds returns the ids of a user's ssh public keys by key
+func (c *iamClient) getSSHPublicKeyIds(username string) (map[string]string, error) {
+	ids := map[string]string{}
+	var keyErr error
+	err := c.ListSSHPublicKeysPages(&iam.ListSSHPublicKeysInput{UserName: aws.String(username)},
+		func(resp *iam.ListSSHPublicKeysOutput, lastPage bool) bool {
+			for _, k := range resp.SSHPublicKeys {
+				var keyResp *iam.GetSSHPublicKeyOutput
+				keyResp, keyErr = c.GetSSHPublicKey(&iam.GetSSHPublicKeyInput{
+					UserName:       aws.String(username),
+					SSHPublicKeyId: k.SSHPublicKeyId,
+					Encoding:       aws.String(iam.EncodingTypeSsh),
+				})
+				if keyErr != nil {
+					return false
+				}
+				ids[normaliseSSHPublicKey(*keyResp.SSHPublicKey.SSHPublicKeyBody)] = *k.SSHPublicKeyId
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	if keyErr != nil {
+		return nil, keyErr
 	}
 
-	return
+	return ids, nil
 }
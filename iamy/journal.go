@@ -0,0 +1,154 @@
+package iamy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A Journal records the progress of applying a PlanFile, so that
+// applying can continue from a failed step. Fingerprint is the
+// fingerprint of the PlanFile the progress is for
+type Journal struct {
+	Fingerprint string          `json:"fingerprint"`
+	Completed   []int           `json:"completed"`
+	Failed      *JournalFailure `json:"failed,omitempty"`
+}
+
+// A JournalFailure is the step that failed when the plan was last applied
+type JournalFailure struct {
+	Step  int    `json:"step"`
+	Error string `json:"error"`
+}
+
+// JournalPath is where the progress of applying the plan file at path is recorded
+func JournalPath(planPath string) string {
+	return planPath + ".progress"
+}
+
+// NewJournal creates an empty Journal for pf
+func NewJournal(pf *PlanFile) *Journal {
+	return &Journal{Fingerprint: pf.Fingerprint, Completed: []int{}}
+}
+
+// ReadJournal reads the Journal at path, or returns nil if there isn't one
+func ReadJournal(path string) (*Journal, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var j Journal
+	if err = json.Unmarshal(b, &j); err != nil {
+		return nil, errors.Wrapf(err, "Error reading progress file %s", path)
+	}
+
+	return &j, nil
+}
+
+// Write writes the Journal to path
+func (j *Journal) Write(path string) error {
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0666)
+}
+
+// IsFor checks the Journal records the progress of pf
+func (j *Journal) IsFor(pf *PlanFile) bool {
+	return j.Fingerprint == pf.Fingerprint
+}
+
+// Complete records that a step succeeded
+func (j *Journal) Complete(s *Step) {
+	if !j.IsComplete(s) {
+		j.Completed = append(j.Completed, s.ID)
+	}
+	if j.Failed != nil && j.Failed.Step == s.ID {
+		j.Failed = nil
+	}
+}
+
+// Fail records that a step failed
+func (j *Journal) Fail(s *Step, err error) {
+	j.Failed = &JournalFailure{Step: s.ID, Error: err.Error()}
+}
+
+// IsComplete is true if the step has succeeded
+func (j *Journal) IsComplete(s *Step) bool {
+	for _, id := range j.Completed {
+		if id == s.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// Remaining returns a plan of the steps of plan that haven't succeeded
+func (j *Journal) Remaining(plan *Plan) *Plan {
+	remaining := Plan{Warnings: plan.Warnings}
+	for _, s := range plan.Steps {
+		if !j.IsComplete(s) {
+			remaining.Steps = append(remaining.Steps, s)
+		}
+	}
+	return &remaining
+}
+
+// resumableResourceTypes are the resources whose existence is
+// checked before continuing a partly applied plan
+var resumableResourceTypes = []string{
+	"iam/user",
+	"iam/group",
+	"iam/role",
+	"iam/policy",
+	"iam/instance-profile",
+	"iam/saml-provider",
+	"iam/oidc-provider",
+}
+
+// ValidateRemaining checks that the AWS account data is still in the state the
+// remaining steps of a partly applied plan expect. Each resource the steps
+// create mustn't exist yet, and each resource they change or delete must
+func ValidateRemaining(remaining *Plan, awsData *AccountData) error {
+	exists := map[string]bool{}
+	for _, r := range awsData.Resources() {
+		exists[resourceKey(ResourceTypeOf(r), r.ResourcePath(), r.ResourceName())] = true
+	}
+
+	problems := []string{}
+	for _, s := range remaining.Steps {
+		if !containsString(resumableResourceTypes, s.ResourceType) {
+			continue
+		}
+
+		key := stepResourceKey(s)
+		switch {
+		case isResourceCreate(s) && exists[key]:
+			problems = append(problems, fmt.Sprintf("step %d creates %s %s, which already exists", s.ID, s.ResourceType, s.ResourceName))
+		case !isResourceCreate(s) && !exists[key]:
+			problems = append(problems, fmt.Sprintf("step %d changes %s %s, which doesn't exist", s.ID, s.ResourceType, s.ResourceName))
+		}
+
+		// later steps expect the changes of this one
+		if isResourceCreate(s) {
+			exists[key] = true
+		} else if isResourceDelete(s) {
+			exists[key] = false
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("AWS account no longer matches the remaining steps: %s", strings.Join(problems, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package iamy
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	pf := PlanFile{Fingerprint: "abc", Plan: &Plan{Steps: []*Step{{ID: 1}, {ID: 2}, {ID: 3}}}}
+	j := NewJournal(&pf)
+	j.Complete(pf.Plan.Steps[0])
+	j.Fail(pf.Plan.Steps[1], errors.New("Throttled"))
+
+	path := JournalPath(filepath.Join(t.TempDir(), "plan.json"))
+	if err := j.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !read.IsFor(&pf) {
+		t.Error("Expected the journal to be for the plan")
+	}
+	if read.Failed == nil || read.Failed.Step != 2 || read.Failed.Error != "Throttled" {
+		t.Errorf("Expected step 2 to have failed, got %#v", read.Failed)
+	}
+	remaining := read.Remaining(pf.Plan)
+	if remaining.Count() != 2 || remaining.Steps[0].ID != 2 || remaining.Steps[1].ID != 3 {
+		t.Errorf("Expected steps 2 and 3 to remain, got %v", remaining.Steps)
+	}
+
+	read.Complete(pf.Plan.Steps[1])
+	if read.Failed != nil {
+		t.Error("Expected completing the failed step to clear the failure")
+	}
+}
+
+func TestReadJournalWithoutProgress(t *testing.T) {
+	j, err := ReadJournal(filepath.Join(t.TempDir(), "plan.json.progress"))
+	if err != nil || j != nil {
+		t.Errorf("Expected no journal, got %v, %v", j, err)
+	}
+}
+
+func TestResumingPartlyAppliedPlan(t *testing.T) {
+	from := &AccountData{Account: &Account{Id: "123456789012"}}
+	to := &AccountData{
+		Account: &Account{Id: "123456789012"},
+		Groups:  []*Group{{iamService: iamService{Name: "developers", Path: "/"}}},
+		Users:   []*User{{iamService: iamService{Name: "alice", Path: "/"}, Groups: []string{"developers"}}},
+	}
+	sim, err := newSimulation(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pf := PlanFile{Fingerprint: "abc", Plan: planForSync(t, from, to)}
+	j := NewJournal(&pf)
+
+	// the first step succeeds, then applying fails
+	first := pf.Plan.Steps[0]
+	if err := sim.executor.Exec(first.Cmd); err != nil {
+		t.Fatal(err)
+	}
+	j.Complete(first)
+	j.Fail(pf.Plan.Steps[1], errors.New("Throttled"))
+
+	remaining := j.Remaining(pf.Plan)
+	current, err := sim.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateRemaining(remaining, current); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateRemaining(pf.Plan, current); err == nil {
+		t.Error("Expected applying the completed step again not to match the account")
+	}
+
+	for _, s := range remaining.Steps {
+		if err := sim.executor.Exec(s.Cmd); err != nil {
+			t.Fatalf("Step %d failed: %s", s.ID, err)
+		}
+	}
+	after, err := sim.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := planForSync(t, after, to); plan.Count() != 0 {
+		t.Errorf("Expected no changes after resuming, got:\n%s", plan)
+	}
+}
+
+func TestResumingRefusesWhenAccountChanged(t *testing.T) {
+	from := &AccountData{Account: &Account{Id: "123456789012"}}
+	to := &AccountData{
+		Account: &Account{Id: "123456789012"},
+		Groups:  []*Group{{iamService: iamService{Name: "developers", Path: "/"}}},
+		Users:   []*User{{iamService: iamService{Name: "alice", Path: "/"}, Groups: []string{"developers"}}},
+	}
+	sim, err := newSimulation(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pf := PlanFile{Fingerprint: "abc", Plan: planForSync(t, from, to)}
+	j := NewJournal(&pf)
+
+	// nothing succeeded, but alice was created outside of iamy
+	if _, err := sim.clients.IAM.CreateUser(&iam.CreateUserInput{UserName: aws.String("alice")}); err != nil {
+		t.Fatal(err)
+	}
+	current, err := sim.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateRemaining(j.Remaining(pf.Plan), current); err == nil {
+		t.Error("Expected creating alice not to match the account")
+	}
+}
@@ -0,0 +1,184 @@
+// Package memaws is a stateful in-memory fake of the parts of the IAM, S3,
+// CloudFormation, S3 Control, Organizations and STS APIs that iamy uses, for
+// simulating pushes and testing pulls and pushes end to end without AWS.
+//
+// Like AWS, it stores account id principals in trust and bucket policies as
+// the arn of the account's root user, compacts bucket policies and returns
+// tags unsorted. Otherwise policy documents are kept as they're sent. It has
+// no resource policies, and its accounts aren't in an organization.
+//
+// Each fake embeds the API interface it implements, so calling an operation
+// the fake doesn't implement panics.
+package memaws
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3control"
+)
+
+// A Backend holds the state of a fake AWS account, shared by its fake API clients
+type Backend struct {
+	AccountId string
+
+	mu               sync.Mutex
+	now              time.Time
+	nextId           int
+	aliases          []string
+	users            map[string]*user
+	groups           map[string]*group
+	roles            map[string]*role
+	policies         map[string]*policy
+	instanceProfiles map[string]*instanceProfile
+	samlProviders    map[string]*samlProvider
+	oidcProviders    map[string]*oidcProvider
+	passwordPolicy   *iam.PasswordPolicy
+	buckets          map[string]*bucket
+	accountPab       *s3control.PublicAccessBlockConfiguration
+	stacks           map[string][]stackResource
+	calls            []string
+}
+
+// New creates a Backend for an empty account
+func New(accountId string) *Backend {
+	return &Backend{
+		AccountId:        accountId,
+		now:              time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		users:            map[string]*user{},
+		groups:           map[string]*group{},
+		roles:            map[string]*role{},
+		policies:         map[string]*policy{},
+		instanceProfiles: map[string]*instanceProfile{},
+		samlProviders:    map[string]*samlProvider{},
+		oidcProviders:    map[string]*oidcProvider{},
+		buckets:          map[string]*bucket{},
+		stacks:           map[string][]stackResource{},
+	}
+}
+
+// Calls are the names of the operations that changed the account, in order
+func (b *Backend) Calls() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string{}, b.calls...)
+}
+
+// change locks the backend for an operation that changes it, and records the call
+func (b *Backend) change(operation string) func() {
+	b.mu.Lock()
+	b.calls = append(b.calls, operation)
+	return b.mu.Unlock
+}
+
+func (b *Backend) read() func() {
+	b.mu.Lock()
+	return b.mu.Unlock
+}
+
+// tick returns a time later than every time before it, so that
+// creation dates are ordered
+func (b *Backend) tick() *time.Time {
+	b.now = b.now.Add(time.Second)
+	t := b.now
+	return &t
+}
+
+// newId returns a unique id with prefix, like the ids AWS generates
+func (b *Backend) newId(prefix string) string {
+	b.nextId++
+	return fmt.Sprintf("%s%016d", prefix, b.nextId)
+}
+
+func (b *Backend) arn(service, resource string) string {
+	return fmt.Sprintf("arn:aws:%s::%s:%s", service, b.AccountId, resource)
+}
+
+func noSuchEntity(format string, args ...interface{}) error {
+	return awserr.New(iam.ErrCodeNoSuchEntityException, fmt.Sprintf(format, args...), nil)
+}
+
+func alreadyExists(format string, args ...interface{}) error {
+	return awserr.New(iam.ErrCodeEntityAlreadyExistsException, fmt.Sprintf(format, args...), nil)
+}
+
+func deleteConflict(format string, args ...interface{}) error {
+	return awserr.New(iam.ErrCodeDeleteConflictException, fmt.Sprintf(format, args...), nil)
+}
+
+func pathOrDefault(path *string) string {
+	if path == nil || *path == "" {
+		return "/"
+	}
+	return *path
+}
+
+// tags are kept in the order they were added, as AWS doesn't sort them
+type tags struct {
+	keys   []string
+	values map[string]string
+}
+
+func (t *tags) set(tt []*iam.Tag) {
+	if t.values == nil {
+		t.values = map[string]string{}
+	}
+	for _, tag := range tt {
+		k := aws.StringValue(tag.Key)
+		if _, ok := t.values[k]; !ok {
+			t.keys = append(t.keys, k)
+		}
+		t.values[k] = aws.StringValue(tag.Value)
+	}
+}
+
+func (t *tags) unset(keys []*string) {
+	for _, k := range keys {
+		delete(t.values, aws.StringValue(k))
+		t.keys, _ = remove(t.keys, aws.StringValue(k))
+	}
+}
+
+func (t tags) iamTags() []*iam.Tag {
+	tt := []*iam.Tag{}
+	for _, k := range t.keys {
+		tt = append(tt, &iam.Tag{Key: aws.String(k), Value: aws.String(t.values[k])})
+	}
+	return tt
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func remove(ss []string, s string) ([]string, bool) {
+	for i, v := range ss {
+		if v == s {
+			return append(ss[:i:i], ss[i+1:]...), true
+		}
+	}
+	return ss, false
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func limitExceeded(format string, args ...interface{}) error {
+	return awserr.New(iam.ErrCodeLimitExceededException, fmt.Sprintf(format, args...), nil)
+}
@@ -0,0 +1,61 @@
+package memaws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+)
+
+type stackResource struct {
+	resourceType, physicalId string
+}
+
+// CloudFormation is a fake of the CloudFormation API backed by a Backend,
+// listing the stacks added with AddStackResource
+type CloudFormation struct {
+	cloudformationiface.CloudFormationAPI
+	b *Backend
+}
+
+// CloudFormation returns a fake CloudFormation client for the backend
+func (b *Backend) CloudFormation() *CloudFormation {
+	return &CloudFormation{b: b}
+}
+
+// AddStackResource adds a resource of resourceType, eg AWS::IAM::Role, to
+// stack, so that iamy treats the resource named physicalId as managed by CloudFormation
+func (b *Backend) AddStackResource(stack, resourceType, physicalId string) {
+	defer b.read()()
+	b.stacks[stack] = append(b.stacks[stack], stackResource{resourceType, physicalId})
+}
+
+func (c *CloudFormation) ListStacks(*cloudformation.ListStacksInput) (*cloudformation.ListStacksOutput, error) {
+	defer c.b.read()()
+	out := cloudformation.ListStacksOutput{}
+	for _, name := range sortedNames(c.b.stacks) {
+		out.StackSummaries = append(out.StackSummaries, &cloudformation.StackSummary{
+			StackName:   aws.String(name),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+		})
+	}
+	return &out, nil
+}
+
+func (c *CloudFormation) ListStackResources(input *cloudformation.ListStackResourcesInput) (*cloudformation.ListStackResourcesOutput, error) {
+	defer c.b.read()()
+	resources, ok := c.b.stacks[aws.StringValue(input.StackName)]
+	if !ok {
+		return nil, awserr.New("ValidationError", fmt.Sprintf("Stack with id %s does not exist", aws.StringValue(input.StackName)), nil)
+	}
+	out := cloudformation.ListStackResourcesOutput{}
+	for _, r := range resources {
+		out.StackResourceSummaries = append(out.StackResourceSummaries, &cloudformation.StackResourceSummary{
+			ResourceType:       aws.String(r.resourceType),
+			PhysicalResourceId: aws.String(r.physicalId),
+		})
+	}
+	return &out, nil
+}
@@ -0,0 +1,1463 @@
+package memaws
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// IAM is a fake of the IAM API backed by a Backend
+type IAM struct {
+	iamiface.IAMAPI
+	b *Backend
+}
+
+// IAM returns a fake IAM client for the backend
+func (b *Backend) IAM() *IAM {
+	return &IAM{b: b}
+}
+
+// principal is the policies of a user, group or role
+type principal struct {
+	attached []string
+	inline   map[string]string
+}
+
+func newPrincipal() principal {
+	return principal{inline: map[string]string{}}
+}
+
+func (p *principal) attach(arn string) {
+	if !contains(p.attached, arn) {
+		p.attached = append(p.attached, arn)
+	}
+}
+
+func (p *principal) detach(arn string) error {
+	var ok bool
+	if p.attached, ok = remove(p.attached, arn); !ok {
+		return noSuchEntity("Policy %s is not attached", arn)
+	}
+	return nil
+}
+
+func (p *principal) deleteInline(name string) error {
+	if _, ok := p.inline[name]; !ok {
+		return noSuchEntity("The inline policy %s cannot be found", name)
+	}
+	delete(p.inline, name)
+	return nil
+}
+
+func (p *principal) inUse() bool {
+	return len(p.attached) > 0 || len(p.inline) > 0
+}
+
+func (p *principal) attachedPolicies() []*iam.AttachedPolicy {
+	pp := []*iam.AttachedPolicy{}
+	for _, arn := range p.attached {
+		pp = append(pp, &iam.AttachedPolicy{
+			PolicyArn:  aws.String(arn),
+			PolicyName: aws.String(arn[strings.LastIndex(arn, "/")+1:]),
+		})
+	}
+	return pp
+}
+
+func (p *principal) inlinePolicies() []*iam.PolicyDetail {
+	pp := []*iam.PolicyDetail{}
+	for _, name := range sortedKeys(p.inline) {
+		pp = append(pp, &iam.PolicyDetail{
+			PolicyName:     aws.String(name),
+			PolicyDocument: aws.String(url.QueryEscape(p.inline[name])),
+		})
+	}
+	return pp
+}
+
+type user struct {
+	principal
+	name, path   string
+	groups       []string
+	tags         tags
+	boundary     string
+	accessKeys   []string
+	mfaDevices   []string
+	loginProfile bool
+	sshKeyIds    []string
+	sshKeys      map[string]string
+	serviceCreds []string
+}
+
+type group struct {
+	principal
+	name, path string
+}
+
+type role struct {
+	principal
+	name, path         string
+	assumeRolePolicy   string
+	description        string
+	maxSessionDuration int64
+	tags               tags
+	boundary           string
+}
+
+type policy struct {
+	name, path, arn string
+	description     string
+	versions        []*iam.PolicyVersion
+	nextVersion     int
+	tags            tags
+}
+
+type instanceProfile struct {
+	name, path string
+	roles      []string
+	tags       tags
+}
+
+type samlProvider struct {
+	metadata string
+	tags     tags
+}
+
+type oidcProvider struct {
+	url         string
+	clientIds   []string
+	thumbprints []string
+	tags        tags
+}
+
+func boundary(arn string) *iam.AttachedPermissionsBoundary {
+	if arn == "" {
+		return nil
+	}
+	return &iam.AttachedPermissionsBoundary{
+		PermissionsBoundaryArn:  aws.String(arn),
+		PermissionsBoundaryType: aws.String(iam.PermissionsBoundaryAttachmentTypePermissionsBoundaryPolicy),
+	}
+}
+
+func (c *IAM) user(name *string) (*user, error) {
+	if u, ok := c.b.users[aws.StringValue(name)]; ok {
+		return u, nil
+	}
+	return nil, noSuchEntity("The user with name %s cannot be found", aws.StringValue(name))
+}
+
+func (c *IAM) group(name *string) (*group, error) {
+	if g, ok := c.b.groups[aws.StringValue(name)]; ok {
+		return g, nil
+	}
+	return nil, noSuchEntity("The group with name %s cannot be found", aws.StringValue(name))
+}
+
+func (c *IAM) role(name *string) (*role, error) {
+	if r, ok := c.b.roles[aws.StringValue(name)]; ok {
+		return r, nil
+	}
+	return nil, noSuchEntity("The role with name %s cannot be found", aws.StringValue(name))
+}
+
+func (c *IAM) policy(arn *string) (*policy, error) {
+	if p, ok := c.b.policies[aws.StringValue(arn)]; ok {
+		return p, nil
+	}
+	return nil, noSuchEntity("Policy %s does not exist", aws.StringValue(arn))
+}
+
+func (c *IAM) instanceProfile(name *string) (*instanceProfile, error) {
+	if p, ok := c.b.instanceProfiles[aws.StringValue(name)]; ok {
+		return p, nil
+	}
+	return nil, noSuchEntity("Instance Profile %s cannot be found", aws.StringValue(name))
+}
+
+// attachable checks a policy exists, AWS managed policies being assumed to
+func (c *IAM) attachable(arn *string) error {
+	if strings.HasPrefix(aws.StringValue(arn), "arn:aws:iam::aws:policy/") {
+		return nil
+	}
+	_, err := c.policy(arn)
+	return err
+}
+
+// policyInUse is true if a policy is attached to anything or is a permissions boundary
+func (c *IAM) policyInUse(arn string) bool {
+	for _, u := range c.b.users {
+		if contains(u.attached, arn) || u.boundary == arn {
+			return true
+		}
+	}
+	for _, g := range c.b.groups {
+		if contains(g.attached, arn) {
+			return true
+		}
+	}
+	for _, r := range c.b.roles {
+		if contains(r.attached, arn) || r.boundary == arn {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *IAM) GetAccountAuthorizationDetailsPages(input *iam.GetAccountAuthorizationDetailsInput, fn func(*iam.GetAccountAuthorizationDetailsOutput, bool) bool) error {
+	return c.GetAccountAuthorizationDetailsPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// Paged operations call fn without holding the lock, as it may call the fake again
+
+func (c *IAM) GetAccountAuthorizationDetailsPagesWithContext(_ aws.Context, _ *iam.GetAccountAuthorizationDetailsInput, fn func(*iam.GetAccountAuthorizationDetailsOutput, bool) bool, _ ...request.Option) error {
+	fn(c.accountAuthorizationDetails(), true)
+	return nil
+}
+
+func (c *IAM) accountAuthorizationDetails() *iam.GetAccountAuthorizationDetailsOutput {
+	defer c.b.read()()
+
+	out := iam.GetAccountAuthorizationDetailsOutput{}
+	for _, name := range sortedNames(c.b.users) {
+		u := c.b.users[name]
+		out.UserDetailList = append(out.UserDetailList, &iam.UserDetail{
+			UserName:                aws.String(u.name),
+			Path:                    aws.String(u.path),
+			Arn:                     aws.String(c.b.arn("iam", "user"+u.path+u.name)),
+			GroupList:               aws.StringSlice(u.groups),
+			AttachedManagedPolicies: u.attachedPolicies(),
+			UserPolicyList:          u.inlinePolicies(),
+			Tags:                    u.tags.iamTags(),
+			PermissionsBoundary:     boundary(u.boundary),
+		})
+	}
+	for _, name := range sortedNames(c.b.groups) {
+		g := c.b.groups[name]
+		out.GroupDetailList = append(out.GroupDetailList, &iam.GroupDetail{
+			GroupName:               aws.String(g.name),
+			Path:                    aws.String(g.path),
+			Arn:                     aws.String(c.b.arn("iam", "group"+g.path+g.name)),
+			AttachedManagedPolicies: g.attachedPolicies(),
+			GroupPolicyList:         g.inlinePolicies(),
+		})
+	}
+	for _, name := range sortedNames(c.b.roles) {
+		r := c.b.roles[name]
+		out.RoleDetailList = append(out.RoleDetailList, &iam.RoleDetail{
+			RoleName:                 aws.String(r.name),
+			Path:                     aws.String(r.path),
+			Arn:                      aws.String(c.b.arn("iam", "role"+r.path+r.name)),
+			AssumeRolePolicyDocument: aws.String(url.QueryEscape(r.assumeRolePolicy)),
+			AttachedManagedPolicies:  r.attachedPolicies(),
+			RolePolicyList:           r.inlinePolicies(),
+			Tags:                     r.tags.iamTags(),
+			PermissionsBoundary:      boundary(r.boundary),
+		})
+	}
+	for _, arn := range sortedNames(c.b.policies) {
+		p := c.b.policies[arn]
+		versions := []*iam.PolicyVersion{}
+		for _, v := range p.versions {
+			versions = append(versions, &iam.PolicyVersion{
+				VersionId:        v.VersionId,
+				IsDefaultVersion: v.IsDefaultVersion,
+				CreateDate:       v.CreateDate,
+				Document:         aws.String(url.QueryEscape(aws.StringValue(v.Document))),
+			})
+		}
+		out.Policies = append(out.Policies, &iam.ManagedPolicyDetail{
+			PolicyName:        aws.String(p.name),
+			Path:              aws.String(p.path),
+			Arn:               aws.String(p.arn),
+			PolicyVersionList: versions,
+		})
+	}
+
+	return &out
+}
+
+func (c *IAM) ListInstanceProfilesPages(input *iam.ListInstanceProfilesInput, fn func(*iam.ListInstanceProfilesOutput, bool) bool) error {
+	fn(c.instanceProfiles(), true)
+	return nil
+}
+
+func (c *IAM) instanceProfiles() *iam.ListInstanceProfilesOutput {
+	defer c.b.read()()
+
+	out := iam.ListInstanceProfilesOutput{}
+	for _, name := range sortedNames(c.b.instanceProfiles) {
+		p := c.b.instanceProfiles[name]
+		profile := iam.InstanceProfile{
+			InstanceProfileName: aws.String(p.name),
+			Path:                aws.String(p.path),
+		}
+		for _, r := range p.roles {
+			profile.Roles = append(profile.Roles, &iam.Role{RoleName: aws.String(r)})
+		}
+		out.InstanceProfiles = append(out.InstanceProfiles, &profile)
+	}
+
+	return &out
+}
+
+func (c *IAM) ListAccountAliases(*iam.ListAccountAliasesInput) (*iam.ListAccountAliasesOutput, error) {
+	defer c.b.read()()
+	return &iam.ListAccountAliasesOutput{AccountAliases: aws.StringSlice(c.b.aliases)}, nil
+}
+
+func (c *IAM) CreateAccountAlias(input *iam.CreateAccountAliasInput) (*iam.CreateAccountAliasOutput, error) {
+	defer c.b.change("CreateAccountAlias")()
+	c.b.aliases = []string{aws.StringValue(input.AccountAlias)}
+	return &iam.CreateAccountAliasOutput{}, nil
+}
+
+func (c *IAM) DeleteAccountAlias(input *iam.DeleteAccountAliasInput) (*iam.DeleteAccountAliasOutput, error) {
+	defer c.b.change("DeleteAccountAlias")()
+	var ok bool
+	if c.b.aliases, ok = remove(c.b.aliases, aws.StringValue(input.AccountAlias)); !ok {
+		return nil, noSuchEntity("The account alias %s cannot be found", aws.StringValue(input.AccountAlias))
+	}
+	return &iam.DeleteAccountAliasOutput{}, nil
+}
+
+func (c *IAM) GetAccountPasswordPolicy(*iam.GetAccountPasswordPolicyInput) (*iam.GetAccountPasswordPolicyOutput, error) {
+	defer c.b.read()()
+	if c.b.passwordPolicy == nil {
+		return nil, noSuchEntity("The Password Policy cannot be found")
+	}
+	pp := *c.b.passwordPolicy
+	return &iam.GetAccountPasswordPolicyOutput{PasswordPolicy: &pp}, nil
+}
+
+func (c *IAM) UpdateAccountPasswordPolicy(input *iam.UpdateAccountPasswordPolicyInput) (*iam.UpdateAccountPasswordPolicyOutput, error) {
+	defer c.b.change("UpdateAccountPasswordPolicy")()
+	c.b.passwordPolicy = &iam.PasswordPolicy{
+		MinimumPasswordLength:      input.MinimumPasswordLength,
+		RequireSymbols:             input.RequireSymbols,
+		RequireNumbers:             input.RequireNumbers,
+		RequireUppercaseCharacters: input.RequireUppercaseCharacters,
+		RequireLowercaseCharacters: input.RequireLowercaseCharacters,
+		AllowUsersToChangePassword: input.AllowUsersToChangePassword,
+		MaxPasswordAge:             input.MaxPasswordAge,
+		PasswordReusePrevention:    input.PasswordReusePrevention,
+		HardExpiry:                 input.HardExpiry,
+	}
+	return &iam.UpdateAccountPasswordPolicyOutput{}, nil
+}
+
+func (c *IAM) DeleteAccountPasswordPolicy(*iam.DeleteAccountPasswordPolicyInput) (*iam.DeleteAccountPasswordPolicyOutput, error) {
+	defer c.b.change("DeleteAccountPasswordPolicy")()
+	if c.b.passwordPolicy == nil {
+		return nil, noSuchEntity("The Password Policy cannot be found")
+	}
+	c.b.passwordPolicy = nil
+	return &iam.DeleteAccountPasswordPolicyOutput{}, nil
+}
+
+// Users
+
+func (c *IAM) CreateUser(input *iam.CreateUserInput) (*iam.CreateUserOutput, error) {
+	defer c.b.change("CreateUser")()
+	name := aws.StringValue(input.UserName)
+	if _, ok := c.b.users[name]; ok {
+		return nil, alreadyExists("User with name %s already exists", name)
+	}
+	u := user{
+		principal: newPrincipal(),
+		name:      name,
+		path:      pathOrDefault(input.Path),
+		tags:      tags{},
+		sshKeys:   map[string]string{},
+	}
+	u.tags.set(input.Tags)
+	if input.PermissionsBoundary != nil {
+		if err := c.attachable(input.PermissionsBoundary); err != nil {
+			return nil, err
+		}
+		u.boundary = aws.StringValue(input.PermissionsBoundary)
+	}
+	c.b.users[name] = &u
+	return &iam.CreateUserOutput{User: &iam.User{UserName: aws.String(name), Path: aws.String(u.path)}}, nil
+}
+
+func (c *IAM) DeleteUser(input *iam.DeleteUserInput) (*iam.DeleteUserOutput, error) {
+	defer c.b.change("DeleteUser")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if u.inUse() || len(u.groups) > 0 || len(u.accessKeys) > 0 || len(u.mfaDevices) > 0 ||
+		u.loginProfile || len(u.sshKeyIds) > 0 || len(u.serviceCreds) > 0 {
+		return nil, deleteConflict("Cannot delete entity, must remove referenced objects first")
+	}
+	delete(c.b.users, u.name)
+	return &iam.DeleteUserOutput{}, nil
+}
+
+func (c *IAM) AddUserToGroup(input *iam.AddUserToGroupInput) (*iam.AddUserToGroupOutput, error) {
+	defer c.b.change("AddUserToGroup")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = c.group(input.GroupName); err != nil {
+		return nil, err
+	}
+	if !contains(u.groups, aws.StringValue(input.GroupName)) {
+		u.groups = append(u.groups, aws.StringValue(input.GroupName))
+	}
+	return &iam.AddUserToGroupOutput{}, nil
+}
+
+func (c *IAM) RemoveUserFromGroup(input *iam.RemoveUserFromGroupInput) (*iam.RemoveUserFromGroupOutput, error) {
+	defer c.b.change("RemoveUserFromGroup")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if u.groups, ok = remove(u.groups, aws.StringValue(input.GroupName)); !ok {
+		return nil, noSuchEntity("User %s is not in group %s", u.name, aws.StringValue(input.GroupName))
+	}
+	return &iam.RemoveUserFromGroupOutput{}, nil
+}
+
+func (c *IAM) AttachUserPolicy(input *iam.AttachUserPolicyInput) (*iam.AttachUserPolicyOutput, error) {
+	defer c.b.change("AttachUserPolicy")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.attachable(input.PolicyArn); err != nil {
+		return nil, err
+	}
+	u.attach(aws.StringValue(input.PolicyArn))
+	return &iam.AttachUserPolicyOutput{}, nil
+}
+
+func (c *IAM) DetachUserPolicy(input *iam.DetachUserPolicyInput) (*iam.DetachUserPolicyOutput, error) {
+	defer c.b.change("DetachUserPolicy")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.DetachUserPolicyOutput{}, u.detach(aws.StringValue(input.PolicyArn))
+}
+
+func (c *IAM) PutUserPolicy(input *iam.PutUserPolicyInput) (*iam.PutUserPolicyOutput, error) {
+	defer c.b.change("PutUserPolicy")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	u.inline[aws.StringValue(input.PolicyName)] = aws.StringValue(input.PolicyDocument)
+	return &iam.PutUserPolicyOutput{}, nil
+}
+
+func (c *IAM) DeleteUserPolicy(input *iam.DeleteUserPolicyInput) (*iam.DeleteUserPolicyOutput, error) {
+	defer c.b.change("DeleteUserPolicy")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.DeleteUserPolicyOutput{}, u.deleteInline(aws.StringValue(input.PolicyName))
+}
+
+func (c *IAM) TagUser(input *iam.TagUserInput) (*iam.TagUserOutput, error) {
+	defer c.b.change("TagUser")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	u.tags.set(input.Tags)
+	return &iam.TagUserOutput{}, nil
+}
+
+func (c *IAM) UntagUser(input *iam.UntagUserInput) (*iam.UntagUserOutput, error) {
+	defer c.b.change("UntagUser")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	u.tags.unset(input.TagKeys)
+	return &iam.UntagUserOutput{}, nil
+}
+
+func (c *IAM) PutUserPermissionsBoundary(input *iam.PutUserPermissionsBoundaryInput) (*iam.PutUserPermissionsBoundaryOutput, error) {
+	defer c.b.change("PutUserPermissionsBoundary")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.attachable(input.PermissionsBoundary); err != nil {
+		return nil, err
+	}
+	u.boundary = aws.StringValue(input.PermissionsBoundary)
+	return &iam.PutUserPermissionsBoundaryOutput{}, nil
+}
+
+func (c *IAM) DeleteUserPermissionsBoundary(input *iam.DeleteUserPermissionsBoundaryInput) (*iam.DeleteUserPermissionsBoundaryOutput, error) {
+	defer c.b.change("DeleteUserPermissionsBoundary")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	u.boundary = ""
+	return &iam.DeleteUserPermissionsBoundaryOutput{}, nil
+}
+
+// User credentials
+
+func (c *IAM) CreateAccessKey(input *iam.CreateAccessKeyInput) (*iam.CreateAccessKeyOutput, error) {
+	defer c.b.change("CreateAccessKey")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	id := c.b.newId("AKIA")
+	u.accessKeys = append(u.accessKeys, id)
+	return &iam.CreateAccessKeyOutput{AccessKey: &iam.AccessKey{UserName: aws.String(u.name), AccessKeyId: aws.String(id)}}, nil
+}
+
+func (c *IAM) ListAccessKeys(input *iam.ListAccessKeysInput) (*iam.ListAccessKeysOutput, error) {
+	defer c.b.read()()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	out := iam.ListAccessKeysOutput{}
+	for _, id := range u.accessKeys {
+		out.AccessKeyMetadata = append(out.AccessKeyMetadata, &iam.AccessKeyMetadata{UserName: aws.String(u.name), AccessKeyId: aws.String(id)})
+	}
+	return &out, nil
+}
+
+func (c *IAM) DeleteAccessKey(input *iam.DeleteAccessKeyInput) (*iam.DeleteAccessKeyOutput, error) {
+	defer c.b.change("DeleteAccessKey")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if u.accessKeys, ok = remove(u.accessKeys, aws.StringValue(input.AccessKeyId)); !ok {
+		return nil, noSuchEntity("The Access Key with id %s cannot be found", aws.StringValue(input.AccessKeyId))
+	}
+	return &iam.DeleteAccessKeyOutput{}, nil
+}
+
+func (c *IAM) EnableMFADevice(input *iam.EnableMFADeviceInput) (*iam.EnableMFADeviceOutput, error) {
+	defer c.b.change("EnableMFADevice")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	u.mfaDevices = append(u.mfaDevices, aws.StringValue(input.SerialNumber))
+	return &iam.EnableMFADeviceOutput{}, nil
+}
+
+func (c *IAM) ListMFADevices(input *iam.ListMFADevicesInput) (*iam.ListMFADevicesOutput, error) {
+	defer c.b.read()()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	out := iam.ListMFADevicesOutput{}
+	for _, serial := range u.mfaDevices {
+		out.MFADevices = append(out.MFADevices, &iam.MFADevice{UserName: aws.String(u.name), SerialNumber: aws.String(serial)})
+	}
+	return &out, nil
+}
+
+func (c *IAM) DeactivateMFADevice(input *iam.DeactivateMFADeviceInput) (*iam.DeactivateMFADeviceOutput, error) {
+	defer c.b.change("DeactivateMFADevice")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if u.mfaDevices, ok = remove(u.mfaDevices, aws.StringValue(input.SerialNumber)); !ok {
+		return nil, noSuchEntity("MFA device %s cannot be found", aws.StringValue(input.SerialNumber))
+	}
+	return &iam.DeactivateMFADeviceOutput{}, nil
+}
+
+func (c *IAM) DeleteVirtualMFADevice(input *iam.DeleteVirtualMFADeviceInput) (*iam.DeleteVirtualMFADeviceOutput, error) {
+	defer c.b.change("DeleteVirtualMFADevice")()
+	for _, u := range c.b.users {
+		if contains(u.mfaDevices, aws.StringValue(input.SerialNumber)) {
+			return nil, deleteConflict("MFA device %s is in use", aws.StringValue(input.SerialNumber))
+		}
+	}
+	return &iam.DeleteVirtualMFADeviceOutput{}, nil
+}
+
+func (c *IAM) CreateLoginProfile(input *iam.CreateLoginProfileInput) (*iam.CreateLoginProfileOutput, error) {
+	defer c.b.change("CreateLoginProfile")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if u.loginProfile {
+		return nil, alreadyExists("Login Profile for user %s already exists", u.name)
+	}
+	u.loginProfile = true
+	return &iam.CreateLoginProfileOutput{LoginProfile: &iam.LoginProfile{UserName: aws.String(u.name)}}, nil
+}
+
+func (c *IAM) GetLoginProfile(input *iam.GetLoginProfileInput) (*iam.GetLoginProfileOutput, error) {
+	defer c.b.read()()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if !u.loginProfile {
+		return nil, noSuchEntity("Login Profile for User %s cannot be found", u.name)
+	}
+	return &iam.GetLoginProfileOutput{LoginProfile: &iam.LoginProfile{UserName: aws.String(u.name)}}, nil
+}
+
+func (c *IAM) DeleteLoginProfile(input *iam.DeleteLoginProfileInput) (*iam.DeleteLoginProfileOutput, error) {
+	defer c.b.change("DeleteLoginProfile")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if !u.loginProfile {
+		return nil, noSuchEntity("Login Profile for User %s cannot be found", u.name)
+	}
+	u.loginProfile = false
+	return &iam.DeleteLoginProfileOutput{}, nil
+}
+
+func (c *IAM) UploadSSHPublicKey(input *iam.UploadSSHPublicKeyInput) (*iam.UploadSSHPublicKeyOutput, error) {
+	defer c.b.change("UploadSSHPublicKey")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	id := c.b.newId("APKA")
+	u.sshKeyIds = append(u.sshKeyIds, id)
+	u.sshKeys[id] = aws.StringValue(input.SSHPublicKeyBody)
+	return &iam.UploadSSHPublicKeyOutput{SSHPublicKey: &iam.SSHPublicKey{
+		UserName:         aws.String(u.name),
+		SSHPublicKeyId:   aws.String(id),
+		SSHPublicKeyBody: input.SSHPublicKeyBody,
+	}}, nil
+}
+
+func (c *IAM) ListSSHPublicKeysPages(input *iam.ListSSHPublicKeysInput, fn func(*iam.ListSSHPublicKeysOutput, bool) bool) error {
+	c.b.mu.Lock()
+	u, err := c.user(input.UserName)
+	out := iam.ListSSHPublicKeysOutput{}
+	if err == nil {
+		for _, id := range u.sshKeyIds {
+			out.SSHPublicKeys = append(out.SSHPublicKeys, &iam.SSHPublicKeyMetadata{UserName: aws.String(u.name), SSHPublicKeyId: aws.String(id)})
+		}
+	}
+	c.b.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	fn(&out, true)
+	return nil
+}
+
+func (c *IAM) GetSSHPublicKey(input *iam.GetSSHPublicKeyInput) (*iam.GetSSHPublicKeyOutput, error) {
+	defer c.b.read()()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	body, ok := u.sshKeys[aws.StringValue(input.SSHPublicKeyId)]
+	if !ok {
+		return nil, noSuchEntity("The Public Key with id %s cannot be found", aws.StringValue(input.SSHPublicKeyId))
+	}
+	return &iam.GetSSHPublicKeyOutput{SSHPublicKey: &iam.SSHPublicKey{
+		UserName:         aws.String(u.name),
+		SSHPublicKeyId:   input.SSHPublicKeyId,
+		SSHPublicKeyBody: aws.String(body),
+	}}, nil
+}
+
+func (c *IAM) DeleteSSHPublicKey(input *iam.DeleteSSHPublicKeyInput) (*iam.DeleteSSHPublicKeyOutput, error) {
+	defer c.b.change("DeleteSSHPublicKey")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if u.sshKeyIds, ok = remove(u.sshKeyIds, aws.StringValue(input.SSHPublicKeyId)); !ok {
+		return nil, noSuchEntity("The Public Key with id %s cannot be found", aws.StringValue(input.SSHPublicKeyId))
+	}
+	delete(u.sshKeys, aws.StringValue(input.SSHPublicKeyId))
+	return &iam.DeleteSSHPublicKeyOutput{}, nil
+}
+
+func (c *IAM) CreateServiceSpecificCredential(input *iam.CreateServiceSpecificCredentialInput) (*iam.CreateServiceSpecificCredentialOutput, error) {
+	defer c.b.change("CreateServiceSpecificCredential")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	id := c.b.newId("ACCA")
+	u.serviceCreds = append(u.serviceCreds, id)
+	return &iam.CreateServiceSpecificCredentialOutput{ServiceSpecificCredential: &iam.ServiceSpecificCredential{
+		UserName:                    aws.String(u.name),
+		ServiceName:                 input.ServiceName,
+		ServiceSpecificCredentialId: aws.String(id),
+	}}, nil
+}
+
+func (c *IAM) ListServiceSpecificCredentials(input *iam.ListServiceSpecificCredentialsInput) (*iam.ListServiceSpecificCredentialsOutput, error) {
+	defer c.b.read()()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	out := iam.ListServiceSpecificCredentialsOutput{}
+	for _, id := range u.serviceCreds {
+		out.ServiceSpecificCredentials = append(out.ServiceSpecificCredentials, &iam.ServiceSpecificCredentialMetadata{
+			UserName:                    aws.String(u.name),
+			ServiceSpecificCredentialId: aws.String(id),
+		})
+	}
+	return &out, nil
+}
+
+func (c *IAM) DeleteServiceSpecificCredential(input *iam.DeleteServiceSpecificCredentialInput) (*iam.DeleteServiceSpecificCredentialOutput, error) {
+	defer c.b.change("DeleteServiceSpecificCredential")()
+	u, err := c.user(input.UserName)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if u.serviceCreds, ok = remove(u.serviceCreds, aws.StringValue(input.ServiceSpecificCredentialId)); !ok {
+		return nil, noSuchEntity("Service specific credential %s cannot be found", aws.StringValue(input.ServiceSpecificCredentialId))
+	}
+	return &iam.DeleteServiceSpecificCredentialOutput{}, nil
+}
+
+// Groups
+
+func (c *IAM) CreateGroup(input *iam.CreateGroupInput) (*iam.CreateGroupOutput, error) {
+	defer c.b.change("CreateGroup")()
+	name := aws.StringValue(input.GroupName)
+	if _, ok := c.b.groups[name]; ok {
+		return nil, alreadyExists("Group with name %s already exists", name)
+	}
+	g := group{principal: newPrincipal(), name: name, path: pathOrDefault(input.Path)}
+	c.b.groups[name] = &g
+	return &iam.CreateGroupOutput{Group: &iam.Group{GroupName: aws.String(name), Path: aws.String(g.path)}}, nil
+}
+
+func (c *IAM) DeleteGroup(input *iam.DeleteGroupInput) (*iam.DeleteGroupOutput, error) {
+	defer c.b.change("DeleteGroup")()
+	g, err := c.group(input.GroupName)
+	if err != nil {
+		return nil, err
+	}
+	inUse := g.inUse()
+	for _, u := range c.b.users {
+		inUse = inUse || contains(u.groups, g.name)
+	}
+	if inUse {
+		return nil, deleteConflict("Cannot delete entity, must remove users and policies from group first")
+	}
+	delete(c.b.groups, g.name)
+	return &iam.DeleteGroupOutput{}, nil
+}
+
+func (c *IAM) AttachGroupPolicy(input *iam.AttachGroupPolicyInput) (*iam.AttachGroupPolicyOutput, error) {
+	defer c.b.change("AttachGroupPolicy")()
+	g, err := c.group(input.GroupName)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.attachable(input.PolicyArn); err != nil {
+		return nil, err
+	}
+	g.attach(aws.StringValue(input.PolicyArn))
+	return &iam.AttachGroupPolicyOutput{}, nil
+}
+
+func (c *IAM) DetachGroupPolicy(input *iam.DetachGroupPolicyInput) (*iam.DetachGroupPolicyOutput, error) {
+	defer c.b.change("DetachGroupPolicy")()
+	g, err := c.group(input.GroupName)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.DetachGroupPolicyOutput{}, g.detach(aws.StringValue(input.PolicyArn))
+}
+
+func (c *IAM) PutGroupPolicy(input *iam.PutGroupPolicyInput) (*iam.PutGroupPolicyOutput, error) {
+	defer c.b.change("PutGroupPolicy")()
+	g, err := c.group(input.GroupName)
+	if err != nil {
+		return nil, err
+	}
+	g.inline[aws.StringValue(input.PolicyName)] = aws.StringValue(input.PolicyDocument)
+	return &iam.PutGroupPolicyOutput{}, nil
+}
+
+func (c *IAM) DeleteGroupPolicy(input *iam.DeleteGroupPolicyInput) (*iam.DeleteGroupPolicyOutput, error) {
+	defer c.b.change("DeleteGroupPolicy")()
+	g, err := c.group(input.GroupName)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.DeleteGroupPolicyOutput{}, g.deleteInline(aws.StringValue(input.PolicyName))
+}
+
+// Roles
+
+func (c *IAM) CreateRole(input *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+	defer c.b.change("CreateRole")()
+	name := aws.StringValue(input.RoleName)
+	if _, ok := c.b.roles[name]; ok {
+		return nil, alreadyExists("Role with name %s already exists", name)
+	}
+	assumeRolePolicy, err := normaliseTrustPolicy(input.AssumeRolePolicyDocument)
+	if err != nil {
+		return nil, err
+	}
+	r := role{
+		principal:          newPrincipal(),
+		name:               name,
+		path:               pathOrDefault(input.Path),
+		assumeRolePolicy:   assumeRolePolicy,
+		description:        aws.StringValue(input.Description),
+		maxSessionDuration: 3600,
+		tags:               tags{},
+	}
+	if input.MaxSessionDuration != nil {
+		r.maxSessionDuration = *input.MaxSessionDuration
+	}
+	r.tags.set(input.Tags)
+	if input.PermissionsBoundary != nil {
+		if err := c.attachable(input.PermissionsBoundary); err != nil {
+			return nil, err
+		}
+		r.boundary = aws.StringValue(input.PermissionsBoundary)
+	}
+	c.b.roles[name] = &r
+	return &iam.CreateRoleOutput{Role: &iam.Role{RoleName: aws.String(name), Path: aws.String(r.path)}}, nil
+}
+
+// normaliseTrustPolicy is the trust policy of a role as IAM stores it
+func normaliseTrustPolicy(doc *string) (string, error) {
+	normalised, err := normalisePrincipals(aws.StringValue(doc))
+	if err != nil {
+		return "", awserr.New(iam.ErrCodeMalformedPolicyDocumentException, "Syntax errors in policy.", err)
+	}
+	return normalised, nil
+}
+
+func (c *IAM) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	defer c.b.read()()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	out := iam.Role{
+		RoleName:           aws.String(r.name),
+		Path:               aws.String(r.path),
+		MaxSessionDuration: aws.Int64(r.maxSessionDuration),
+	}
+	if r.description != "" {
+		out.Description = aws.String(r.description)
+	}
+	return &iam.GetRoleOutput{Role: &out}, nil
+}
+
+func (c *IAM) DeleteRole(input *iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error) {
+	defer c.b.change("DeleteRole")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	inUse := r.inUse()
+	for _, p := range c.b.instanceProfiles {
+		inUse = inUse || contains(p.roles, r.name)
+	}
+	if inUse {
+		return nil, deleteConflict("Cannot delete entity, must detach all policies and remove from instance profiles first")
+	}
+	delete(c.b.roles, r.name)
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+func (c *IAM) UpdateAssumeRolePolicy(input *iam.UpdateAssumeRolePolicyInput) (*iam.UpdateAssumeRolePolicyOutput, error) {
+	defer c.b.change("UpdateAssumeRolePolicy")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	if r.assumeRolePolicy, err = normaliseTrustPolicy(input.PolicyDocument); err != nil {
+		return nil, err
+	}
+	return &iam.UpdateAssumeRolePolicyOutput{}, nil
+}
+
+func (c *IAM) UpdateRole(input *iam.UpdateRoleInput) (*iam.UpdateRoleOutput, error) {
+	defer c.b.change("UpdateRole")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	if input.Description != nil {
+		r.description = *input.Description
+	}
+	if input.MaxSessionDuration != nil {
+		r.maxSessionDuration = *input.MaxSessionDuration
+	}
+	return &iam.UpdateRoleOutput{}, nil
+}
+
+func (c *IAM) UpdateRoleDescription(input *iam.UpdateRoleDescriptionInput) (*iam.UpdateRoleDescriptionOutput, error) {
+	defer c.b.change("UpdateRoleDescription")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	r.description = aws.StringValue(input.Description)
+	return &iam.UpdateRoleDescriptionOutput{}, nil
+}
+
+func (c *IAM) AttachRolePolicy(input *iam.AttachRolePolicyInput) (*iam.AttachRolePolicyOutput, error) {
+	defer c.b.change("AttachRolePolicy")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.attachable(input.PolicyArn); err != nil {
+		return nil, err
+	}
+	r.attach(aws.StringValue(input.PolicyArn))
+	return &iam.AttachRolePolicyOutput{}, nil
+}
+
+func (c *IAM) DetachRolePolicy(input *iam.DetachRolePolicyInput) (*iam.DetachRolePolicyOutput, error) {
+	defer c.b.change("DetachRolePolicy")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.DetachRolePolicyOutput{}, r.detach(aws.StringValue(input.PolicyArn))
+}
+
+func (c *IAM) PutRolePolicy(input *iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error) {
+	defer c.b.change("PutRolePolicy")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	r.inline[aws.StringValue(input.PolicyName)] = aws.StringValue(input.PolicyDocument)
+	return &iam.PutRolePolicyOutput{}, nil
+}
+
+func (c *IAM) DeleteRolePolicy(input *iam.DeleteRolePolicyInput) (*iam.DeleteRolePolicyOutput, error) {
+	defer c.b.change("DeleteRolePolicy")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.DeleteRolePolicyOutput{}, r.deleteInline(aws.StringValue(input.PolicyName))
+}
+
+func (c *IAM) TagRole(input *iam.TagRoleInput) (*iam.TagRoleOutput, error) {
+	defer c.b.change("TagRole")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	r.tags.set(input.Tags)
+	return &iam.TagRoleOutput{}, nil
+}
+
+func (c *IAM) UntagRole(input *iam.UntagRoleInput) (*iam.UntagRoleOutput, error) {
+	defer c.b.change("UntagRole")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	r.tags.unset(input.TagKeys)
+	return &iam.UntagRoleOutput{}, nil
+}
+
+func (c *IAM) PutRolePermissionsBoundary(input *iam.PutRolePermissionsBoundaryInput) (*iam.PutRolePermissionsBoundaryOutput, error) {
+	defer c.b.change("PutRolePermissionsBoundary")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.attachable(input.PermissionsBoundary); err != nil {
+		return nil, err
+	}
+	r.boundary = aws.StringValue(input.PermissionsBoundary)
+	return &iam.PutRolePermissionsBoundaryOutput{}, nil
+}
+
+func (c *IAM) DeleteRolePermissionsBoundary(input *iam.DeleteRolePermissionsBoundaryInput) (*iam.DeleteRolePermissionsBoundaryOutput, error) {
+	defer c.b.change("DeleteRolePermissionsBoundary")()
+	r, err := c.role(input.RoleName)
+	if err != nil {
+		return nil, err
+	}
+	r.boundary = ""
+	return &iam.DeleteRolePermissionsBoundaryOutput{}, nil
+}
+
+// Managed policies
+
+func (c *IAM) CreatePolicy(input *iam.CreatePolicyInput) (*iam.CreatePolicyOutput, error) {
+	defer c.b.change("CreatePolicy")()
+	name, path := aws.StringValue(input.PolicyName), pathOrDefault(input.Path)
+	arn := c.b.arn("iam", "policy"+path+name)
+	if _, ok := c.b.policies[arn]; ok {
+		return nil, alreadyExists("A policy called %s already exists", name)
+	}
+	p := policy{
+		name:        name,
+		path:        path,
+		arn:         arn,
+		description: aws.StringValue(input.Description),
+		tags:        tags{},
+	}
+	p.tags.set(input.Tags)
+	p.addVersion(c.b, aws.StringValue(input.PolicyDocument), true)
+	c.b.policies[arn] = &p
+	return &iam.CreatePolicyOutput{Policy: &iam.Policy{PolicyName: aws.String(name), Path: aws.String(path), Arn: aws.String(arn)}}, nil
+}
+
+func (p *policy) addVersion(b *Backend, document string, setAsDefault bool) *iam.PolicyVersion {
+	p.nextVersion++
+	v := iam.PolicyVersion{
+		VersionId:        aws.String(fmt.Sprintf("v%d", p.nextVersion)),
+		Document:         aws.String(document),
+		IsDefaultVersion: aws.Bool(setAsDefault),
+		CreateDate:       b.tick(),
+	}
+	if setAsDefault {
+		for _, other := range p.versions {
+			other.IsDefaultVersion = aws.Bool(false)
+		}
+	}
+	p.versions = append(p.versions, &v)
+	return &v
+}
+
+func (c *IAM) GetPolicy(input *iam.GetPolicyInput) (*iam.GetPolicyOutput, error) {
+	defer c.b.read()()
+	p, err := c.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	out := iam.Policy{PolicyName: aws.String(p.name), Path: aws.String(p.path), Arn: aws.String(p.arn)}
+	if p.description != "" {
+		out.Description = aws.String(p.description)
+	}
+	return &iam.GetPolicyOutput{Policy: &out}, nil
+}
+
+func (c *IAM) CreatePolicyVersion(input *iam.CreatePolicyVersionInput) (*iam.CreatePolicyVersionOutput, error) {
+	defer c.b.change("CreatePolicyVersion")()
+	p, err := c.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.versions) >= 5 {
+		return nil, limitExceeded("A managed policy can have up to 5 versions")
+	}
+	v := p.addVersion(c.b, aws.StringValue(input.PolicyDocument), aws.BoolValue(input.SetAsDefault))
+	return &iam.CreatePolicyVersionOutput{PolicyVersion: v}, nil
+}
+
+func (c *IAM) SetDefaultPolicyVersion(input *iam.SetDefaultPolicyVersionInput) (*iam.SetDefaultPolicyVersionOutput, error) {
+	defer c.b.change("SetDefaultPolicyVersion")()
+	p, err := c.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, v := range p.versions {
+		if aws.StringValue(v.VersionId) == aws.StringValue(input.VersionId) {
+			found = true
+		}
+	}
+	if !found {
+		return nil, noSuchEntity("Policy version %s does not exist", aws.StringValue(input.VersionId))
+	}
+	for _, v := range p.versions {
+		v.IsDefaultVersion = aws.Bool(aws.StringValue(v.VersionId) == aws.StringValue(input.VersionId))
+	}
+	return &iam.SetDefaultPolicyVersionOutput{}, nil
+}
+
+func (c *IAM) DeletePolicyVersion(input *iam.DeletePolicyVersionInput) (*iam.DeletePolicyVersionOutput, error) {
+	defer c.b.change("DeletePolicyVersion")()
+	p, err := c.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range p.versions {
+		if aws.StringValue(v.VersionId) == aws.StringValue(input.VersionId) {
+			if aws.BoolValue(v.IsDefaultVersion) {
+				return nil, deleteConflict("Cannot delete the default version of a policy")
+			}
+			p.versions = append(p.versions[:i:i], p.versions[i+1:]...)
+			return &iam.DeletePolicyVersionOutput{}, nil
+		}
+	}
+	return nil, noSuchEntity("Policy version %s does not exist", aws.StringValue(input.VersionId))
+}
+
+func (c *IAM) DeletePolicy(input *iam.DeletePolicyInput) (*iam.DeletePolicyOutput, error) {
+	defer c.b.change("DeletePolicy")()
+	p, err := c.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	if c.policyInUse(p.arn) {
+		return nil, deleteConflict("Cannot delete a policy attached to entities")
+	}
+	if len(p.versions) > 1 {
+		return nil, deleteConflict("This policy has more than one version. Before you delete a policy, you must delete the policy's versions")
+	}
+	delete(c.b.policies, p.arn)
+	return &iam.DeletePolicyOutput{}, nil
+}
+
+func (c *IAM) ListPolicyTagsPages(input *iam.ListPolicyTagsInput, fn func(*iam.ListPolicyTagsOutput, bool) bool) error {
+	c.b.mu.Lock()
+	p, err := c.policy(input.PolicyArn)
+	out := iam.ListPolicyTagsOutput{}
+	if err == nil {
+		out.Tags = p.tags.iamTags()
+	}
+	c.b.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	fn(&out, true)
+	return nil
+}
+
+func (c *IAM) TagPolicy(input *iam.TagPolicyInput) (*iam.TagPolicyOutput, error) {
+	defer c.b.change("TagPolicy")()
+	p, err := c.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	p.tags.set(input.Tags)
+	return &iam.TagPolicyOutput{}, nil
+}
+
+func (c *IAM) UntagPolicy(input *iam.UntagPolicyInput) (*iam.UntagPolicyOutput, error) {
+	defer c.b.change("UntagPolicy")()
+	p, err := c.policy(input.PolicyArn)
+	if err != nil {
+		return nil, err
+	}
+	p.tags.unset(input.TagKeys)
+	return &iam.UntagPolicyOutput{}, nil
+}
+
+// Instance profiles
+
+func (c *IAM) CreateInstanceProfile(input *iam.CreateInstanceProfileInput) (*iam.CreateInstanceProfileOutput, error) {
+	defer c.b.change("CreateInstanceProfile")()
+	name := aws.StringValue(input.InstanceProfileName)
+	if _, ok := c.b.instanceProfiles[name]; ok {
+		return nil, alreadyExists("Instance Profile %s already exists", name)
+	}
+	p := instanceProfile{name: name, path: pathOrDefault(input.Path), tags: tags{}}
+	p.tags.set(input.Tags)
+	c.b.instanceProfiles[name] = &p
+	return &iam.CreateInstanceProfileOutput{InstanceProfile: &iam.InstanceProfile{InstanceProfileName: aws.String(name), Path: aws.String(p.path)}}, nil
+}
+
+func (c *IAM) DeleteInstanceProfile(input *iam.DeleteInstanceProfileInput) (*iam.DeleteInstanceProfileOutput, error) {
+	defer c.b.change("DeleteInstanceProfile")()
+	p, err := c.instanceProfile(input.InstanceProfileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.roles) > 0 {
+		return nil, deleteConflict("Cannot delete entity, must remove roles from instance profile first")
+	}
+	delete(c.b.instanceProfiles, p.name)
+	return &iam.DeleteInstanceProfileOutput{}, nil
+}
+
+func (c *IAM) AddRoleToInstanceProfile(input *iam.AddRoleToInstanceProfileInput) (*iam.AddRoleToInstanceProfileOutput, error) {
+	defer c.b.change("AddRoleToInstanceProfile")()
+	p, err := c.instanceProfile(input.InstanceProfileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = c.role(input.RoleName); err != nil {
+		return nil, err
+	}
+	if len(p.roles) > 0 {
+		return nil, limitExceeded("Cannot exceed quota for InstanceSessionsPerInstanceProfile: 1")
+	}
+	p.roles = append(p.roles, aws.StringValue(input.RoleName))
+	return &iam.AddRoleToInstanceProfileOutput{}, nil
+}
+
+func (c *IAM) RemoveRoleFromInstanceProfile(input *iam.RemoveRoleFromInstanceProfileInput) (*iam.RemoveRoleFromInstanceProfileOutput, error) {
+	defer c.b.change("RemoveRoleFromInstanceProfile")()
+	p, err := c.instanceProfile(input.InstanceProfileName)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if p.roles, ok = remove(p.roles, aws.StringValue(input.RoleName)); !ok {
+		return nil, noSuchEntity("Role %s is not in instance profile %s", aws.StringValue(input.RoleName), p.name)
+	}
+	return &iam.RemoveRoleFromInstanceProfileOutput{}, nil
+}
+
+func (c *IAM) ListInstanceProfileTagsPages(input *iam.ListInstanceProfileTagsInput, fn func(*iam.ListInstanceProfileTagsOutput, bool) bool) error {
+	c.b.mu.Lock()
+	p, err := c.instanceProfile(input.InstanceProfileName)
+	out := iam.ListInstanceProfileTagsOutput{}
+	if err == nil {
+		out.Tags = p.tags.iamTags()
+	}
+	c.b.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	fn(&out, true)
+	return nil
+}
+
+func (c *IAM) TagInstanceProfile(input *iam.TagInstanceProfileInput) (*iam.TagInstanceProfileOutput, error) {
+	defer c.b.change("TagInstanceProfile")()
+	p, err := c.instanceProfile(input.InstanceProfileName)
+	if err != nil {
+		return nil, err
+	}
+	p.tags.set(input.Tags)
+	return &iam.TagInstanceProfileOutput{}, nil
+}
+
+func (c *IAM) UntagInstanceProfile(input *iam.UntagInstanceProfileInput) (*iam.UntagInstanceProfileOutput, error) {
+	defer c.b.change("UntagInstanceProfile")()
+	p, err := c.instanceProfile(input.InstanceProfileName)
+	if err != nil {
+		return nil, err
+	}
+	p.tags.unset(input.TagKeys)
+	return &iam.UntagInstanceProfileOutput{}, nil
+}
+
+// Identity providers
+
+func (c *IAM) samlProvider(arn *string) (*samlProvider, error) {
+	if p, ok := c.b.samlProviders[aws.StringValue(arn)]; ok {
+		return p, nil
+	}
+	return nil, noSuchEntity("SAML provider %s does not exist", aws.StringValue(arn))
+}
+
+func (c *IAM) oidcProvider(arn *string) (*oidcProvider, error) {
+	if p, ok := c.b.oidcProviders[aws.StringValue(arn)]; ok {
+		return p, nil
+	}
+	return nil, noSuchEntity("OpenIDConnect Provider %s does not exist", aws.StringValue(arn))
+}
+
+func (c *IAM) CreateSAMLProvider(input *iam.CreateSAMLProviderInput) (*iam.CreateSAMLProviderOutput, error) {
+	defer c.b.change("CreateSAMLProvider")()
+	arn := c.b.arn("iam", "saml-provider/"+aws.StringValue(input.Name))
+	if _, ok := c.b.samlProviders[arn]; ok {
+		return nil, alreadyExists("SAML provider %s already exists", aws.StringValue(input.Name))
+	}
+	p := samlProvider{metadata: aws.StringValue(input.SAMLMetadataDocument), tags: tags{}}
+	p.tags.set(input.Tags)
+	c.b.samlProviders[arn] = &p
+	return &iam.CreateSAMLProviderOutput{SAMLProviderArn: aws.String(arn)}, nil
+}
+
+func (c *IAM) ListSAMLProviders(*iam.ListSAMLProvidersInput) (*iam.ListSAMLProvidersOutput, error) {
+	defer c.b.read()()
+	out := iam.ListSAMLProvidersOutput{}
+	for _, arn := range sortedNames(c.b.samlProviders) {
+		out.SAMLProviderList = append(out.SAMLProviderList, &iam.SAMLProviderListEntry{Arn: aws.String(arn)})
+	}
+	return &out, nil
+}
+
+func (c *IAM) GetSAMLProvider(input *iam.GetSAMLProviderInput) (*iam.GetSAMLProviderOutput, error) {
+	defer c.b.read()()
+	p, err := c.samlProvider(input.SAMLProviderArn)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.GetSAMLProviderOutput{SAMLMetadataDocument: aws.String(p.metadata), Tags: p.tags.iamTags()}, nil
+}
+
+func (c *IAM) UpdateSAMLProvider(input *iam.UpdateSAMLProviderInput) (*iam.UpdateSAMLProviderOutput, error) {
+	defer c.b.change("UpdateSAMLProvider")()
+	p, err := c.samlProvider(input.SAMLProviderArn)
+	if err != nil {
+		return nil, err
+	}
+	p.metadata = aws.StringValue(input.SAMLMetadataDocument)
+	return &iam.UpdateSAMLProviderOutput{SAMLProviderArn: input.SAMLProviderArn}, nil
+}
+
+func (c *IAM) DeleteSAMLProvider(input *iam.DeleteSAMLProviderInput) (*iam.DeleteSAMLProviderOutput, error) {
+	defer c.b.change("DeleteSAMLProvider")()
+	if _, err := c.samlProvider(input.SAMLProviderArn); err != nil {
+		return nil, err
+	}
+	delete(c.b.samlProviders, aws.StringValue(input.SAMLProviderArn))
+	return &iam.DeleteSAMLProviderOutput{}, nil
+}
+
+func (c *IAM) TagSAMLProvider(input *iam.TagSAMLProviderInput) (*iam.TagSAMLProviderOutput, error) {
+	defer c.b.change("TagSAMLProvider")()
+	p, err := c.samlProvider(input.SAMLProviderArn)
+	if err != nil {
+		return nil, err
+	}
+	p.tags.set(input.Tags)
+	return &iam.TagSAMLProviderOutput{}, nil
+}
+
+func (c *IAM) UntagSAMLProvider(input *iam.UntagSAMLProviderInput) (*iam.UntagSAMLProviderOutput, error) {
+	defer c.b.change("UntagSAMLProvider")()
+	p, err := c.samlProvider(input.SAMLProviderArn)
+	if err != nil {
+		return nil, err
+	}
+	p.tags.unset(input.TagKeys)
+	return &iam.UntagSAMLProviderOutput{}, nil
+}
+
+func (c *IAM) CreateOpenIDConnectProvider(input *iam.CreateOpenIDConnectProviderInput) (*iam.CreateOpenIDConnectProviderOutput, error) {
+	defer c.b.change("CreateOpenIDConnectProvider")()
+	providerUrl := aws.StringValue(input.Url)
+	arn := c.b.arn("iam", "oidc-provider/"+strings.TrimPrefix(providerUrl, "https://"))
+	if _, ok := c.b.oidcProviders[arn]; ok {
+		return nil, alreadyExists("Provider with url %s already exists", providerUrl)
+	}
+	p := oidcProvider{
+		url:         providerUrl,
+		clientIds:   aws.StringValueSlice(input.ClientIDList),
+		thumbprints: aws.StringValueSlice(input.ThumbprintList),
+		tags:        tags{},
+	}
+	p.tags.set(input.Tags)
+	c.b.oidcProviders[arn] = &p
+	return &iam.CreateOpenIDConnectProviderOutput{OpenIDConnectProviderArn: aws.String(arn)}, nil
+}
+
+func (c *IAM) ListOpenIDConnectProviders(*iam.ListOpenIDConnectProvidersInput) (*iam.ListOpenIDConnectProvidersOutput, error) {
+	defer c.b.read()()
+	out := iam.ListOpenIDConnectProvidersOutput{}
+	for _, arn := range sortedNames(c.b.oidcProviders) {
+		out.OpenIDConnectProviderList = append(out.OpenIDConnectProviderList, &iam.OpenIDConnectProviderListEntry{Arn: aws.String(arn)})
+	}
+	return &out, nil
+}
+
+func (c *IAM) GetOpenIDConnectProvider(input *iam.GetOpenIDConnectProviderInput) (*iam.GetOpenIDConnectProviderOutput, error) {
+	defer c.b.read()()
+	p, err := c.oidcProvider(input.OpenIDConnectProviderArn)
+	if err != nil {
+		return nil, err
+	}
+	return &iam.GetOpenIDConnectProviderOutput{
+		Url:            aws.String(strings.TrimPrefix(p.url, "https://")),
+		ClientIDList:   aws.StringSlice(p.clientIds),
+		ThumbprintList: aws.StringSlice(p.thumbprints),
+		Tags:           p.tags.iamTags(),
+	}, nil
+}
+
+func (c *IAM) DeleteOpenIDConnectProvider(input *iam.DeleteOpenIDConnectProviderInput) (*iam.DeleteOpenIDConnectProviderOutput, error) {
+	defer c.b.change("DeleteOpenIDConnectProvider")()
+	if _, err := c.oidcProvider(input.OpenIDConnectProviderArn); err != nil {
+		return nil, err
+	}
+	delete(c.b.oidcProviders, aws.StringValue(input.OpenIDConnectProviderArn))
+	return &iam.DeleteOpenIDConnectProviderOutput{}, nil
+}
+
+func (c *IAM) AddClientIDToOpenIDConnectProvider(input *iam.AddClientIDToOpenIDConnectProviderInput) (*iam.AddClientIDToOpenIDConnectProviderOutput, error) {
+	defer c.b.change("AddClientIDToOpenIDConnectProvider")()
+	p, err := c.oidcProvider(input.OpenIDConnectProviderArn)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(p.clientIds, aws.StringValue(input.ClientID)) {
+		p.clientIds = append(p.clientIds, aws.StringValue(input.ClientID))
+	}
+	return &iam.AddClientIDToOpenIDConnectProviderOutput{}, nil
+}
+
+func (c *IAM) RemoveClientIDFromOpenIDConnectProvider(input *iam.RemoveClientIDFromOpenIDConnectProviderInput) (*iam.RemoveClientIDFromOpenIDConnectProviderOutput, error) {
+	defer c.b.change("RemoveClientIDFromOpenIDConnectProvider")()
+	p, err := c.oidcProvider(input.OpenIDConnectProviderArn)
+	if err != nil {
+		return nil, err
+	}
+	p.clientIds, _ = remove(p.clientIds, aws.StringValue(input.ClientID))
+	return &iam.RemoveClientIDFromOpenIDConnectProviderOutput{}, nil
+}
+
+func (c *IAM) UpdateOpenIDConnectProviderThumbprint(input *iam.UpdateOpenIDConnectProviderThumbprintInput) (*iam.UpdateOpenIDConnectProviderThumbprintOutput, error) {
+	defer c.b.change("UpdateOpenIDConnectProviderThumbprint")()
+	p, err := c.oidcProvider(input.OpenIDConnectProviderArn)
+	if err != nil {
+		return nil, err
+	}
+	p.thumbprints = aws.StringValueSlice(input.ThumbprintList)
+	return &iam.UpdateOpenIDConnectProviderThumbprintOutput{}, nil
+}
+
+func (c *IAM) TagOpenIDConnectProvider(input *iam.TagOpenIDConnectProviderInput) (*iam.TagOpenIDConnectProviderOutput, error) {
+	defer c.b.change("TagOpenIDConnectProvider")()
+	p, err := c.oidcProvider(input.OpenIDConnectProviderArn)
+	if err != nil {
+		return nil, err
+	}
+	p.tags.set(input.Tags)
+	return &iam.TagOpenIDConnectProviderOutput{}, nil
+}
+
+func (c *IAM) UntagOpenIDConnectProvider(input *iam.UntagOpenIDConnectProviderInput) (*iam.UntagOpenIDConnectProviderOutput, error) {
+	defer c.b.change("UntagOpenIDConnectProvider")()
+	p, err := c.oidcProvider(input.OpenIDConnectProviderArn)
+	if err != nil {
+		return nil, err
+	}
+	p.tags.unset(input.TagKeys)
+	return &iam.UntagOpenIDConnectProviderOutput{}, nil
+}
+
+// sortedNames are the keys of a map of resources by name, in order
+func sortedNames(m interface{}) []string {
+	names := []string{}
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		names = append(names, k.String())
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,84 @@
+package memaws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// normalisePrincipals rewrites the account ids in the AWS principals of a
+// resource policy to the arn of the account's root user, as IAM and S3 do.
+// A document without account id principals is kept as it was sent
+func normalisePrincipals(doc string) (string, error) {
+	var policy map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &policy); err != nil {
+		return "", err
+	}
+
+	statements, ok := policy["Statement"].([]interface{})
+	if !ok {
+		statements = []interface{}{policy["Statement"]}
+	}
+	changed := false
+	for _, s := range statements {
+		statement, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"Principal", "NotPrincipal"} {
+			if principal, ok := statement[key].(map[string]interface{}); ok {
+				if aws, ok := rootArns(principal["AWS"]); ok {
+					principal["AWS"], changed = aws, true
+				}
+			}
+		}
+	}
+	if !changed {
+		return doc, nil
+	}
+
+	b, err := json.Marshal(policy)
+	return string(b), err
+}
+
+// rootArns rewrites account ids in an AWS principal of one or more
+// arns, returning false if there aren't any
+func rootArns(principal interface{}) (interface{}, bool) {
+	switch p := principal.(type) {
+	case string:
+		if isAccountId(p) {
+			return fmt.Sprintf("arn:aws:iam::%s:root", p), true
+		}
+	case []interface{}:
+		changed := false
+		rewritten := []interface{}{}
+		for _, v := range p {
+			arn, ok := rootArns(v)
+			changed = changed || ok
+			rewritten = append(rewritten, arn)
+		}
+		return rewritten, changed
+	}
+	return principal, false
+}
+
+func isAccountId(s string) bool {
+	if len(s) != 12 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compactPolicy is a policy document without whitespace, as S3 returns it
+func compactPolicy(doc string) (string, error) {
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(doc)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
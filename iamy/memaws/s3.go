@@ -0,0 +1,202 @@
+package memaws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"github.com/aws/aws-sdk-go/service/s3control/s3controliface"
+)
+
+const (
+	noSuchBucketPolicy      = "NoSuchBucketPolicy"
+	malformedPolicy         = "MalformedPolicy"
+	noSuchPublicAccessBlock = "NoSuchPublicAccessBlockConfiguration"
+)
+
+type bucket struct {
+	name, region string
+	policy       string
+	pab          *s3.PublicAccessBlockConfiguration
+}
+
+// S3 is a fake of the S3 API backed by a Backend. It serves
+// buckets in every region
+type S3 struct {
+	s3iface.S3API
+	b *Backend
+}
+
+// S3 returns a fake S3 client for the backend
+func (b *Backend) S3() *S3 {
+	return &S3{b: b}
+}
+
+func (c *S3) bucket(name *string) (*bucket, error) {
+	if b, ok := c.b.buckets[aws.StringValue(name)]; ok {
+		return b, nil
+	}
+	return nil, awserr.New(s3.ErrCodeNoSuchBucket, fmt.Sprintf("The specified bucket %s does not exist", aws.StringValue(name)), nil)
+}
+
+func (c *S3) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	defer c.b.change("CreateBucket")()
+	name := aws.StringValue(input.Bucket)
+	if _, ok := c.b.buckets[name]; ok {
+		return nil, awserr.New(s3.ErrCodeBucketAlreadyOwnedByYou, fmt.Sprintf("Bucket %s already exists", name), nil)
+	}
+	b := bucket{name: name}
+	if input.CreateBucketConfiguration != nil {
+		b.region = aws.StringValue(input.CreateBucketConfiguration.LocationConstraint)
+	}
+	c.b.buckets[name] = &b
+	return &s3.CreateBucketOutput{}, nil
+}
+
+func (c *S3) ListBuckets(*s3.ListBucketsInput) (*s3.ListBucketsOutput, error) {
+	defer c.b.read()()
+	out := s3.ListBucketsOutput{}
+	for _, name := range sortedNames(c.b.buckets) {
+		out.Buckets = append(out.Buckets, &s3.Bucket{Name: aws.String(name)})
+	}
+	return &out, nil
+}
+
+func (c *S3) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
+	defer c.b.read()()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	out := s3.GetBucketLocationOutput{}
+	if b.region != "" {
+		out.LocationConstraint = aws.String(b.region)
+	}
+	return &out, nil
+}
+
+func (c *S3) GetBucketPolicy(input *s3.GetBucketPolicyInput) (*s3.GetBucketPolicyOutput, error) {
+	defer c.b.read()()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if b.policy == "" {
+		return nil, awserr.New(noSuchBucketPolicy, "The bucket policy does not exist", nil)
+	}
+	return &s3.GetBucketPolicyOutput{Policy: aws.String(b.policy)}, nil
+}
+
+func (c *S3) PutBucketPolicy(input *s3.PutBucketPolicyInput) (*s3.PutBucketPolicyOutput, error) {
+	defer c.b.change("PutBucketPolicy")()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := normalisePrincipals(aws.StringValue(input.Policy))
+	if err == nil {
+		policy, err = compactPolicy(policy)
+	}
+	if err != nil {
+		return nil, awserr.New(malformedPolicy, "Policies must be valid JSON and the first byte must be '{'", err)
+	}
+	b.policy = policy
+	return &s3.PutBucketPolicyOutput{}, nil
+}
+
+func (c *S3) DeleteBucketPolicy(input *s3.DeleteBucketPolicyInput) (*s3.DeleteBucketPolicyOutput, error) {
+	defer c.b.change("DeleteBucketPolicy")()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	b.policy = ""
+	return &s3.DeleteBucketPolicyOutput{}, nil
+}
+
+func (c *S3) GetPublicAccessBlock(input *s3.GetPublicAccessBlockInput) (*s3.GetPublicAccessBlockOutput, error) {
+	defer c.b.read()()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if b.pab == nil {
+		return nil, awserr.New(noSuchPublicAccessBlock, "The public access block configuration was not found", nil)
+	}
+	pab := *b.pab
+	return &s3.GetPublicAccessBlockOutput{PublicAccessBlockConfiguration: &pab}, nil
+}
+
+func (c *S3) PutPublicAccessBlock(input *s3.PutPublicAccessBlockInput) (*s3.PutPublicAccessBlockOutput, error) {
+	defer c.b.change("PutPublicAccessBlock")()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	pab := *input.PublicAccessBlockConfiguration
+	b.pab = &pab
+	return &s3.PutPublicAccessBlockOutput{}, nil
+}
+
+func (c *S3) DeletePublicAccessBlock(input *s3.DeletePublicAccessBlockInput) (*s3.DeletePublicAccessBlockOutput, error) {
+	defer c.b.change("DeletePublicAccessBlock")()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	b.pab = nil
+	return &s3.DeletePublicAccessBlockOutput{}, nil
+}
+
+// S3Control is a fake of the S3 Control API backed by a Backend,
+// for the account's Block Public Access settings
+type S3Control struct {
+	s3controliface.S3ControlAPI
+	b *Backend
+}
+
+// S3Control returns a fake S3 Control client for the backend
+func (b *Backend) S3Control() *S3Control {
+	return &S3Control{b: b}
+}
+
+func (c *S3Control) checkAccount(accountId *string) error {
+	if aws.StringValue(accountId) != c.b.AccountId {
+		return awserr.New("AccessDenied", fmt.Sprintf("Access denied to account %s", aws.StringValue(accountId)), nil)
+	}
+	return nil
+}
+
+func (c *S3Control) GetPublicAccessBlock(input *s3control.GetPublicAccessBlockInput) (*s3control.GetPublicAccessBlockOutput, error) {
+	defer c.b.read()()
+	if err := c.checkAccount(input.AccountId); err != nil {
+		return nil, err
+	}
+	if c.b.accountPab == nil {
+		return nil, awserr.New(noSuchPublicAccessBlock, "The public access block configuration was not found", nil)
+	}
+	pab := *c.b.accountPab
+	return &s3control.GetPublicAccessBlockOutput{PublicAccessBlockConfiguration: &pab}, nil
+}
+
+func (c *S3Control) PutPublicAccessBlock(input *s3control.PutPublicAccessBlockInput) (*s3control.PutPublicAccessBlockOutput, error) {
+	defer c.b.change("PutAccountPublicAccessBlock")()
+	if err := c.checkAccount(input.AccountId); err != nil {
+		return nil, err
+	}
+	pab := *input.PublicAccessBlockConfiguration
+	c.b.accountPab = &pab
+	return &s3control.PutPublicAccessBlockOutput{}, nil
+}
+
+func (c *S3Control) DeletePublicAccessBlock(input *s3control.DeletePublicAccessBlockInput) (*s3control.DeletePublicAccessBlockOutput, error) {
+	defer c.b.change("DeleteAccountPublicAccessBlock")()
+	if err := c.checkAccount(input.AccountId); err != nil {
+		return nil, err
+	}
+	c.b.accountPab = nil
+	return &s3control.DeletePublicAccessBlockOutput{}, nil
+}
@@ -0,0 +1,43 @@
+package memaws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// STS is a fake of the STS API, identifying callers as the backend's account
+type STS struct {
+	stsiface.STSAPI
+	b *Backend
+}
+
+// STS returns a fake STS client for the backend
+func (b *Backend) STS() *STS {
+	return &STS{b: b}
+}
+
+func (c *STS) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(c.b.AccountId),
+		Arn:     aws.String(c.b.arn("iam", "user/iamy")),
+	}, nil
+}
+
+// Organizations is a fake of the Organizations API for an
+// account that isn't in an organization
+type Organizations struct {
+	organizationsiface.OrganizationsAPI
+}
+
+// Organizations returns a fake Organizations client for the backend
+func (b *Backend) Organizations() *Organizations {
+	return &Organizations{}
+}
+
+func (c *Organizations) DescribeOrganization(*organizations.DescribeOrganizationInput) (*organizations.DescribeOrganizationOutput, error) {
+	return nil, awserr.New(organizations.ErrCodeAWSOrganizationsNotInUseException, "Your account is not a member of an organization", nil)
+}
@@ -1,8 +1,10 @@
 package iamy
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -66,18 +68,68 @@ func (s iamService) ResourcePath() string {
 	return s.Path
 }
 
+// A User's ConsoleAccess, MFARequired and MaxAccessKeys are expectations of
+// the user's credentials, which iamy can't create as they're secret.
+// A nil ConsoleAccess or MaxAccessKeys has no expectation, and nil
+// SSHPublicKeys leaves the user's ssh public keys as they are
 type User struct {
-	iamService     `json:"-"`
-	Groups         []string          `json:"Groups,omitempty"`
-	InlinePolicies []InlinePolicy    `json:"InlinePolicies,omitempty"`
-	Policies       []string          `json:"Policies,omitempty"`
-	Tags           map[string]string `json:"Tags,omitempty"`
+	iamService          `json:"-"`
+	credentials         userCredentials
+	Groups              []string          `json:"Groups,omitempty"`
+	InlinePolicies      []InlinePolicy    `json:"InlinePolicies,omitempty"`
+	Policies            []string          `json:"Policies,omitempty"`
+	PermissionsBoundary string            `json:"PermissionsBoundary,omitempty"`
+	ConsoleAccess       *bool             `json:"ConsoleAccess,omitempty"`
+	MFARequired         bool              `json:"MFARequired,omitempty"`
+	MaxAccessKeys       *int              `json:"MaxAccessKeys,omitempty"`
+	SSHPublicKeys       *[]string         `json:"SSHPublicKeys,omitempty"`
+	Tags                map[string]string `json:"Tags,omitempty"`
 }
 
 func (u User) ResourceType() string {
 	return "user"
 }
 
+func (u User) hasCredentialExpectations() bool {
+	return u.ConsoleAccess != nil || u.MFARequired || u.MaxAccessKeys != nil
+}
+
+// userCredentials is the state of a User's credentials in AWS.
+// SSHPublicKeysOnly is true if the credentials other than ssh public keys
+// weren't fetched, and SSHPublicKeysSkipped if the ssh public keys weren't
+type userCredentials struct {
+	AccessKeyIds                 []string
+	MFADevices                   []string
+	HasLoginProfile              bool
+	SSHPublicKeyIds              map[string]string
+	ServiceSpecificCredentialIds []string
+	SSHPublicKeysOnly            bool
+	SSHPublicKeysSkipped         bool `json:",omitempty"`
+}
+
+// normaliseSSHPublicKey is the type and body of an ssh public key,
+// without the comment, which AWS doesn't keep
+func normaliseSSHPublicKey(key string) string {
+	fields := strings.Fields(key)
+	if len(fields) > 2 {
+		fields = fields[:2]
+	}
+	return strings.Join(fields, " ")
+}
+
+// sshPublicKeyId is the id of an ssh public key in AWS, or "" if the key isn't uploaded
+func (c userCredentials) sshPublicKeyId(key string) string {
+	return c.SSHPublicKeyIds[normaliseSSHPublicKey(key)]
+}
+
+func (u User) remoteState() interface{} {
+	return u.credentials
+}
+
+func (u *User) setRemoteState(data []byte) error {
+	return json.Unmarshal(data, &u.credentials)
+}
+
 type Group struct {
 	iamService     `json:"-"`
 	InlinePolicies []InlinePolicy `json:"InlinePolicies,omitempty"`
@@ -98,25 +150,56 @@ type Policy struct {
 	numberOfVersions     int
 	oldestVersionId      string
 	nondefaultVersionIds []string
-	Description          string          `json:"Description,omitempty"`
-	Policy               *PolicyDocument `json:"Policy"`
+	defaultVersionId     string
+	Description          string            `json:"Description,omitempty"`
+	Policy               *PolicyDocument   `json:"Policy"`
+	Tags                 map[string]string `json:"Tags,omitempty"`
 }
 
 func (p Policy) ResourceType() string {
 	return "policy"
 }
 
+// policyVersionState is the state of a Policy's versions in AWS
+type policyVersionState struct {
+	NumberOfVersions     int
+	OldestVersionId      string
+	NondefaultVersionIds []string
+	DefaultVersionId     string `json:",omitempty"`
+}
+
+func (p Policy) remoteState() interface{} {
+	return policyVersionState{p.numberOfVersions, p.oldestVersionId, p.nondefaultVersionIds, p.defaultVersionId}
+}
+
+func (p *Policy) setRemoteState(data []byte) error {
+	var s policyVersionState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	p.numberOfVersions, p.oldestVersionId, p.nondefaultVersionIds, p.defaultVersionId = s.NumberOfVersions, s.OldestVersionId, s.NondefaultVersionIds, s.DefaultVersionId
+	return nil
+}
+
+// DefaultMaxSessionDuration is the MaxSessionDuration of a role when none is specified,
+// which is omitted from yaml
+const DefaultMaxSessionDuration = 3600
+
 type Role struct {
 	iamService               `json:"-"`
-	Description              string          `json:"Description,omitempty"`
-	AssumeRolePolicyDocument *PolicyDocument `json:"AssumeRolePolicyDocument"`
-	InlinePolicies           []InlinePolicy  `json:"InlinePolicies,omitempty"`
-	Policies                 []string        `json:"Policies,omitempty"`
+	Description              string            `json:"Description,omitempty"`
+	MaxSessionDuration       int64             `json:"MaxSessionDuration,omitempty"`
+	AssumeRolePolicyDocument *PolicyDocument   `json:"AssumeRolePolicyDocument"`
+	InlinePolicies           []InlinePolicy    `json:"InlinePolicies,omitempty"`
+	Policies                 []string          `json:"Policies,omitempty"`
+	PermissionsBoundary      string            `json:"PermissionsBoundary,omitempty"`
+	Tags                     map[string]string `json:"Tags,omitempty"`
 }
 
 type InstanceProfile struct {
 	iamService `json:"-"`
-	Roles      []string `json:"Roles,omitempty"`
+	Roles      []string          `json:"Roles,omitempty"`
+	Tags       map[string]string `json:"Tags,omitempty"`
 }
 
 func (ip InstanceProfile) ResourceType() string {
@@ -127,9 +210,72 @@ func (r Role) ResourceType() string {
 	return "role"
 }
 
+func (r Role) maxSessionDuration() int64 {
+	if r.MaxSessionDuration == 0 {
+		return DefaultMaxSessionDuration
+	}
+	return r.MaxSessionDuration
+}
+
+type SamlProvider struct {
+	iamService           `json:"-"`
+	SAMLMetadataDocument string            `json:"SAMLMetadataDocument"`
+	Tags                 map[string]string `json:"Tags,omitempty"`
+}
+
+func (p SamlProvider) ResourceType() string {
+	return "saml-provider"
+}
+
+// An OidcProvider is named by its url without the scheme, which can include
+// a path, eg oidc.eks.us-east-1.amazonaws.com/id/EXAMPLE has the path
+// /oidc.eks.us-east-1.amazonaws.com/id/ and the name EXAMPLE
+type OidcProvider struct {
+	iamService     `json:"-"`
+	ClientIDList   []string          `json:"ClientIDList,omitempty"`
+	ThumbprintList []string          `json:"ThumbprintList,omitempty"`
+	Tags           map[string]string `json:"Tags,omitempty"`
+}
+
+func (p OidcProvider) ResourceType() string {
+	return "oidc-provider"
+}
+
+// Url is the url of the identity provider
+func (p OidcProvider) Url() string {
+	return "https://" + strings.TrimPrefix(p.Path, "/") + p.Name
+}
+
+// oidcProviderNameAndPath splits the url of an identity provider, with or
+// without the scheme, into the name and path of an OidcProvider
+func oidcProviderNameAndPath(url string) iamService {
+	url = strings.TrimPrefix(url, "https://")
+	i := strings.LastIndex(url, "/")
+	return iamService{
+		Name: url[i+1:],
+		Path: "/" + url[:i+1],
+	}
+}
+
+// PublicAccessBlock is the S3 Block Public Access settings of a bucket or account
+type PublicAccessBlock struct {
+	BlockPublicAcls       bool `json:"BlockPublicAcls"`
+	IgnorePublicAcls      bool `json:"IgnorePublicAcls"`
+	BlockPublicPolicy     bool `json:"BlockPublicPolicy"`
+	RestrictPublicBuckets bool `json:"RestrictPublicBuckets"`
+}
+
+// blocksPublicPolicies indicates that public bucket policies are refused or have no effect
+func (pab *PublicAccessBlock) blocksPublicPolicies() bool {
+	return pab != nil && (pab.BlockPublicPolicy || pab.RestrictPublicBuckets)
+}
+
+// A BucketPolicy is the policy and Block Public Access settings of a bucket,
+// either of which can be unset
 type BucketPolicy struct {
-	BucketName string          `json:"-"`
-	Policy     *PolicyDocument `json:"Policy"`
+	BucketName        string             `json:"-"`
+	Policy            *PolicyDocument    `json:"Policy,omitempty"`
+	PublicAccessBlock *PublicAccessBlock `json:"PublicAccessBlock,omitempty"`
 }
 
 func (bp BucketPolicy) Service() string {
@@ -148,14 +294,241 @@ func (bp BucketPolicy) ResourcePath() string {
 	return "/"
 }
 
+// ResourcePolicyServices are the services with resource policies kept in
+// a directory per region, eg sqs/us-east-1/myqueue.yaml
+var ResourcePolicyServices = []string{"sqs", "sns", "kms", "ecr", "lambda", "secretsmanager"}
+
+// A ResourcePolicy is the resource-based policy of a resource in a region,
+// such as an SQS queue or a KMS key. Name is how the service identifies the
+// resource, eg the queue name or key id
+type ResourcePolicy struct {
+	ServiceName string          `json:"-"`
+	Region      string          `json:"-"`
+	Name        string          `json:"-"`
+	Policy      *PolicyDocument `json:"Policy"`
+}
+
+func (rp ResourcePolicy) Service() string {
+	return rp.ServiceName
+}
+
+func (rp ResourcePolicy) ResourceType() string {
+	return ""
+}
+
+func (rp ResourcePolicy) ResourceName() string {
+	return rp.Name
+}
+
+func (rp ResourcePolicy) ResourcePath() string {
+	return rp.Region + "/"
+}
+
+// AccountAlias is the alias of the account. The alias is usually taken from
+// the name of the account directory, but can be set in a file instead
+type AccountAlias struct {
+	Alias string `json:"Alias"`
+}
+
+func (aa AccountAlias) Service() string {
+	return "iam"
+}
+
+func (aa AccountAlias) ResourceType() string {
+	return ""
+}
+
+func (aa AccountAlias) ResourceName() string {
+	return "account-alias"
+}
+
+func (aa AccountAlias) ResourcePath() string {
+	return ""
+}
+
+// DefaultMinimumPasswordLength is the MinimumPasswordLength of a password
+// policy when none is specified, which is omitted from yaml
+const DefaultMinimumPasswordLength = 6
+
+// PasswordPolicy is the password policy of the account. Unset fields
+// take the AWS defaults when the policy is updated
+type PasswordPolicy struct {
+	MinimumPasswordLength      int64 `json:"MinimumPasswordLength,omitempty"`
+	RequireSymbols             bool  `json:"RequireSymbols,omitempty"`
+	RequireNumbers             bool  `json:"RequireNumbers,omitempty"`
+	RequireUppercaseCharacters bool  `json:"RequireUppercaseCharacters,omitempty"`
+	RequireLowercaseCharacters bool  `json:"RequireLowercaseCharacters,omitempty"`
+	AllowUsersToChangePassword bool  `json:"AllowUsersToChangePassword,omitempty"`
+	MaxPasswordAge             int64 `json:"MaxPasswordAge,omitempty"`
+	PasswordReusePrevention    int64 `json:"PasswordReusePrevention,omitempty"`
+	HardExpiry                 bool  `json:"HardExpiry,omitempty"`
+}
+
+// withDefaults returns the policy with unset fields set to the AWS defaults
+func (pp PasswordPolicy) withDefaults() PasswordPolicy {
+	if pp.MinimumPasswordLength == 0 {
+		pp.MinimumPasswordLength = DefaultMinimumPasswordLength
+	}
+	return pp
+}
+
+func (pp PasswordPolicy) Service() string {
+	return "iam"
+}
+
+func (pp PasswordPolicy) ResourceType() string {
+	return ""
+}
+
+func (pp PasswordPolicy) ResourceName() string {
+	return "account-password-policy"
+}
+
+func (pp PasswordPolicy) ResourcePath() string {
+	return ""
+}
+
+type organizationsService struct {
+	Name string `json:"-"`
+	Path string `json:"-"`
+}
+
+func (s organizationsService) Service() string {
+	return "organizations"
+}
+
+func (s organizationsService) ResourceName() string {
+	return s.Name
+}
+
+func (s organizationsService) ResourcePath() string {
+	return s.Path
+}
+
+// RootTarget is how the root of the organization is named as a policy target
+const RootTarget = "Root"
+
+// An OrganizationalUnit is named by its path from the root, eg Engineering/Prod
+// has the path /Engineering/ and the name Prod. Accounts are the ids of the
+// accounts in the OU, and accounts in no OU are in the root
+type OrganizationalUnit struct {
+	organizationsService `json:"-"`
+	id                   string
+	Accounts             []string `json:"Accounts,omitempty"`
+}
+
+func (ou OrganizationalUnit) ResourceType() string {
+	return "ou"
+}
+
+func (ou OrganizationalUnit) remoteState() interface{} {
+	return ou.id
+}
+
+func (ou *OrganizationalUnit) setRemoteState(data []byte) error {
+	return json.Unmarshal(data, &ou.id)
+}
+
+// OuPath is the path of the OU from the root, eg Engineering/Prod
+func (ou OrganizationalUnit) OuPath() string {
+	return strings.TrimPrefix(ou.Path, "/") + ou.Name
+}
+
+// parentOuPath is the path of the OU's parent, or "" for the root
+func (ou OrganizationalUnit) parentOuPath() string {
+	return strings.Trim(ou.Path, "/")
+}
+
+// A ServiceControlPolicy is an Organizations SCP. Targets are where the
+// policy is attached, being Root, the path of an OU or an account id
+type ServiceControlPolicy struct {
+	organizationsService `json:"-"`
+	id                   string
+	Description          string          `json:"Description,omitempty"`
+	Policy               *PolicyDocument `json:"Policy"`
+	Targets              []string        `json:"Targets,omitempty"`
+}
+
+func (p ServiceControlPolicy) ResourceType() string {
+	return "policy"
+}
+
+func (p ServiceControlPolicy) remoteState() interface{} {
+	return p.id
+}
+
+func (p *ServiceControlPolicy) setRemoteState(data []byte) error {
+	return json.Unmarshal(data, &p.id)
+}
+
+// AccountPublicAccessBlock is the S3 Block Public Access settings
+// that apply to every bucket in the account
+type AccountPublicAccessBlock struct {
+	PublicAccessBlock
+}
+
+func (pab AccountPublicAccessBlock) Service() string {
+	return "s3control"
+}
+
+func (pab AccountPublicAccessBlock) ResourceType() string {
+	return ""
+}
+
+func (pab AccountPublicAccessBlock) ResourceName() string {
+	return "public-access-block"
+}
+
+func (pab AccountPublicAccessBlock) ResourcePath() string {
+	return ""
+}
+
 type AccountData struct {
-	Account          *Account
-	Users            []*User
-	Groups           []*Group
-	Roles            []*Role
-	Policies         []*Policy
-	BucketPolicies   []*BucketPolicy
-	InstanceProfiles []*InstanceProfile
+	Account           *Account
+	Users             []*User
+	Groups            []*Group
+	Roles             []*Role
+	Policies          []*Policy
+	BucketPolicies    []*BucketPolicy
+	InstanceProfiles  []*InstanceProfile
+	SamlProviders     []*SamlProvider
+	OidcProviders     []*OidcProvider
+	ResourcePolicies  []*ResourcePolicy
+	PasswordPolicy    *PasswordPolicy
+	PublicAccessBlock *AccountPublicAccessBlock
+
+	OrganizationalUnits    []*OrganizationalUnit
+	ServiceControlPolicies []*ServiceControlPolicy
+
+	// organizationRootId is the id of the organization root when
+	// the account is an organization's management account
+	organizationRootId string
+
+	// hasOrganizationalUnitsDir and hasServiceControlPoliciesDir are true
+	// when the yaml has an organizations/ou or organizations/policy directory
+	hasOrganizationalUnitsDir    bool
+	hasServiceControlPoliciesDir bool
+
+	// fetchedRegions are the regions resource policies were fetched from
+	fetchedRegions []string
+
+	// noPasswordPolicy is true when the account has no password policy,
+	// rather than one that isn't managed, as when an empty password policy
+	// file declares it or the account was fetched without one
+	noPasswordPolicy bool
+
+	// noAccountAlias is true when the account has no alias, rather than one
+	// that isn't managed, as when an account alias file declares an empty
+	// alias or the account was fetched without one
+	noAccountAlias bool
+
+	// publicAccessBlockUnknown is true when the account's Block Public Access
+	// settings couldn't be fetched for lack of permission
+	publicAccessBlockUnknown bool
+
+	// unknownBucketPublicAccessBlocks are the buckets whose Block Public
+	// Access settings couldn't be fetched for lack of permission
+	unknownBucketPublicAccessBlocks []string
 }
 
 func NewAccountData(account string) *AccountData {
@@ -166,7 +539,57 @@ func NewAccountData(account string) *AccountData {
 		Roles:            []*Role{},
 		Policies:         []*Policy{},
 		InstanceProfiles: []*InstanceProfile{},
+		SamlProviders:    []*SamlProvider{},
+		OidcProviders:    []*OidcProvider{},
+	}
+}
+
+// Resources lists every resource in the account
+func (a *AccountData) Resources() []AwsResource {
+	rr := []AwsResource{}
+	for _, u := range a.Users {
+		rr = append(rr, u)
+	}
+	for _, p := range a.Policies {
+		rr = append(rr, p)
+	}
+	for _, g := range a.Groups {
+		rr = append(rr, g)
+	}
+	for _, r := range a.Roles {
+		rr = append(rr, r)
+	}
+	for _, p := range a.InstanceProfiles {
+		rr = append(rr, p)
+	}
+	for _, bp := range a.BucketPolicies {
+		rr = append(rr, bp)
+	}
+	for _, p := range a.SamlProviders {
+		rr = append(rr, p)
+	}
+	for _, p := range a.OidcProviders {
+		rr = append(rr, p)
+	}
+	for _, rp := range a.ResourcePolicies {
+		rr = append(rr, rp)
+	}
+	for _, ou := range a.OrganizationalUnits {
+		rr = append(rr, ou)
+	}
+	for _, p := range a.ServiceControlPolicies {
+		rr = append(rr, p)
+	}
+	if a.Account.Alias != "" {
+		rr = append(rr, AccountAlias{a.Account.Alias})
 	}
+	if a.PasswordPolicy != nil {
+		rr = append(rr, a.PasswordPolicy)
+	}
+	if a.PublicAccessBlock != nil {
+		rr = append(rr, a.PublicAccessBlock)
+	}
+	return rr
 }
 
 func (a *AccountData) addUser(u *User) {
@@ -193,6 +616,38 @@ func (a *AccountData) addBucketPolicy(bp *BucketPolicy) {
 	a.BucketPolicies = append(a.BucketPolicies, bp)
 }
 
+func (a *AccountData) addSamlProvider(p *SamlProvider) {
+	a.SamlProviders = append(a.SamlProviders, p)
+}
+
+func (a *AccountData) addOidcProvider(p *OidcProvider) {
+	a.OidcProviders = append(a.OidcProviders, p)
+}
+
+func (a *AccountData) addOrganizationalUnit(ou *OrganizationalUnit) {
+	a.OrganizationalUnits = append(a.OrganizationalUnits, ou)
+}
+
+func (a *AccountData) addServiceControlPolicy(p *ServiceControlPolicy) {
+	a.ServiceControlPolicies = append(a.ServiceControlPolicies, p)
+}
+
+func (a *AccountData) addResourcePolicy(rp *ResourcePolicy) {
+	a.ResourcePolicies = append(a.ResourcePolicies, rp)
+}
+
+// Regions are the regions with resource policies
+func (a *AccountData) Regions() []string {
+	regions := []string{}
+	for _, rp := range a.ResourcePolicies {
+		if !containsString(regions, rp.Region) {
+			regions = append(regions, rp.Region)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
+
 func (a *AccountData) FindUserByName(name, path string) (bool, *User) {
 	for _, u := range a.Users {
 		if u.Name == name && u.Path == path {
@@ -243,6 +698,107 @@ func (a *AccountData) FindInstanceProfileByName(name, path string) (bool, *Insta
 	return false, nil
 }
 
+func (a *AccountData) FindSamlProviderByName(name, path string) (bool, *SamlProvider) {
+	for _, p := range a.SamlProviders {
+		if p.Name == name && p.Path == path {
+			return true, p
+		}
+	}
+
+	return false, nil
+}
+
+func (a *AccountData) FindOidcProviderByName(name, path string) (bool, *OidcProvider) {
+	for _, p := range a.OidcProviders {
+		if p.Name == name && p.Path == path {
+			return true, p
+		}
+	}
+
+	return false, nil
+}
+
+func (a *AccountData) FindResourcePolicy(service, region, name string) (bool, *ResourcePolicy) {
+	for _, rp := range a.ResourcePolicies {
+		if rp.ServiceName == service && rp.Region == region && rp.Name == name {
+			return true, rp
+		}
+	}
+
+	return false, nil
+}
+
+func (a *AccountData) FindOrganizationalUnitByPath(ouPath string) (bool, *OrganizationalUnit) {
+	for _, ou := range a.OrganizationalUnits {
+		if ou.OuPath() == ouPath {
+			return true, ou
+		}
+	}
+
+	return false, nil
+}
+
+func (a *AccountData) FindServiceControlPolicyByName(name string) (bool, *ServiceControlPolicy) {
+	for _, p := range a.ServiceControlPolicies {
+		if p.Name == name {
+			return true, p
+		}
+	}
+
+	return false, nil
+}
+
+// managesOrganizationalUnits is true when the account data has all the OUs of
+// the organization and where its accounts are, being fetched from the
+// management account or having an organizations/ou directory
+func (a *AccountData) managesOrganizationalUnits() bool {
+	return a.organizationRootId != "" || a.hasOrganizationalUnitsDir || len(a.OrganizationalUnits) > 0
+}
+
+// managesServiceControlPolicies is true when the account data has all the
+// service control policies of the organization, being fetched from the
+// management account or having an organizations/policy directory
+func (a *AccountData) managesServiceControlPolicies() bool {
+	return a.organizationRootId != "" || a.hasServiceControlPoliciesDir || len(a.ServiceControlPolicies) > 0
+}
+
+// organizationTargetId is the id of a policy target or OU parent, being
+// Root, the path of an OU or an account id, or "" if it doesn't exist yet
+func (a *AccountData) organizationTargetId(target string) string {
+	if target == RootTarget || target == "" {
+		return a.organizationRootId
+	}
+	if isAccountId(target) {
+		return target
+	}
+	if found, ou := a.FindOrganizationalUnitByPath(target); found {
+		return ou.id
+	}
+	return ""
+}
+
+// organizationParentId is the id of the OU or root that an account is in
+func (a *AccountData) organizationParentId(accountId string) string {
+	for _, ou := range a.OrganizationalUnits {
+		if containsString(ou.Accounts, accountId) {
+			return ou.id
+		}
+	}
+	return a.organizationRootId
+}
+
+func isAccountId(s string) bool {
+	if len(s) != 12 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func (a *AccountData) FindBucketPolicyByBucketName(name string) (bool, *BucketPolicy) {
 	for _, p := range a.BucketPolicies {
 		if p.BucketName == name {
@@ -0,0 +1,223 @@
+package iamy
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/pkg/errors"
+)
+
+// organizationData is the OU tree and service control policies of an organization
+type organizationData struct {
+	rootId   string
+	units    []*OrganizationalUnit
+	policies []*ServiceControlPolicy
+}
+
+// fetchOrganizationData fetches the OU tree and SCPs of the organization
+// that accountId manages, returning nil if it isn't a management account
+func fetchOrganizationData(c organizationsiface.OrganizationsAPI, accountId string) (*organizationData, error) {
+	org, err := c.DescribeOrganization(&organizations.DescribeOrganizationInput{})
+	if isAwsErrorCode(err, organizations.ErrCodeAWSOrganizationsNotInUseException) || isAwsErrorCode(err, organizations.ErrCodeAccessDeniedException) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Error describing organization")
+	}
+	if aws.StringValue(org.Organization.MasterAccountId) != accountId {
+		return nil, nil
+	}
+
+	roots, err := c.ListRoots(&organizations.ListRootsInput{})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error listing organization roots")
+	}
+	if len(roots.Roots) == 0 {
+		return nil, errors.New("Organization has no root")
+	}
+
+	data := organizationData{
+		rootId: aws.StringValue(roots.Roots[0].Id),
+	}
+	if err := data.fetchOrganizationalUnits(c, data.rootId, "/"); err != nil {
+		return nil, err
+	}
+	if err := data.fetchServiceControlPolicies(c); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// fetchOrganizationalUnits walks the OU tree below parentId
+func (d *organizationData) fetchOrganizationalUnits(c organizationsiface.OrganizationsAPI, parentId, path string) error {
+	children := []*organizations.OrganizationalUnit{}
+	err := c.ListOrganizationalUnitsForParentPages(
+		&organizations.ListOrganizationalUnitsForParentInput{ParentId: aws.String(parentId)},
+		func(resp *organizations.ListOrganizationalUnitsForParentOutput, lastPage bool) bool {
+			children = append(children, resp.OrganizationalUnits...)
+			return true
+		},
+	)
+	if err != nil {
+		return errors.Wrapf(err, "Error listing organizational units in %s", parentId)
+	}
+
+	for _, child := range children {
+		ou := OrganizationalUnit{
+			organizationsService: organizationsService{
+				Name: aws.StringValue(child.Name),
+				Path: path,
+			},
+			id: aws.StringValue(child.Id),
+		}
+
+		err := c.ListAccountsForParentPages(
+			&organizations.ListAccountsForParentInput{ParentId: child.Id},
+			func(resp *organizations.ListAccountsForParentOutput, lastPage bool) bool {
+				for _, account := range resp.Accounts {
+					ou.Accounts = append(ou.Accounts, aws.StringValue(account.Id))
+				}
+				return true
+			},
+		)
+		if err != nil {
+			return errors.Wrapf(err, "Error listing accounts in %s", ou.OuPath())
+		}
+		sort.Strings(ou.Accounts)
+
+		d.units = append(d.units, &ou)
+		if err := d.fetchOrganizationalUnits(c, ou.id, path+ou.Name+"/"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetchServiceControlPolicies fetches the customer managed SCPs and their targets
+func (d *organizationData) fetchServiceControlPolicies(c organizationsiface.OrganizationsAPI) error {
+	summaries := []*organizations.PolicySummary{}
+	err := c.ListPoliciesPages(
+		&organizations.ListPoliciesInput{Filter: aws.String(organizations.PolicyTypeServiceControlPolicy)},
+		func(resp *organizations.ListPoliciesOutput, lastPage bool) bool {
+			summaries = append(summaries, resp.Policies...)
+			return true
+		},
+	)
+	if err != nil {
+		return errors.Wrap(err, "Error listing service control policies")
+	}
+
+	for _, summary := range summaries {
+		if aws.BoolValue(summary.AwsManaged) {
+			log.Printf("Skipping AWS managed service control policy %s", aws.StringValue(summary.Name))
+			continue
+		}
+
+		resp, err := c.DescribePolicy(&organizations.DescribePolicyInput{PolicyId: summary.Id})
+		if err != nil {
+			return errors.Wrapf(err, "Error describing service control policy %s", aws.StringValue(summary.Name))
+		}
+
+		p := ServiceControlPolicy{
+			organizationsService: organizationsService{
+				Name: aws.StringValue(summary.Name),
+				Path: "/",
+			},
+			id:          aws.StringValue(summary.Id),
+			Description: aws.StringValue(summary.Description),
+		}
+		if p.Policy, err = NewPolicyDocumentFromJson(aws.StringValue(resp.Policy.Content)); err != nil {
+			return errors.Wrap(err, "Error creating Policy document")
+		}
+
+		err = c.ListTargetsForPolicyPages(
+			&organizations.ListTargetsForPolicyInput{PolicyId: summary.Id},
+			func(resp *organizations.ListTargetsForPolicyOutput, lastPage bool) bool {
+				for _, t := range resp.Targets {
+					p.Targets = append(p.Targets, d.targetName(t))
+				}
+				return true
+			},
+		)
+		if err != nil {
+			return errors.Wrapf(err, "Error listing targets of service control policy %s", p.Name)
+		}
+		sort.Strings(p.Targets)
+
+		d.policies = append(d.policies, &p)
+	}
+
+	return nil
+}
+
+// targetName names a policy target the way it's written in YAML
+func (d *organizationData) targetName(t *organizations.PolicyTargetSummary) string {
+	switch aws.StringValue(t.Type) {
+	case organizations.TargetTypeRoot:
+		return RootTarget
+	case organizations.TargetTypeOrganizationalUnit:
+		for _, ou := range d.units {
+			if ou.id == aws.StringValue(t.TargetId) {
+				return ou.OuPath()
+			}
+		}
+	}
+	return aws.StringValue(t.TargetId)
+}
+
+// OrganizationAccounts lists the active accounts in the organization of the
+// default session's account. The management account uses the default session,
+// and member accounts assume roleName
+func OrganizationAccounts(roleName string) ([]AccountConfig, error) {
+	return organizationAccounts(organizations.New(awsSession()), roleName)
+}
+
+func organizationAccounts(c organizationsiface.OrganizationsAPI, roleName string) ([]AccountConfig, error) {
+	org, err := c.DescribeOrganization(&organizations.DescribeOrganizationInput{})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error describing organization")
+	}
+	managementId := aws.StringValue(org.Organization.MasterAccountId)
+
+	accounts := []AccountConfig{}
+	err = c.ListAccountsPages(&organizations.ListAccountsInput{},
+		func(resp *organizations.ListAccountsOutput, lastPage bool) bool {
+			for _, a := range resp.Accounts {
+				if aws.StringValue(a.Status) != organizations.AccountStatusActive {
+					log.Printf("Skipping %s account %s", aws.StringValue(a.Status), aws.StringValue(a.Id))
+					continue
+				}
+
+				account := AccountConfig{Id: aws.StringValue(a.Id)}
+				if account.Id != managementId {
+					account.RoleArn = fmt.Sprintf("arn:%s:iam::%s:role/%s", arnPartition(aws.StringValue(a.Arn)), account.Id, roleName)
+				}
+				accounts = append(accounts, account)
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error listing organization accounts")
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Id < accounts[j].Id
+	})
+
+	return accounts, nil
+}
+
+// arnPartition is the partition of an arn, eg aws or aws-us-gov
+func arnPartition(arn string) string {
+	if parts := strings.SplitN(arn, ":", 3); len(parts) == 3 && parts[1] != "" {
+		return parts[1]
+	}
+	return "aws"
+}
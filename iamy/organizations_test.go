@@ -0,0 +1,58 @@
+package iamy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+)
+
+type organizationAccountsStub struct {
+	organizationsiface.OrganizationsAPI
+	managementId string
+	accounts     []*organizations.Account
+}
+
+func (s organizationAccountsStub) DescribeOrganization(*organizations.DescribeOrganizationInput) (*organizations.DescribeOrganizationOutput, error) {
+	return &organizations.DescribeOrganizationOutput{
+		Organization: &organizations.Organization{MasterAccountId: aws.String(s.managementId)},
+	}, nil
+}
+
+func (s organizationAccountsStub) ListAccountsPages(_ *organizations.ListAccountsInput, fn func(*organizations.ListAccountsOutput, bool) bool) error {
+	fn(&organizations.ListAccountsOutput{Accounts: s.accounts}, true)
+	return nil
+}
+
+func TestOrganizationAccountsAssumeRoleInMemberAccounts(t *testing.T) {
+	account := func(id, status string) *organizations.Account {
+		return &organizations.Account{
+			Id:     aws.String(id),
+			Arn:    aws.String("arn:aws-us-gov:organizations::111111111111:account/o-example/" + id),
+			Status: aws.String(status),
+		}
+	}
+	stub := organizationAccountsStub{
+		managementId: "111111111111",
+		accounts: []*organizations.Account{
+			account("333333333333", organizations.AccountStatusActive),
+			account("111111111111", organizations.AccountStatusActive),
+			account("222222222222", organizations.AccountStatusSuspended),
+		},
+	}
+
+	accounts, err := organizationAccounts(stub, "OrganizationAccountAccessRole")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []AccountConfig{
+		{Id: "111111111111"},
+		{Id: "333333333333", RoleArn: "arn:aws-us-gov:iam::333333333333:role/OrganizationAccountAccessRole"},
+	}
+	if !reflect.DeepEqual(accounts, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, accounts)
+	}
+}
@@ -0,0 +1,135 @@
+package iamy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Action is the kind of change a Step makes
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	ActionAttach Action = "attach"
+	ActionDetach Action = "detach"
+	ActionTag    Action = "tag"
+	ActionUntag  Action = "untag"
+)
+
+// Destructiveness classifies what a Step can take away
+type Destructiveness string
+
+const (
+	// NotDestructive steps only add or change things
+	NotDestructive Destructiveness = "none"
+	// Revokes steps remove an association, such as a policy attachment or
+	// group membership, but leave the entities themselves in place
+	Revokes Destructiveness = "revokes"
+	// Deletes steps remove an entity, credential or policy document
+	Deletes Destructiveness = "deletes"
+)
+
+func (a Action) destructiveness() Destructiveness {
+	switch a {
+	case ActionDelete:
+		return Deletes
+	case ActionDetach, ActionUntag:
+		return Revokes
+	}
+	return NotDestructive
+}
+
+// A Step is a single change to an AWS resource.
+//
+// When Target is set, the Action applies to Target within the resource,
+// eg attaching the policy ARN Target to a role, otherwise it applies to
+// the resource itself
+type Step struct {
+	ID              int             `json:"id"`
+	Action          Action          `json:"action"`
+	ResourceType    string          `json:"resourceType"`
+	ResourceName    string          `json:"resourceName"`
+	ResourcePath    string          `json:"resourcePath,omitempty"`
+	Target          string          `json:"target,omitempty"`
+	Before          interface{}     `json:"before,omitempty"`
+	After           interface{}     `json:"after,omitempty"`
+	Destructiveness Destructiveness `json:"destructiveness"`
+	Cmd             Cmd             `json:"command"`
+	// DependsOn are the IDs of the steps that must run before this one
+	DependsOn []int `json:"dependsOn,omitempty"`
+}
+
+// IsDestructive indicates if the step takes anything away
+func (s *Step) IsDestructive() bool {
+	return s.Destructiveness != NotDestructive
+}
+
+func (s *Step) String() string {
+	return s.Cmd.String()
+}
+
+// PolicyDiff describes how the step changes a policy document, or is nil
+// if the step doesn't change a policy document
+func (s *Step) PolicyDiff() *PolicyDiff {
+	before, ok := asPolicyDocument(s.Before)
+	if !ok {
+		return nil
+	}
+	after, ok := asPolicyDocument(s.After)
+	if !ok {
+		return nil
+	}
+	return DiffPolicyDocuments(before, after)
+}
+
+// ResourceTypeOf is the type of r as it appears in the yaml directory structure,
+// eg iam/user or s3
+func ResourceTypeOf(r AwsResource) string {
+	return strings.TrimSuffix(r.Service()+"/"+r.ResourceType(), "/")
+}
+
+// A Plan is the ordered list of Steps that syncs one AccountData to another.
+// Warnings describe differences that the Steps can't resolve
+type Plan struct {
+	Steps    []*Step  `json:"steps"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (p *Plan) add(s *Step) {
+	s.ID = len(p.Steps) + 1
+	s.Destructiveness = s.Action.destructiveness()
+	p.Steps = append(p.Steps, s)
+}
+
+func (p *Plan) warn(format string, args ...interface{}) {
+	p.Warnings = append(p.Warnings, fmt.Sprintf(format, args...))
+}
+
+// CmdList renders the plan as aws cli commands
+func (p *Plan) CmdList() CmdList {
+	cmds := CmdList{}
+	for _, s := range p.Steps {
+		cmds = append(cmds, s.Cmd)
+	}
+	return cmds
+}
+
+func (p *Plan) String() string {
+	return p.CmdList().String()
+}
+
+func (p *Plan) Count() int {
+	return len(p.Steps)
+}
+
+func (p *Plan) CountDestructive() int {
+	count := 0
+	for _, s := range p.Steps {
+		if s.IsDestructive() {
+			count++
+		}
+	}
+	return count
+}
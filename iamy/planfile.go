@@ -0,0 +1,199 @@
+package iamy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// PlanFileVersion is the version of the plan file format
+const PlanFileVersion = 1
+
+// A PlanFile is a Plan saved to be applied later, along with the
+// account it was generated for and a fingerprint of the account data
+// the plan was generated from. Regions are the regions resource policies
+// were fetched from, and CredentialUsers and SSHPublicKeyUsers the users whose
+// credentials and ssh public keys were fetched, to fetch the same account
+// data when applying
+type PlanFile struct {
+	Version           int      `json:"version"`
+	Account           *Account `json:"account"`
+	Regions           []string `json:"regions,omitempty"`
+	CredentialUsers   []string `json:"credentialUsers"`
+	SSHPublicKeyUsers []string `json:"sshPublicKeyUsers"`
+	Fingerprint       string   `json:"fingerprint"`
+	Plan              *Plan    `json:"plan"`
+}
+
+// NewPlanFile creates a PlanFile for a plan generated against the AWS account data
+func NewPlanFile(plan *Plan, awsData *AccountData) (*PlanFile, error) {
+	fingerprint, err := awsData.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PlanFile{
+		Version:           PlanFileVersion,
+		Account:           awsData.Account,
+		Regions:           awsData.fetchedRegions,
+		CredentialUsers:   awsData.credentialUsers(),
+		SSHPublicKeyUsers: awsData.sshPublicKeyUsers(),
+		Fingerprint:       fingerprint,
+		Plan:              plan,
+	}, nil
+}
+
+// CredentialsNeeded is the AwsFetcher CredentialsNeeded that fetches the
+// same credentials as when the plan was generated
+func (pf *PlanFile) CredentialsNeeded() func(account *Account, u *User) bool {
+	if pf.CredentialUsers == nil {
+		// saved before credentials were only fetched when needed
+		return nil
+	}
+	return func(account *Account, u *User) bool {
+		return containsString(pf.CredentialUsers, u.Name)
+	}
+}
+
+// SSHPublicKeysNeeded is the AwsFetcher SSHPublicKeysNeeded that fetches the
+// same ssh public keys as when the plan was generated
+func (pf *PlanFile) SSHPublicKeysNeeded() func(account *Account, u *User) bool {
+	if pf.SSHPublicKeyUsers == nil {
+		// saved before ssh public keys were only fetched when needed
+		return nil
+	}
+	return func(account *Account, u *User) bool {
+		return containsString(pf.SSHPublicKeyUsers, u.Name)
+	}
+}
+
+// sshPublicKeyUsers are the names of the users whose ssh public keys were fetched
+func (a *AccountData) sshPublicKeyUsers() []string {
+	names := []string{}
+	for _, u := range a.Users {
+		if !u.credentials.SSHPublicKeysSkipped {
+			names = append(names, u.Name)
+		}
+	}
+	return names
+}
+
+// credentialUsers are the names of the users whose credentials were fetched
+func (a *AccountData) credentialUsers() []string {
+	names := []string{}
+	for _, u := range a.Users {
+		if !u.credentials.SSHPublicKeysOnly {
+			names = append(names, u.Name)
+		}
+	}
+	return names
+}
+
+// ReadPlanFile reads a PlanFile from path
+func ReadPlanFile(path string) (*PlanFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pf PlanFile
+	if err = json.Unmarshal(b, &pf); err != nil {
+		return nil, errors.Wrapf(err, "Error reading plan file %s", path)
+	}
+	if pf.Version != PlanFileVersion {
+		return nil, fmt.Errorf("Unsupported plan file version %d in %s", pf.Version, path)
+	}
+	if pf.Plan == nil {
+		return nil, fmt.Errorf("No plan in %s", path)
+	}
+	if err = pf.Plan.checkOrder(); err != nil {
+		return nil, errors.Wrapf(err, "Error reading plan file %s", path)
+	}
+	for _, s := range pf.Plan.Steps {
+		if err = s.Cmd.validate(); err != nil {
+			return nil, errors.Wrapf(err, "Error reading step %d of plan file %s", s.ID, path)
+		}
+	}
+
+	return &pf, nil
+}
+
+// Write writes the PlanFile to path
+func (pf *PlanFile) Write(path string) error {
+	b, err := json.MarshalIndent(pf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0666)
+}
+
+// Validate checks that the plan can be applied to the AWS account data
+func (pf *PlanFile) Validate(awsData *AccountData) error {
+	if pf.Account.Id != awsData.Account.Id {
+		return fmt.Errorf("Plan is for AWS Account ID %s, but the current account is %s", pf.Account.Id, awsData.Account.Id)
+	}
+
+	if pf.IsPending() {
+		return pf.validatePending(awsData)
+	}
+
+	fingerprint, err := awsData.Fingerprint()
+	if err != nil {
+		return err
+	}
+	if fingerprint != pf.Fingerprint {
+		return errors.New("AWS account has changed since the plan was generated")
+	}
+
+	return nil
+}
+
+// remoteStateful is implemented by resources with state in AWS
+// that isn't written to yaml
+type remoteStateful interface {
+	remoteState() interface{}
+}
+
+// Fingerprint is a hash of the account data, including the state of each
+// resource that isn't written to yaml, such as policy version ids
+func (a *AccountData) Fingerprint() (string, error) {
+	type entry struct {
+		path        string
+		resource    []byte
+		remoteState []byte
+	}
+
+	entries := []entry{}
+	for _, r := range a.Resources() {
+		var err error
+		e := entry{path: ResourceFile(a.Account, r)}
+
+		if e.resource, err = json.Marshal(r); err != nil {
+			return "", err
+		}
+		if s, ok := r.(remoteStateful); ok {
+			if e.remoteState, err = json.Marshal(s.remoteState()); err != nil {
+				return "", err
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+
+	h := sha256.New()
+	for _, e := range entries {
+		fmt.Fprintf(h, "%s\n%s\n%s\n", e.path, e.resource, e.remoteState)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
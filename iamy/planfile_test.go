@@ -0,0 +1,175 @@
+package iamy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func TestPlanFileRoundTrip(t *testing.T) {
+	localData := loadDataFrom("testcase1-local")
+	remoteData := loadDataFrom("testcase1-remote")
+	plan := planForSync(t, remoteData, localData)
+
+	pf, err := NewPlanFile(plan, remoteData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(newTmpDir(), "plan.json")
+	defer os.Remove(path)
+	if err = pf.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	pf2, err := ReadPlanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf2.Plan.String() != plan.String() {
+		t.Errorf("Expected plan:\n%s\nActual:\n%s", plan, pf2.Plan)
+	}
+	if err = pf2.Validate(remoteData); err != nil {
+		t.Errorf("Expected plan to be valid, got %s", err)
+	}
+}
+
+func TestPlanFileStepsMustBeInOrder(t *testing.T) {
+	cmd, err := newCmd("iam", "delete-user", "--user-name", "billy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		steps    []*Step
+		expected string
+	}{
+		{"in order", []*Step{{ID: 1}, {ID: 2, DependsOn: []int{1}}}, ""},
+		{"renumbered", []*Step{{ID: 2}, {ID: 1}}, "Step 2 is out of order"},
+		{"depends on a later step", []*Step{{ID: 1, DependsOn: []int{2}}, {ID: 2}}, "Step 1 depends on step 2, which runs after it"},
+		{"depends on itself", []*Step{{ID: 1, DependsOn: []int{1}}}, "Step 1 depends on step 1, which runs after it"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, s := range tt.steps {
+				s.Cmd = cmd
+			}
+			path := filepath.Join(t.TempDir(), "plan.json")
+			pf := PlanFile{Version: PlanFileVersion, Account: &Account{Id: "123"}, Plan: &Plan{Steps: tt.steps}}
+			if err := pf.Write(path); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := ReadPlanFile(path)
+			if tt.expected == "" && err != nil {
+				t.Errorf("Expected the plan file to be read, got %s", err)
+			}
+			if tt.expected != "" && (err == nil || !strings.HasSuffix(err.Error(), tt.expected)) {
+				t.Errorf("Expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestPlanFileWithAnEditedInputIsRefused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	edited := `{"version":1,"account":{"id":"123"},"plan":{"steps":[{"id":1,"command":{"name":"aws","args":["iam","delete-user"],"service":"iam","operation":"DeleteUser","input":{"UserName":"billy","Colour":"blue"}}}]}}`
+	if err := ioutil.WriteFile(path, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ReadPlanFile(path)
+	if err == nil || !strings.Contains(err.Error(), `unknown field "Colour"`) {
+		t.Errorf("Expected the unknown field to be refused, got %v", err)
+	}
+}
+
+func TestPlanFileValidateDetectsDrift(t *testing.T) {
+	remoteData := loadDataFrom("testcase1-remote")
+	pf, err := NewPlanFile(&Plan{}, remoteData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteData.Policies[0].numberOfVersions = 2
+	if err = pf.Validate(remoteData); err == nil {
+		t.Error("Expected a change in policy versions to invalidate the plan")
+	}
+
+	otherAccount := loadDataFrom("testcase1-remote")
+	otherAccount.Account = &Account{Id: "456"}
+	if err = pf.Validate(otherAccount); err == nil {
+		t.Error("Expected a different account to invalidate the plan")
+	}
+}
+
+func TestPlanFileFetchesTheSameRegions(t *testing.T) {
+	remoteData := loadDataFrom("testcase1-remote")
+	remoteData.fetchedRegions = []string{"ap-southeast-2", "us-east-1"}
+	remoteData.ResourcePolicies = []*ResourcePolicy{{Region: "us-east-1"}}
+
+	pf, err := NewPlanFile(&Plan{}, remoteData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(pf.Regions, remoteData.fetchedRegions) {
+		t.Errorf("Expected regions %v, got %v", remoteData.fetchedRegions, pf.Regions)
+	}
+}
+
+func TestFingerprintIgnoresOrder(t *testing.T) {
+	a := loadDataFrom("testcase1-remote")
+	b := loadDataFrom("testcase1-remote")
+	b.BucketPolicies = append(b.BucketPolicies, &BucketPolicy{BucketName: "a"}, &BucketPolicy{BucketName: "b"})
+	a.BucketPolicies = append(a.BucketPolicies, &BucketPolicy{BucketName: "b"}, &BucketPolicy{BucketName: "a"})
+
+	fa, _ := a.Fingerprint()
+	fb, _ := b.Fingerprint()
+	if fa != fb {
+		t.Error("Expected fingerprint to be independent of resource order")
+	}
+}
+
+func TestPlanFileFetchesTheSameCredentials(t *testing.T) {
+	_, c := newRoundTripBackend(t)
+	if _, err := c.IAM.CreateUser(&iam.CreateUserInput{UserName: aws.String("bob")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := c.IAM.UploadSSHPublicKey(&iam.UploadSSHPublicKeyInput{UserName: aws.String(name), SSHPublicKeyBody: aws.String("ssh-ed25519 AAAA" + name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []AccountData{{
+		Account: &Account{Id: "123456789012"},
+		Users: []*User{
+			{iamService: iamService{Name: "alice", Path: "/staff/"}, SSHPublicKeys: &[]string{}},
+			{iamService: iamService{Name: "bob", Path: "/"}},
+		},
+	}}
+	f := AwsFetcher{Clients: c, CredentialsNeeded: CredentialsNeededFor(expected), SSHPublicKeysNeeded: SSHPublicKeysNeededFor(expected)}
+	data, err := f.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := NewPlanFile(&Plan{}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f = AwsFetcher{Clients: c, CredentialsNeeded: pf.CredentialsNeeded(), SSHPublicKeysNeeded: pf.SSHPublicKeysNeeded()}
+	refetched, err := f.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pf.Validate(refetched); err != nil {
+		t.Errorf("Expected the plan to be valid against the same credentials, got %s", err)
+	}
+}
@@ -0,0 +1,290 @@
+package iamy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The steps of a plan are ordered by a dependency graph between the resources
+// they create, change and delete. A step that uses a resource runs after the
+// step creating it, and a step that stops using a resource runs before the step
+// deleting it. Where a resource is deleted and created again under the same
+// name, eg when its path changes, the delete runs first.
+//
+// The edges are derived from each step:
+//   - a policy is created before it's attached, and detached before it's deleted
+//   - a group is created before users are added, and emptied before it's deleted
+//   - a role is created before it's added to an instance profile, and removed
+//     from instance profiles before it's deleted
+//   - an identity provider is created before the roles that trust it, and
+//     deleted after them
+//   - a resource is created before it's changed, and the parts of it are
+//     removed before it's deleted
+//
+// Steps without an edge between them keep the order they were planned in.
+// Steps that depend on each other can't be ordered, so there's no plan.
+
+// trustedProviderRegex matches the identity providers in a trust policy
+var trustedProviderRegex = regexp.MustCompile(`^arn:[^:]+:iam::[0-9]+:(saml-provider|oidc-provider)(/.+)$`)
+
+// resourceKey identifies a resource in the dependency graph. IAM names are
+// unique whatever the path, so a resource moved to a new path has the same key
+func resourceKey(resourceType, path, name string) string {
+	switch resourceType {
+	case "iam/saml-provider", "iam/oidc-provider":
+		// identity providers are named by their path too
+		return resourceType + ":" + path + name
+	}
+	return resourceType + ":" + name
+}
+
+func stepResourceKey(s *Step) string {
+	return resourceKey(s.ResourceType, s.ResourcePath, s.ResourceName)
+}
+
+// policyKey is the key of the customer managed policy arn,
+// or "" if it's an AWS managed policy
+func policyKey(arn string) string {
+	if !strings.HasPrefix(arn, "arn:") || !strings.Contains(arn, ":policy/") || strings.Contains(arn, ":iam::aws:policy/") {
+		return ""
+	}
+	return resourceKey("iam/policy", "", arn[strings.LastIndex(arn, "/")+1:])
+}
+
+func isResourceCreate(s *Step) bool {
+	return s.Action == ActionCreate && s.Target == ""
+}
+
+func isResourceDelete(s *Step) bool {
+	return s.Action == ActionDelete && s.Target == ""
+}
+
+// stepDependencies are the resources a step needs to exist, and the
+// resources it stops using so that they can be deleted after it
+type stepDependencies struct {
+	requires []string
+	releases []string
+}
+
+func (d *stepDependencies) add(keys *[]string, key string) {
+	if key != "" && !containsString(*keys, key) {
+		*keys = append(*keys, key)
+	}
+}
+
+// uses adds a resource the step needs if it adds to the account,
+// or stops using if it takes away from the account
+func (d *stepDependencies) uses(s *Step, key string) {
+	if s.IsDestructive() {
+		d.add(&d.releases, key)
+	} else {
+		d.add(&d.requires, key)
+	}
+}
+
+// dependencies finds the resources used by a step from its input,
+// and the resources referenced before and after it
+func (a *awsSyncCmdGenerator) dependencies(s *Step) stepDependencies {
+	d := stepDependencies{}
+	own := stepResourceKey(s)
+
+	if !isResourceCreate(s) && !isResourceDelete(s) {
+		d.uses(s, own)
+	}
+
+	if arn := s.Cmd.inputString("PolicyArn"); arn != "" && s.ResourceType != "iam/policy" {
+		d.uses(s, policyKey(arn))
+	}
+	if arn := s.Cmd.inputString("PermissionsBoundary"); arn != "" {
+		d.add(&d.requires, policyKey(arn))
+	}
+	if name := s.Cmd.inputString("GroupName"); name != "" && s.ResourceType != "iam/group" {
+		d.uses(s, resourceKey("iam/group", "", name))
+	}
+	if name := s.Cmd.inputString("RoleName"); name != "" && s.ResourceType == "iam/instance-profile" {
+		d.uses(s, resourceKey("iam/role", "", name))
+	}
+
+	// a permissions boundary being replaced or removed
+	if arn, ok := s.Before.(string); ok {
+		d.add(&d.releases, policyKey(arn))
+	}
+
+	if isResourceDelete(s) {
+		switch r := s.Before.(type) {
+		case *User:
+			d.add(&d.releases, policyKey(a.to.Account.policyArnFromString(r.PermissionsBoundary)))
+		case *Role:
+			d.add(&d.releases, policyKey(a.to.Account.policyArnFromString(r.PermissionsBoundary)))
+		}
+	}
+
+	if s.ResourceType == "iam/role" {
+		for _, key := range trustedProviders(s.Before) {
+			d.add(&d.releases, key)
+		}
+		for _, key := range trustedProviders(s.After) {
+			d.add(&d.requires, key)
+		}
+	}
+
+	return d
+}
+
+// trustedProviders are the keys of the identity providers
+// trusted by a role or trust policy
+func trustedProviders(v interface{}) []string {
+	var doc *PolicyDocument
+	switch v := v.(type) {
+	case *Role:
+		doc = v.AssumeRolePolicyDocument
+	case *PolicyDocument:
+		doc = v
+	}
+	if doc == nil {
+		return nil
+	}
+
+	keys := []string{}
+	walkPolicyStrings(doc.data, func(s string) {
+		if m := trustedProviderRegex.FindStringSubmatch(s); m != nil {
+			keys = append(keys, "iam/"+m[1]+":"+m[2])
+		}
+	})
+	return keys
+}
+
+// walkPolicyStrings calls f with each string in the policy data
+func walkPolicyStrings(i interface{}, f func(string)) {
+	switch v := i.(type) {
+	case string:
+		f(v)
+	case []string:
+		for _, s := range v {
+			f(s)
+		}
+	case []interface{}:
+		for _, e := range v {
+			walkPolicyStrings(e, f)
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			walkPolicyStrings(e, f)
+		}
+	}
+}
+
+// orderSteps orders the plan's steps by the dependency graph, returning an
+// error for steps that depend on each other and so can't be ordered
+func (a *awsSyncCmdGenerator) orderSteps() error {
+	steps := a.plan.Steps
+	creates, deletes := map[string]int{}, map[string]int{}
+	for i, s := range steps {
+		if isResourceCreate(s) {
+			creates[stepResourceKey(s)] = i
+		} else if isResourceDelete(s) {
+			deletes[stepResourceKey(s)] = i
+		}
+	}
+
+	// after[i] are the steps that must run before step i
+	after := make([]map[int]bool, len(steps))
+	for i := range steps {
+		after[i] = map[int]bool{}
+	}
+	edge := func(from, to int) {
+		if from != to {
+			after[to][from] = true
+		}
+	}
+	for i, s := range steps {
+		if d, ok := deletes[stepResourceKey(s)]; ok && isResourceCreate(s) {
+			edge(d, i)
+		}
+		deps := a.dependencies(s)
+		for _, key := range deps.requires {
+			if c, ok := creates[key]; ok {
+				edge(c, i)
+			}
+		}
+		for _, key := range deps.releases {
+			if d, ok := deletes[key]; ok {
+				edge(i, d)
+			}
+		}
+	}
+
+	ordered := make([]int, 0, len(steps))
+	done := make([]bool, len(steps))
+	for len(ordered) < len(steps) {
+		next := -1
+		for i := range steps {
+			if !done[i] && allDone(after[i], done) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			break
+		}
+		done[next] = true
+		ordered = append(ordered, next)
+	}
+
+	if len(ordered) < len(steps) {
+		cycle := []string{}
+		for i, s := range steps {
+			if !done[i] {
+				cycle = append(cycle, fmt.Sprintf("%s %s %s", s.Action, s.ResourceType, s.ResourceName))
+			}
+		}
+		return errors.Errorf("Steps that depend on each other can't be ordered: %s", strings.Join(cycle, ", "))
+	}
+
+	// renumber the steps in their new order
+	ids := make([]int, len(steps))
+	a.plan.Steps = make([]*Step, len(steps))
+	for n, i := range ordered {
+		ids[i] = n + 1
+		a.plan.Steps[n] = steps[i]
+		steps[i].ID = n + 1
+	}
+	for _, i := range ordered {
+		steps[i].DependsOn = nil
+		for j := range after[i] {
+			steps[i].DependsOn = append(steps[i].DependsOn, ids[j])
+		}
+		sort.Ints(steps[i].DependsOn)
+	}
+
+	return nil
+}
+
+// checkOrder checks each step depends only on the steps before it, as a
+// plan that's been edited may not be in an order it can run in
+func (p *Plan) checkOrder() error {
+	for i, s := range p.Steps {
+		if s.ID != i+1 {
+			return errors.Errorf("Step %d is out of order", s.ID)
+		}
+		for _, id := range s.DependsOn {
+			if id >= s.ID {
+				return errors.Errorf("Step %d depends on step %d, which runs after it", s.ID, id)
+			}
+		}
+	}
+	return nil
+}
+
+func allDone(steps map[int]bool, done []bool) bool {
+	for i := range steps {
+		if !done[i] {
+			return false
+		}
+	}
+	return true
+}
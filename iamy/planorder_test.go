@@ -0,0 +1,165 @@
+package iamy
+
+import (
+	"strings"
+	"testing"
+)
+
+func newOrderTestRole(t *testing.T, name, path string) *Role {
+	doc, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"ec2.amazonaws.com"},"Action":"sts:AssumeRole"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Role{iamService: iamService{Name: name, Path: path}, AssumeRolePolicyDocument: doc}
+}
+
+func newOrderTestPolicy(t *testing.T, name, path string) *Policy {
+	doc, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Policy{iamService: iamService{Name: name, Path: path}, Policy: doc}
+}
+
+// verifyConverges checks the plan from from to to applies cleanly to a
+// simulated account, returning the operations of the plan in order
+func verifyConverges(t *testing.T, from, to *AccountData) []string {
+	t.Helper()
+
+	v, err := Verify(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range v.Failures {
+		t.Errorf("Step %d (%s) failed: %s", f.Step.ID, f.Step, f.Err)
+	}
+	if v.Unconverged.Count() != 0 {
+		t.Errorf("Expected no changes after applying the plan, got:\n%s", v.Unconverged)
+	}
+
+	operations := []string{}
+	for _, s := range v.Plan.Steps {
+		operations = append(operations, strings.Join(s.Cmd.Args[1:], " "))
+	}
+	return operations
+}
+
+func indexOf(t *testing.T, operations []string, operation string) int {
+	t.Helper()
+	for i, o := range operations {
+		if o == operation {
+			return i
+		}
+	}
+	t.Fatalf("Expected %q in %v", operation, operations)
+	return -1
+}
+
+func TestGroupRenamedWithUsersMovingToIt(t *testing.T) {
+	from := &AccountData{
+		Account: &Account{Id: "123456789012"},
+		Groups:  []*Group{{iamService: iamService{Name: "old", Path: "/"}}},
+		Users:   []*User{{iamService: iamService{Name: "alice", Path: "/"}, Groups: []string{"old"}}},
+	}
+	to := &AccountData{
+		Account: &Account{Id: "123456789012"},
+		Groups:  []*Group{{iamService: iamService{Name: "new", Path: "/"}}},
+		Users:   []*User{{iamService: iamService{Name: "alice", Path: "/"}, Groups: []string{"new"}}},
+	}
+
+	ops := verifyConverges(t, from, to)
+	if indexOf(t, ops, "create-group --group-name new --path /") > indexOf(t, ops, "add-user-to-group --user-name alice --group-name new") {
+		t.Errorf("Expected the group to be created before alice is added, got %v", ops)
+	}
+	if indexOf(t, ops, "remove-user-from-group --user-name alice --group-name old") > indexOf(t, ops, "delete-group --group-name old") {
+		t.Errorf("Expected alice to be removed before the group is deleted, got %v", ops)
+	}
+}
+
+func TestGroupMovedToNewPathKeepsItsUsers(t *testing.T) {
+	from := &AccountData{
+		Account: &Account{Id: "123456789012"},
+		Groups:  []*Group{{iamService: iamService{Name: "developers", Path: "/"}}},
+		Users:   []*User{{iamService: iamService{Name: "alice", Path: "/"}, Groups: []string{"developers"}}},
+	}
+	to := &AccountData{
+		Account: &Account{Id: "123456789012"},
+		Groups:  []*Group{{iamService: iamService{Name: "developers", Path: "/engineering/"}}},
+		Users:   []*User{{iamService: iamService{Name: "alice", Path: "/"}, Groups: []string{"developers"}}},
+	}
+
+	ops := verifyConverges(t, from, to)
+	expected := []string{
+		"remove-user-from-group --user-name alice --group-name developers",
+		"delete-group --group-name developers",
+		"create-group --group-name developers --path /engineering/",
+		"add-user-to-group --user-name alice --group-name developers",
+	}
+	if strings.Join(ops, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(ops, "\n"))
+	}
+}
+
+func TestRoleMovedToNewInstanceProfileWhileOldIsDeleted(t *testing.T) {
+	from := &AccountData{
+		Account:          &Account{Id: "123456789012"},
+		Roles:            []*Role{newOrderTestRole(t, "worker", "/")},
+		InstanceProfiles: []*InstanceProfile{{iamService: iamService{Name: "old", Path: "/"}, Roles: []string{"worker"}}},
+	}
+	to := &AccountData{
+		Account:          &Account{Id: "123456789012"},
+		Roles:            []*Role{newOrderTestRole(t, "worker", "/apps/")},
+		InstanceProfiles: []*InstanceProfile{{iamService: iamService{Name: "new", Path: "/"}, Roles: []string{"worker"}}},
+	}
+
+	ops := verifyConverges(t, from, to)
+	if indexOf(t, ops, "remove-role-from-instance-profile --instance-profile-name old --role-name worker") > indexOf(t, ops, "delete-role --role-name worker") {
+		t.Errorf("Expected the role to be removed from the old instance profile before it's deleted, got %v", ops)
+	}
+	if indexOf(t, ops, "delete-role --role-name worker") > indexOf(t, ops, "add-role-to-instance-profile --instance-profile-name new --role-name worker") {
+		t.Errorf("Expected the role to be created again before it's added to the new instance profile, got %v", ops)
+	}
+}
+
+func TestPolicyMovedToNewPathIsDetachedBeforeDeleting(t *testing.T) {
+	from := &AccountData{
+		Account:  &Account{Id: "123456789012"},
+		Policies: []*Policy{newOrderTestPolicy(t, "read", "/")},
+		Groups:   []*Group{{iamService: iamService{Name: "developers", Path: "/"}, Policies: []string{"read"}}},
+	}
+	to := &AccountData{
+		Account:  &Account{Id: "123456789012"},
+		Policies: []*Policy{newOrderTestPolicy(t, "read", "/shared/")},
+		Groups:   []*Group{{iamService: iamService{Name: "developers", Path: "/"}, Policies: []string{"shared/read"}}},
+	}
+
+	ops := verifyConverges(t, from, to)
+	detach := indexOf(t, ops, "detach-group-policy --group-name developers --policy-arn arn:aws:iam::123456789012:policy/read")
+	del := indexOf(t, ops, "delete-policy --policy-arn arn:aws:iam::123456789012:policy/read")
+	attach := indexOf(t, ops, "attach-group-policy --group-name developers --policy-arn arn:aws:iam::123456789012:policy/shared/read")
+	create := -1
+	for i, o := range ops {
+		if strings.HasPrefix(o, "create-policy --policy-name read --path /shared/") {
+			create = i
+		}
+	}
+	if !(detach < del && del < create && create < attach) {
+		t.Errorf("Expected detach, delete, create then attach, got %v", ops)
+	}
+}
+
+func TestStepsThatDependOnEachOtherCantBePlanned(t *testing.T) {
+	a := awsSyncCmdGenerator{from: &AccountData{Account: &Account{Id: "123"}}, to: &AccountData{Account: &Account{Id: "123"}}}
+	group := &Group{iamService: iamService{Name: "a", Path: "/"}}
+	policy := &Policy{iamService: iamService{Name: "b", Path: "/"}}
+
+	// each create uses what the other creates
+	a.add(ActionCreate, group, "", nil, nil, "iam", "attach-group-policy", "--group-name", "a", "--policy-arn", "arn:aws:iam::123:policy/b")
+	a.add(ActionCreate, policy, "", nil, nil, "iam", "put-group-policy", "--group-name", "a", "--policy-name", "b")
+	err := a.orderSteps()
+
+	expected := "Steps that depend on each other can't be ordered: create iam/group a, create iam/policy b"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+}
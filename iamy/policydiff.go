@@ -0,0 +1,329 @@
+package iamy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// statementFieldOrder is the order fields of a statement are diffed in,
+// other fields are diffed afterwards alphabetically
+var statementFieldOrder = []string{
+	"Sid", "Effect",
+	"Principal", "NotPrincipal",
+	"Action", "NotAction",
+	"Resource", "NotResource",
+	"Condition",
+}
+
+// A FieldChange describes a change to a field of a policy document or statement.
+//
+// Values are flattened to strings, eg a Principal of {"AWS": ["arn1", "arn2"]}
+// becomes "AWS: arn1" and "AWS: arn2", so that changes to lists and maps are
+// described by the entries added and removed
+type FieldChange struct {
+	Field   string   `json:"field"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// A StatementChange describes a statement that was added, removed or modified
+type StatementChange struct {
+	Change    string        `json:"change"`
+	Sid       string        `json:"sid,omitempty"`
+	Index     int           `json:"index"`
+	Statement interface{}   `json:"statement,omitempty"`
+	Fields    []FieldChange `json:"fields,omitempty"`
+}
+
+const (
+	StatementAdded    = "added"
+	StatementRemoved  = "removed"
+	StatementModified = "modified"
+)
+
+// A PolicyDiff describes the changes between two policy documents
+type PolicyDiff struct {
+	Fields     []FieldChange     `json:"fields,omitempty"`
+	Statements []StatementChange `json:"statements,omitempty"`
+}
+
+// IsEmpty indicates the policy documents are equivalent
+func (d *PolicyDiff) IsEmpty() bool {
+	return len(d.Fields) == 0 && len(d.Statements) == 0
+}
+
+// DiffPolicyDocuments compares two policy documents statement by statement.
+//
+// Statements are matched first if they are identical, then if they have the
+// same Sid, and then in order if they have no Sid
+func DiffPolicyDocuments(before, after *PolicyDocument) *PolicyDiff {
+	diff := &PolicyDiff{}
+	beforeDoc, afterDoc := policyDocumentMap(before), policyDocumentMap(after)
+
+	for _, k := range sortedFields(beforeDoc, afterDoc, nil) {
+		if k == "Statement" {
+			continue
+		}
+		if c := diffField(k, beforeDoc[k], afterDoc[k]); c != nil {
+			diff.Fields = append(diff.Fields, *c)
+		}
+	}
+
+	beforeStatements := statementList(beforeDoc["Statement"])
+	afterStatements := statementList(afterDoc["Statement"])
+	matches := make([]int, len(afterStatements))
+	beforeMatched := make([]bool, len(beforeStatements))
+	for i := range matches {
+		matches[i] = -1
+	}
+
+	match := func(isMatch func(b, a map[string]interface{}) bool) {
+		for i, a := range afterStatements {
+			if matches[i] != -1 {
+				continue
+			}
+			for j, b := range beforeStatements {
+				if !beforeMatched[j] && isMatch(b, a) {
+					matches[i] = j
+					beforeMatched[j] = true
+					break
+				}
+			}
+		}
+	}
+	match(func(b, a map[string]interface{}) bool {
+		return reflect.DeepEqual(b, a)
+	})
+	match(func(b, a map[string]interface{}) bool {
+		return sid(b) != "" && sid(b) == sid(a)
+	})
+	match(func(b, a map[string]interface{}) bool {
+		return sid(b) == "" && sid(a) == ""
+	})
+
+	for j, b := range beforeStatements {
+		if !beforeMatched[j] {
+			diff.Statements = append(diff.Statements, StatementChange{
+				Change:    StatementRemoved,
+				Sid:       sid(b),
+				Index:     j,
+				Statement: b,
+			})
+		}
+	}
+
+	for i, a := range afterStatements {
+		if matches[i] == -1 {
+			diff.Statements = append(diff.Statements, StatementChange{
+				Change:    StatementAdded,
+				Sid:       sid(a),
+				Index:     i,
+				Statement: a,
+			})
+			continue
+		}
+
+		b := beforeStatements[matches[i]]
+		var fields []FieldChange
+		for _, k := range sortedFields(b, a, statementFieldOrder) {
+			if c := diffField(k, b[k], a[k]); c != nil {
+				fields = append(fields, *c)
+			}
+		}
+		if len(fields) > 0 {
+			diff.Statements = append(diff.Statements, StatementChange{
+				Change: StatementModified,
+				Sid:    sid(a),
+				Index:  i,
+				Fields: fields,
+			})
+		}
+	}
+
+	return diff
+}
+
+// String renders the diff for people to read
+func (d *PolicyDiff) String() string {
+	lines := []string{}
+	addFieldLines := func(indent string, fields []FieldChange) {
+		for _, f := range fields {
+			lines = append(lines, indent+f.Field+":")
+			for _, v := range f.Removed {
+				lines = append(lines, indent+"  - "+v)
+			}
+			for _, v := range f.Added {
+				lines = append(lines, indent+"  + "+v)
+			}
+		}
+	}
+
+	addFieldLines("", d.Fields)
+
+	for _, s := range d.Statements {
+		name := fmt.Sprintf("Statement %d", s.Index+1)
+		if s.Sid != "" {
+			name = fmt.Sprintf("Statement %q", s.Sid)
+		}
+
+		switch s.Change {
+		case StatementAdded:
+			lines = append(lines, "+ "+name+" "+compactJson(s.Statement))
+		case StatementRemoved:
+			lines = append(lines, "- "+name+" "+compactJson(s.Statement))
+		case StatementModified:
+			lines = append(lines, "~ "+name)
+			addFieldLines("    ", s.Fields)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func diffField(field string, before, after interface{}) *FieldChange {
+	if reflect.DeepEqual(before, after) {
+		return nil
+	}
+
+	b, a := flattenPolicyValue(before, ""), flattenPolicyValue(after, "")
+	c := FieldChange{
+		Field:   field,
+		Removed: stringSetDifference(b, a),
+		Added:   stringSetDifference(a, b),
+	}
+	if len(c.Added) == 0 && len(c.Removed) == 0 {
+		return nil
+	}
+	if len(c.Added) == 0 {
+		c.Added = nil
+	}
+	if len(c.Removed) == 0 {
+		c.Removed = nil
+	}
+
+	return &c
+}
+
+// flattenPolicyValue flattens a policy value to a sorted list of strings,
+// prefixing values in maps with their keys
+func flattenPolicyValue(v interface{}, prefix string) []string {
+	ss := []string{}
+
+	switch vv := v.(type) {
+	case nil:
+	case string:
+		ss = append(ss, prefix+vv)
+	case []interface{}:
+		for _, i := range vv {
+			ss = append(ss, flattenPolicyValue(i, prefix)...)
+		}
+	case []string:
+		for _, i := range vv {
+			ss = append(ss, prefix+i)
+		}
+	case map[string]interface{}:
+		for _, k := range sortedFields(vv, nil, nil) {
+			ss = append(ss, flattenPolicyValue(vv[k], prefix+k+": ")...)
+		}
+	default:
+		ss = append(ss, prefix+compactJson(vv))
+	}
+
+	sort.Strings(ss)
+	return ss
+}
+
+// sortedFields is the union of the keys of a and b, with the keys
+// in order first and the rest sorted alphabetically
+func sortedFields(a, b map[string]interface{}, order []string) []string {
+	keys := []string{}
+	for _, k := range order {
+		_, inA := a[k]
+		_, inB := b[k]
+		if inA || inB {
+			keys = append(keys, k)
+		}
+	}
+
+	rest := []string{}
+	for _, m := range []map[string]interface{}{a, b} {
+		for k := range m {
+			if !containsString(order, k) && !containsString(rest, k) {
+				rest = append(rest, k)
+			}
+		}
+	}
+	sort.Strings(rest)
+
+	return append(keys, rest...)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func policyDocumentMap(p *PolicyDocument) map[string]interface{} {
+	if p == nil {
+		return map[string]interface{}{}
+	}
+	if m, ok := p.data.(map[string]interface{}); ok {
+		return m
+	}
+	return map[string]interface{}{}
+}
+
+// statementList returns the statements of a policy, which
+// can be either a single statement or a list
+func statementList(v interface{}) []map[string]interface{} {
+	ss := []map[string]interface{}{}
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		ss = append(ss, vv)
+	case []interface{}:
+		for _, s := range vv {
+			if m, ok := s.(map[string]interface{}); ok {
+				ss = append(ss, m)
+			}
+		}
+	}
+	return ss
+}
+
+func sid(statement map[string]interface{}) string {
+	if s, ok := statement["Sid"].(string); ok {
+		return s
+	}
+	return ""
+}
+
+func compactJson(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// asPolicyDocument converts v to a PolicyDocument, v being either a
+// PolicyDocument or its decoded json, as found in a plan read from a file
+func asPolicyDocument(v interface{}) (*PolicyDocument, bool) {
+	switch vv := v.(type) {
+	case *PolicyDocument:
+		return vv, vv != nil
+	case map[string]interface{}:
+		if _, ok := vv["Statement"]; !ok {
+			return nil, false
+		}
+		doc, err := NewPolicyDocumentFromJson(compactJson(vv))
+		return doc, err == nil
+	}
+	return nil, false
+}
@@ -0,0 +1,68 @@
+package iamy
+
+import (
+	"testing"
+)
+
+func mustNewPolicyDocument(t *testing.T, jsonString string) *PolicyDocument {
+	doc, err := NewPolicyDocumentFromJson(jsonString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestDiffPolicyDocuments(t *testing.T) {
+	before := mustNewPolicyDocument(t, `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Sid": "Read", "Effect": "Allow", "Action": ["s3:GetObject", "s3:ListBucket"], "Resource": "*"},
+			{"Effect": "Deny", "Action": "s3:DeleteBucket", "Resource": "*"},
+			{"Sid": "Old", "Effect": "Allow", "Action": "sqs:*", "Resource": "*"}
+		]
+	}`)
+	after := mustNewPolicyDocument(t, `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Effect": "Deny", "Action": "s3:DeleteBucket", "Resource": "*"},
+			{"Sid": "Read", "Effect": "Allow", "Action": ["s3:GetObject", "s3:GetObjectVersion"], "Resource": "*",
+				"Principal": {"AWS": "arn:aws:iam::123:root"}},
+			{"Sid": "New", "Effect": "Allow", "Action": "sns:Publish", "Resource": "*"}
+		]
+	}`)
+
+	expected := `- Statement "Old" {"Action":"sqs:*","Effect":"Allow","Resource":"*","Sid":"Old"}
+~ Statement "Read"
+    Principal:
+      + AWS: arn:aws:iam::123:root
+    Action:
+      - s3:ListBucket
+      + s3:GetObjectVersion
++ Statement "New" {"Action":"sns:Publish","Effect":"Allow","Resource":"*","Sid":"New"}`
+
+	actual := DiffPolicyDocuments(before, after).String()
+	if actual != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestDiffPolicyDocumentsWithoutChanges(t *testing.T) {
+	before := mustNewPolicyDocument(t, `{"Statement": [{"Effect": "Allow", "Action": ["b", "a"], "Resource": "*"}]}`)
+	after := mustNewPolicyDocument(t, `{"Statement": {"Effect": "Allow", "Action": ["a", "b"], "Resource": "*"}}`)
+
+	if diff := DiffPolicyDocuments(before, after); !diff.IsEmpty() {
+		t.Errorf("Expected no changes, got:\n%s", diff)
+	}
+}
+
+func TestStepPolicyDiffFromDecodedPlan(t *testing.T) {
+	step := Step{
+		Before: map[string]interface{}{"Statement": map[string]interface{}{"Effect": "Allow", "Action": "a", "Resource": "*"}},
+		After:  mustNewPolicyDocument(t, `{"Statement": {"Effect": "Deny", "Action": "a", "Resource": "*"}}`),
+	}
+
+	diff := step.PolicyDiff()
+	if diff == nil || len(diff.Statements) != 1 || diff.Statements[0].Change != StatementModified {
+		t.Errorf("Expected a modified statement, got %#v", diff)
+	}
+}
@@ -0,0 +1,451 @@
+package iamy
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+)
+
+// regionalClients creates and caches the clients of services with
+// resource policies, for each region
+type regionalClients struct {
+	sess    *session.Session
+	clients map[string]interface{}
+	mutex   *sync.Mutex
+
+	// injected are clients used for a service in every region
+	injected map[string]interface{}
+}
+
+func newRegionalClients(s *session.Session) *regionalClients {
+	return &regionalClients{
+		sess:    s,
+		clients: map[string]interface{}{},
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// get returns the client for service in region, named as the aws cli names it
+func (rc *regionalClients) get(service, region string) (interface{}, error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if c, ok := rc.injected[service]; ok {
+		return c, nil
+	}
+
+	key := service + "/" + region
+	if c, ok := rc.clients[key]; ok {
+		return c, nil
+	}
+
+	config := aws.NewConfig().WithRegion(region)
+	var c interface{}
+	switch service {
+	case "sqs":
+		c = sqs.New(rc.sess, config)
+	case "sns":
+		c = sns.New(rc.sess, config)
+	case "kms":
+		c = kms.New(rc.sess, config)
+	case "ecr":
+		c = ecr.New(rc.sess, config)
+	case "lambda":
+		c = lambda.New(rc.sess, config)
+	case "secretsmanager":
+		c = secretsmanager.New(rc.sess, config)
+	case "s3control":
+		c = s3control.New(rc.sess, config)
+	case "organizations":
+		c = organizations.New(rc.sess, config)
+	default:
+		return nil, errors.Errorf("unsupported service %s", service)
+	}
+
+	rc.clients[key] = c
+	return c, nil
+}
+
+// resourcePolicyFetchers fetch the resource policies of a service in a region
+var resourcePolicyFetchers = map[string]func(client interface{}, region string) ([]*ResourcePolicy, error){
+	"sqs":            fetchQueuePolicies,
+	"sns":            fetchTopicPolicies,
+	"kms":            fetchKeyPolicies,
+	"ecr":            fetchRepositoryPolicies,
+	"lambda":         fetchFunctionPolicies,
+	"secretsmanager": fetchSecretPolicies,
+}
+
+// fetchResourcePolicies fetches the resource policies of every service in each region
+func fetchResourcePolicies(clients *regionalClients, regions []string) ([]*ResourcePolicy, error) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var fetchErr error
+	policies := []*ResourcePolicy{}
+
+	for _, region := range regions {
+		for _, service := range ResourcePolicyServices {
+			client, err := clients.get(service, region)
+			if err != nil {
+				return nil, err
+			}
+
+			wg.Add(1)
+			go func(service, region string) {
+				defer wg.Done()
+				log.Printf("Fetching %s resource policies in %s", service, region)
+
+				pp, err := resourcePolicyFetchers[service](client, region)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				if err != nil {
+					fetchErr = errors.Wrapf(err, "Error fetching %s resource policies in %s", service, region)
+					return
+				}
+				policies = append(policies, pp...)
+			}(service, region)
+		}
+	}
+	wg.Wait()
+
+	return policies, fetchErr
+}
+
+func newResourcePolicy(service, region, name, policyJson string) (*ResourcePolicy, error) {
+	doc, err := NewPolicyDocumentFromJson(policyJson)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing the policy of %s %s", service, name)
+	}
+
+	return &ResourcePolicy{
+		ServiceName: service,
+		Region:      region,
+		Name:        name,
+		Policy:      doc,
+	}, nil
+}
+
+func isAwsErrorCode(err error, code string) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == code
+}
+
+// queueUrl is the url of a queue, at the sqs endpoint of its region's partition
+func queueUrl(region, accountId, name string) (string, error) {
+	endpoint, err := endpoints.DefaultResolver().EndpointFor(sqs.EndpointsID, region)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error resolving the sqs endpoint in %s", region)
+	}
+	return fmt.Sprintf("%s/%s/%s", endpoint.URL, accountId, name), nil
+}
+
+// regionPartition is the arn partition of a region, eg aws or aws-cn
+func regionPartition(region string) string {
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return p.ID()
+	}
+	return "aws"
+}
+
+func fetchQueuePolicies(client interface{}, region string) ([]*ResourcePolicy, error) {
+	c := client.(*sqs.SQS)
+	pp := []*ResourcePolicy{}
+
+	var fetchErr error
+	err := c.ListQueuesPages(&sqs.ListQueuesInput{}, func(resp *sqs.ListQueuesOutput, lastPage bool) bool {
+		for _, url := range resp.QueueUrls {
+			attrs, err := c.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+				QueueUrl:       url,
+				AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNamePolicy}),
+			})
+			if isAwsErrorCode(err, sqs.ErrCodeQueueDoesNotExist) {
+				continue
+			}
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+
+			policy, ok := attrs.Attributes[sqs.QueueAttributeNamePolicy]
+			if !ok || policy == nil {
+				continue
+			}
+
+			name := (*url)[strings.LastIndex(*url, "/")+1:]
+			rp, err := newResourcePolicy("sqs", region, name, *policy)
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+			pp = append(pp, rp)
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	return pp, err
+}
+
+// fetchTopicPolicies fetches the policies of SNS topics. Every topic has a policy
+func fetchTopicPolicies(client interface{}, region string) ([]*ResourcePolicy, error) {
+	c := client.(*sns.SNS)
+	pp := []*ResourcePolicy{}
+
+	var fetchErr error
+	err := c.ListTopicsPages(&sns.ListTopicsInput{}, func(resp *sns.ListTopicsOutput, lastPage bool) bool {
+		for _, t := range resp.Topics {
+			attrs, err := c.GetTopicAttributes(&sns.GetTopicAttributesInput{TopicArn: t.TopicArn})
+			if isAwsErrorCode(err, sns.ErrCodeNotFoundException) {
+				continue
+			}
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+
+			policy, ok := attrs.Attributes["Policy"]
+			if !ok || policy == nil {
+				continue
+			}
+
+			name := (*t.TopicArn)[strings.LastIndex(*t.TopicArn, ":")+1:]
+			rp, err := newResourcePolicy("sns", region, name, *policy)
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+			pp = append(pp, rp)
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	return pp, err
+}
+
+// fetchKeyPolicies fetches the policies of customer managed KMS keys, named by key id.
+// Every key has a policy
+func fetchKeyPolicies(client interface{}, region string) ([]*ResourcePolicy, error) {
+	c := client.(*kms.KMS)
+	pp := []*ResourcePolicy{}
+
+	var fetchErr error
+	err := c.ListKeysPages(&kms.ListKeysInput{}, func(resp *kms.ListKeysOutput, lastPage bool) bool {
+		for _, k := range resp.Keys {
+			keyResp, err := c.DescribeKey(&kms.DescribeKeyInput{KeyId: k.KeyId})
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+			meta := keyResp.KeyMetadata
+			if aws.StringValue(meta.KeyManager) != kms.KeyManagerTypeCustomer ||
+				aws.StringValue(meta.KeyState) == kms.KeyStatePendingDeletion {
+				continue
+			}
+
+			policyResp, err := c.GetKeyPolicy(&kms.GetKeyPolicyInput{
+				KeyId:      k.KeyId,
+				PolicyName: aws.String("default"),
+			})
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+
+			rp, err := newResourcePolicy("kms", region, *k.KeyId, *policyResp.Policy)
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+			pp = append(pp, rp)
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	return pp, err
+}
+
+func fetchRepositoryPolicies(client interface{}, region string) ([]*ResourcePolicy, error) {
+	c := client.(*ecr.ECR)
+	pp := []*ResourcePolicy{}
+
+	var fetchErr error
+	err := c.DescribeRepositoriesPages(&ecr.DescribeRepositoriesInput{}, func(resp *ecr.DescribeRepositoriesOutput, lastPage bool) bool {
+		for _, r := range resp.Repositories {
+			policyResp, err := c.GetRepositoryPolicy(&ecr.GetRepositoryPolicyInput{RepositoryName: r.RepositoryName})
+			if isAwsErrorCode(err, ecr.ErrCodeRepositoryPolicyNotFoundException) {
+				continue
+			}
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+
+			rp, err := newResourcePolicy("ecr", region, *r.RepositoryName, *policyResp.PolicyText)
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+			pp = append(pp, rp)
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	return pp, err
+}
+
+func fetchFunctionPolicies(client interface{}, region string) ([]*ResourcePolicy, error) {
+	c := client.(*lambda.Lambda)
+	pp := []*ResourcePolicy{}
+
+	var fetchErr error
+	err := c.ListFunctionsPages(&lambda.ListFunctionsInput{}, func(resp *lambda.ListFunctionsOutput, lastPage bool) bool {
+		for _, f := range resp.Functions {
+			policyResp, err := c.GetPolicy(&lambda.GetPolicyInput{FunctionName: f.FunctionName})
+			if isAwsErrorCode(err, lambda.ErrCodeResourceNotFoundException) {
+				continue
+			}
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+
+			rp, err := newResourcePolicy("lambda", region, *f.FunctionName, *policyResp.Policy)
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+			pp = append(pp, rp)
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	return pp, err
+}
+
+func fetchSecretPolicies(client interface{}, region string) ([]*ResourcePolicy, error) {
+	c := client.(*secretsmanager.SecretsManager)
+	pp := []*ResourcePolicy{}
+
+	var fetchErr error
+	err := c.ListSecretsPages(&secretsmanager.ListSecretsInput{}, func(resp *secretsmanager.ListSecretsOutput, lastPage bool) bool {
+		for _, s := range resp.SecretList {
+			policyResp, err := c.GetResourcePolicy(&secretsmanager.GetResourcePolicyInput{SecretId: s.ARN})
+			if isAwsErrorCode(err, secretsmanager.ErrCodeResourceNotFoundException) {
+				continue
+			}
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+			if policyResp.ResourcePolicy == nil {
+				continue
+			}
+
+			rp, err := newResourcePolicy("secretsmanager", region, *s.Name, *policyResp.ResourcePolicy)
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+			pp = append(pp, rp)
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	return pp, err
+}
+
+// lambdaPermissionConditions are the conditions of a lambda permission
+// statement that add-permission has options for
+var lambdaPermissionConditions = map[string]string{
+	"ArnLike/AWS:SourceArn":                   "--source-arn",
+	"StringEquals/AWS:SourceAccount":          "--source-account",
+	"StringEquals/aws:PrincipalOrgID":         "--principal-org-id",
+	"StringEquals/lambda:EventSourceToken":    "--event-source-token",
+	"StringEquals/lambda:FunctionUrlAuthType": "--function-url-auth-type",
+}
+
+// lambdaPermissionArgs converts a statement of a lambda function policy to
+// the add-permission options that create it. Lambda policies can only be
+// changed a statement at a time, so statements add-permission can't
+// create are an error
+func lambdaPermissionArgs(statement map[string]interface{}) ([]interface{}, error) {
+	sid, _ := statement["Sid"].(string)
+	if sid == "" {
+		return nil, errors.New("the statement has no Sid")
+	}
+	if effect, _ := statement["Effect"].(string); effect != "Allow" {
+		return nil, errors.Errorf("statement %s isn't an Allow statement", sid)
+	}
+
+	action, ok := statement["Action"].(string)
+	if !ok {
+		return nil, errors.Errorf("statement %s must have a single Action", sid)
+	}
+
+	var principal string
+	switch p := statement["Principal"].(type) {
+	case string:
+		principal = p
+	case map[string]interface{}:
+		for _, v := range p {
+			s, ok := v.(string)
+			if !ok || len(p) > 1 {
+				return nil, errors.Errorf("statement %s must have a single Principal", sid)
+			}
+			principal = s
+		}
+	}
+	if principal == "" {
+		return nil, errors.Errorf("statement %s has no Principal", sid)
+	}
+
+	args := []interface{}{"--statement-id", sid, "--action", action, "--principal", principal}
+
+	conditions, _ := statement["Condition"].(map[string]interface{})
+	for _, operator := range sortedFields(conditions, nil, nil) {
+		values, _ := conditions[operator].(map[string]interface{})
+		for _, key := range sortedFields(values, nil, nil) {
+			option, ok := lambdaPermissionConditions[operator+"/"+key]
+			value, isString := values[key].(string)
+			if !ok || !isString {
+				return nil, errors.Errorf("statement %s has the condition %s %s, which add-permission can't create", sid, operator, key)
+			}
+			args = append(args, option, value)
+		}
+	}
+
+	return args, nil
+}
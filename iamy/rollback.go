@@ -0,0 +1,202 @@
+package iamy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RollbackPlan is the plan that undoes a push, syncing the account data after
+// the push back to the account data before it. Deleted policies are created
+// again with the document of their default version, updated policies have
+// their default version restored, detached policies are attached again and
+// assume role policies are restored. Credentials can't be created again, so
+// the access keys of deleted users are listed as a warning
+func RollbackPlan(after, before *AccountData) (*Plan, error) {
+	a := awsSyncCmdGenerator{from: after, to: before, rollback: true}
+	plan, err := a.GeneratePlan()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range before.Users {
+		if found, _ := after.FindUserByName(u.Name, u.Path); found {
+			continue
+		}
+		if keys := u.credentials.AccessKeyIds; len(keys) > 0 {
+			plan.warn("User %s is created again without its access keys: %s", u.Name, strings.Join(keys, ", "))
+		}
+	}
+
+	return plan, nil
+}
+
+// NewRollbackPlanFile creates a PlanFile that undoes a push, from the
+// account data fetched before and after it
+func NewRollbackPlanFile(before, after *AccountData) (*PlanFile, error) {
+	plan, err := RollbackPlan(after, before)
+	if err != nil {
+		return nil, err
+	}
+	pf, err := NewPlanFile(plan, after)
+	if err != nil {
+		return nil, err
+	}
+	pf.Regions = rollbackRegions(before, after)
+
+	return pf, nil
+}
+
+// NewPendingRollbackPlanFile creates a PlanFile that undoes a push which
+// hasn't run yet, from the account data fetched before it and the yaml
+// account data it pushes. Without the account data after the push there's
+// no fingerprint, so the plan is checked by the resources it changes instead.
+// It's complete unless the push creates resources whose ids aren't known
+// until they exist, which only fetching the account after the push can undo
+func NewPendingRollbackPlanFile(before, expected *AccountData) (pf *PlanFile, complete bool, err error) {
+	after, unknown := expectedAfterPush(before, expected)
+	plan, err := RollbackPlan(after, before)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, r := range unknown {
+		plan.warn("%s %s is created by the push, but isn't deleted by this rollback as its id isn't known until it exists", ResourceTypeOf(r), r.ResourceName())
+	}
+
+	return &PlanFile{
+		Version: PlanFileVersion,
+		Account: before.Account,
+		Regions: rollbackRegions(before, after),
+		Plan:    plan,
+	}, len(unknown) == 0, nil
+}
+
+// expectedAfterPush is the account data expected after pushing the yaml
+// account data in expected to the account data fetched in before. What yaml
+// doesn't have is taken from before: the ids of organization resources, the
+// credentials of users, and the resources a push
+// leaves alone without files. Organization resources the push creates are
+// left out and returned as unknown, as their ids aren't known until they exist
+func expectedAfterPush(before, expected *AccountData) (after *AccountData, unknown []AwsResource) {
+	copied := *expected
+	after = &copied
+
+	account := *before.Account
+	if expected.Account.Alias != "" || expected.noAccountAlias {
+		account.Alias = expected.Account.Alias
+	}
+	after.Account = &account
+	after.organizationRootId = before.organizationRootId
+
+	after.Users = []*User{}
+	for _, u := range expected.Users {
+		copied := *u
+		if found, fromUser := before.FindUserByName(u.Name, u.Path); found {
+			copied.credentials = fromUser.credentials
+		}
+		after.Users = append(after.Users, &copied)
+	}
+
+	after.BucketPolicies = []*BucketPolicy{}
+	for _, bp := range expected.BucketPolicies {
+		copied := *bp
+		if found, fromBucketPolicy := before.FindBucketPolicyByBucketName(bp.BucketName); found && bp.PublicAccessBlock == nil {
+			copied.PublicAccessBlock = fromBucketPolicy.PublicAccessBlock
+		}
+		after.BucketPolicies = append(after.BucketPolicies, &copied)
+	}
+	for _, bp := range before.BucketPolicies {
+		if found, _ := expected.FindBucketPolicyByBucketName(bp.BucketName); !found && bp.PublicAccessBlock != nil {
+			after.BucketPolicies = append(after.BucketPolicies, &BucketPolicy{BucketName: bp.BucketName, PublicAccessBlock: bp.PublicAccessBlock})
+		}
+	}
+
+	if expected.PasswordPolicy == nil && !expected.noPasswordPolicy {
+		after.PasswordPolicy = before.PasswordPolicy
+		after.noPasswordPolicy = before.noPasswordPolicy
+	}
+	if expected.PublicAccessBlock == nil {
+		after.PublicAccessBlock = before.PublicAccessBlock
+	}
+
+	after.OrganizationalUnits = before.OrganizationalUnits
+	if expected.managesOrganizationalUnits() {
+		after.OrganizationalUnits = []*OrganizationalUnit{}
+		for _, ou := range expected.OrganizationalUnits {
+			found, fromOu := before.FindOrganizationalUnitByPath(ou.OuPath())
+			if !found {
+				unknown = append(unknown, ou)
+				continue
+			}
+			copied := *ou
+			copied.id = fromOu.id
+			after.OrganizationalUnits = append(after.OrganizationalUnits, &copied)
+		}
+	}
+
+	after.ServiceControlPolicies = before.ServiceControlPolicies
+	if expected.managesServiceControlPolicies() {
+		after.ServiceControlPolicies = []*ServiceControlPolicy{}
+		for _, p := range expected.ServiceControlPolicies {
+			found, fromPolicy := before.FindServiceControlPolicyByName(p.Name)
+			if !found {
+				unknown = append(unknown, p)
+				continue
+			}
+			copied := *p
+			copied.id = fromPolicy.id
+			copied.Targets = []string{}
+			for _, target := range p.Targets {
+				// the push can only attach the policy to targets that exist
+				if before.organizationTargetId(target) != "" {
+					copied.Targets = append(copied.Targets, target)
+				}
+			}
+			after.ServiceControlPolicies = append(after.ServiceControlPolicies, &copied)
+		}
+	}
+
+	return after, unknown
+}
+
+// DeletedPolicies are the policies in awsData that the plan deletes,
+// which a rollback creates again with their descriptions
+func (p *Plan) DeletedPolicies(awsData *AccountData) []*Policy {
+	policies := []*Policy{}
+	for _, s := range p.Steps {
+		if s.ResourceType != "iam/policy" || !isResourceDelete(s) {
+			continue
+		}
+		if found, policy := awsData.FindPolicyByName(s.ResourceName, s.ResourcePath); found {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+// IsPending is true if the plan file is a rollback saved before its push ran
+func (pf *PlanFile) IsPending() bool {
+	return pf.Fingerprint == ""
+}
+
+// validatePending checks that the resources of a pending rollback are in the
+// state its steps expect, as there's no fingerprint to compare
+func (pf *PlanFile) validatePending(awsData *AccountData) error {
+	if err := ValidateRemaining(pf.Plan, awsData); err != nil {
+		return fmt.Errorf("Rollback was saved from the account before its push: %s", err)
+	}
+	return nil
+}
+
+// rollbackRegions are the regions of resource policies before or after a
+// push, so that applying the rollback fetches the same resource policies
+func rollbackRegions(before, after *AccountData) []string {
+	regions := before.Regions()
+	for _, r := range after.Regions() {
+		if !containsString(regions, r) {
+			regions = append(regions, r)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
@@ -0,0 +1,288 @@
+package iamy
+
+import (
+	"strings"
+	"testing"
+)
+
+func rollbackPlan(t *testing.T, after, before *AccountData) *Plan {
+	t.Helper()
+	plan, err := RollbackPlan(after, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func pendingRollbackPlanFile(t *testing.T, before, expected *AccountData) *PlanFile {
+	t.Helper()
+	pf, _, err := NewPendingRollbackPlanFile(before, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pf
+}
+
+func newRollbackTestData(t *testing.T) (from, to *AccountData) {
+	trust, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"lambda.amazonaws.com"},"Action":"sts:AssumeRole"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := newOrderTestRole(t, "worker", "/")
+	worker.Policies = []string{"read"}
+
+	read := newOrderTestPolicy(t, "read", "/")
+	read.Description = "Reads objects"
+
+	from = &AccountData{
+		Account:  &Account{Id: "123456789012"},
+		Policies: []*Policy{read},
+		Groups:   []*Group{{iamService: iamService{Name: "developers", Path: "/"}, Policies: []string{"read"}}},
+		Users:    []*User{{iamService: iamService{Name: "alice", Path: "/"}, Groups: []string{"developers"}}},
+		Roles:    []*Role{worker},
+	}
+
+	// delete the policy, detaching it, delete alice and trust another service
+	changed := newOrderTestRole(t, "worker", "/")
+	changed.AssumeRolePolicyDocument = trust
+	to = &AccountData{
+		Account: &Account{Id: "123456789012"},
+		Groups:  []*Group{{iamService: iamService{Name: "developers", Path: "/"}}},
+		Roles:   []*Role{changed},
+	}
+
+	return from, to
+}
+
+// pushInSimulation applies the plan from before to to in the simulation,
+// returning the account data fetched after it
+func pushInSimulation(t *testing.T, sim *simulation, before, to *AccountData) *AccountData {
+	t.Helper()
+	for _, s := range planForSync(t, before, to).Steps {
+		if err := sim.executor.Exec(s.Cmd); err != nil {
+			t.Fatalf("Step %d (%s) failed: %s", s.ID, s, err)
+		}
+	}
+	after, err := sim.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return after
+}
+
+func TestRollbackPlanUndoesPush(t *testing.T) {
+	from, to := newRollbackTestData(t)
+	sim, err := newSimulation(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := sim.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := pushInSimulation(t, sim, before, to)
+
+	pf, err := NewRollbackPlanFile(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pf.Validate(after); err != nil {
+		t.Fatal(err)
+	}
+	rolledBack := pushInSimulation(t, sim, after, before)
+
+	if plan := planForSync(t, rolledBack, before); plan.Count() != 0 {
+		t.Errorf("Expected the rollback to restore the account, got:\n%s", plan)
+	}
+	if !strings.Contains(pf.Plan.String(), "create-policy --policy-name read") {
+		t.Errorf("Expected the deleted policy to be created again, got:\n%s", pf.Plan)
+	}
+}
+
+func TestPendingRollbackPlanUndoesPush(t *testing.T) {
+	from, to := newRollbackTestData(t)
+	sim, err := newSimulation(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := sim.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as when pushing, the descriptions are only fetched for deleted policies
+	fetcher := AwsFetcher{SkipFetchingPolicyDescriptions: true, Clients: sim.clients}
+	if err := fetcher.FetchPolicyDescriptions(planForSync(t, before, to).DeletedPolicies(before)); err != nil {
+		t.Fatal(err)
+	}
+
+	pf, complete, err := NewPendingRollbackPlanFile(before, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pf.IsPending() || !complete {
+		t.Fatal("Expected the rollback to be pending and complete")
+	}
+	if err := pf.Validate(before); err == nil {
+		t.Error("Expected the rollback not to apply before the push")
+	}
+
+	after := pushInSimulation(t, sim, before, to)
+	if err := pf.Validate(after); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range pf.Plan.Steps {
+		if err := sim.executor.Exec(s.Cmd); err != nil {
+			t.Fatalf("Step %d (%s) failed: %s", s.ID, s, err)
+		}
+	}
+	rolledBack, err := (&AwsFetcher{Clients: sim.clients}).Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan := planForSync(t, rolledBack, before); plan.Count() != 0 {
+		t.Errorf("Expected the rollback to restore the account, got:\n%s", plan)
+	}
+	if _, p := rolledBack.FindPolicyByName("read", "/"); p == nil || p.Description != "Reads objects" {
+		t.Errorf("Expected the deleted policy to be created again with its description, got %+v", p)
+	}
+}
+
+func TestPendingRollbackPlanRestoresThePolicyVersion(t *testing.T) {
+	withAction := func(action string) *AccountData {
+		p := newOrderTestPolicy(t, "read", "/")
+		doc, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"` + action + `","Resource":"*"}]}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Policy = doc
+		return &AccountData{Account: &Account{Id: "123456789012"}, Policies: []*Policy{p}}
+	}
+	sim, err := newSimulation(withAction("s3:GetObject"), withAction("s3:GetObject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := sim.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fill the policy's versions, so the push deletes the oldest
+	for _, action := range []string{"s3:ListBucket", "s3:PutObject", "s3:DeleteObject", "s3:GetObjectAcl"} {
+		current = pushInSimulation(t, sim, current, withAction(action))
+	}
+	before := current
+	to := withAction("s3:*")
+
+	pf := pendingRollbackPlanFile(t, before, to)
+	after := pushInSimulation(t, sim, before, to)
+	if err := pf.Validate(after); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range pf.Plan.Steps {
+		if err := sim.executor.Exec(s.Cmd); err != nil {
+			t.Fatalf("Step %d (%s) failed: %s", s.ID, s, err)
+		}
+	}
+	rolledBack, err := sim.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "aws iam set-default-policy-version --policy-arn arn:aws:iam::123456789012:policy/read --version-id v5"
+	if actual := pf.Plan.String(); actual != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+	if plan := planForSync(t, rolledBack, before); plan.Count() != 0 {
+		t.Errorf("Expected the rollback to restore the policy, got:\n%s", plan)
+	}
+}
+
+func TestPendingRollbackPlanUsesIdsFetchedBeforePush(t *testing.T) {
+	doc, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Action":"s3:*","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Action":"ec2:*","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := &AccountData{
+		Account: &Account{Id: "123456789012"},
+		OrganizationalUnits: []*OrganizationalUnit{
+			{organizationsService: organizationsService{Name: "Prod", Path: "/"}, id: "ou-prod"},
+		},
+		ServiceControlPolicies: []*ServiceControlPolicy{
+			{organizationsService: organizationsService{Name: "deny-s3"}, id: "p-denys3", Policy: doc, Targets: []string{RootTarget}},
+		},
+		organizationRootId: "r-root",
+	}
+	expected := &AccountData{
+		Account: &Account{Id: "123456789012"},
+		OrganizationalUnits: []*OrganizationalUnit{
+			{organizationsService: organizationsService{Name: "Prod", Path: "/"}},
+			{organizationsService: organizationsService{Name: "Dev", Path: "/"}},
+		},
+		ServiceControlPolicies: []*ServiceControlPolicy{
+			{organizationsService: organizationsService{Name: "deny-s3"}, Policy: changed, Targets: []string{"Prod"}},
+		},
+	}
+
+	pf, complete, err := NewPendingRollbackPlanFile(before, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if complete {
+		t.Error("Expected the rollback to be incomplete, as the push creates an OU")
+	}
+	plan := pf.Plan.String()
+	for _, want := range []string{
+		"update-policy --policy-id p-denys3",
+		"attach-policy --policy-id p-denys3 --target-id r-root",
+		"detach-policy --policy-id p-denys3 --target-id ou-prod",
+	} {
+		if !strings.Contains(plan, want) {
+			t.Errorf("Expected the rollback to contain %q, got:\n%s", want, plan)
+		}
+	}
+	if strings.Contains(plan, `""`) {
+		t.Errorf("Expected no empty ids in the rollback, got:\n%s", plan)
+	}
+	if len(pf.Plan.Warnings) != 1 || !strings.Contains(pf.Plan.Warnings[0], "organizations/ou Dev") {
+		t.Errorf("Expected a warning that the created OU isn't deleted, got %v", pf.Plan.Warnings)
+	}
+}
+
+func TestPendingRollbackPlanLeavesAloneWhatPushLeavesAlone(t *testing.T) {
+	from, to := newRollbackTestData(t)
+	before := *from
+	before.Account = &Account{Id: "123456789012", Alias: "myalias"}
+	before.PasswordPolicy = &PasswordPolicy{MinimumPasswordLength: 12}
+	before.PublicAccessBlock = &AccountPublicAccessBlock{PublicAccessBlock{BlockPublicAcls: true}}
+	before.ServiceControlPolicies = []*ServiceControlPolicy{{organizationsService: organizationsService{Name: "deny-s3"}, id: "p-denys3"}}
+	before.organizationRootId = "r-root"
+
+	pf := pendingRollbackPlanFile(t, &before, to)
+	for _, s := range pf.Plan.Steps {
+		if s.ResourceType != "iam/policy" && s.ResourceType != "iam/group" && s.ResourceType != "iam/user" && s.ResourceType != "iam/role" {
+			t.Errorf("Expected only the pushed resources to be rolled back, got %s", s)
+		}
+	}
+}
+
+func TestRollbackPlanWarnsOfDeletedAccessKeys(t *testing.T) {
+	before := &AccountData{
+		Account: &Account{Id: "123456789012"},
+		Users: []*User{{
+			iamService:  iamService{Name: "alice", Path: "/"},
+			credentials: userCredentials{AccessKeyIds: []string{"AKIAEXAMPLE"}},
+		}},
+	}
+	after := &AccountData{Account: &Account{Id: "123456789012"}}
+
+	plan := rollbackPlan(t, after, before)
+	if len(plan.Warnings) != 1 || !strings.Contains(plan.Warnings[0], "AKIAEXAMPLE") {
+		t.Errorf("Expected a warning of alice's access key, got %v", plan.Warnings)
+	}
+}
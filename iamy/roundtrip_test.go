@@ -0,0 +1,154 @@
+package iamy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/99designs/iamy/iamy/memaws"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const roundTripPolicy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`
+const roundTripAssumeRolePolicy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"ec2.amazonaws.com"},"Action":"sts:AssumeRole"}]}`
+
+func newRoundTripBackend(t *testing.T) (*memaws.Backend, Clients) {
+	b := memaws.New("123456789012")
+	c := Clients{
+		IAM:            b.IAM(),
+		S3:             b.S3(),
+		CloudFormation: b.CloudFormation(),
+		S3Control:      b.S3Control(),
+		Organizations:  b.Organizations(),
+		STS:            b.STS(),
+	}
+
+	seed := []error{}
+	add := func(_ interface{}, err error) { seed = append(seed, err) }
+	add(c.IAM.CreateAccountAlias(&iam.CreateAccountAliasInput{AccountAlias: aws.String("example")}))
+	add(c.IAM.CreateGroup(&iam.CreateGroupInput{GroupName: aws.String("Developers")}))
+	add(c.IAM.CreateUser(&iam.CreateUserInput{UserName: aws.String("alice"), Path: aws.String("/staff/")}))
+	add(c.IAM.AddUserToGroup(&iam.AddUserToGroupInput{UserName: aws.String("alice"), GroupName: aws.String("Developers")}))
+	add(c.IAM.CreatePolicy(&iam.CreatePolicyInput{PolicyName: aws.String("ReadObjects"), PolicyDocument: aws.String(roundTripPolicy)}))
+	add(c.IAM.AttachGroupPolicy(&iam.AttachGroupPolicyInput{GroupName: aws.String("Developers"), PolicyArn: aws.String("arn:aws:iam::123456789012:policy/ReadObjects")}))
+	add(c.IAM.CreateRole(&iam.CreateRoleInput{RoleName: aws.String("worker"), AssumeRolePolicyDocument: aws.String(roundTripAssumeRolePolicy)}))
+	add(c.IAM.AttachRolePolicy(&iam.AttachRolePolicyInput{RoleName: aws.String("worker"), PolicyArn: aws.String("arn:aws:iam::123456789012:policy/ReadObjects")}))
+	add(c.IAM.CreateRole(&iam.CreateRoleInput{RoleName: aws.String("stack-role"), AssumeRolePolicyDocument: aws.String(roundTripAssumeRolePolicy)}))
+	add(c.S3.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("example-bucket")}))
+	for _, err := range seed {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.AddStackResource("example-stack", "AWS::IAM::Role", "stack-role")
+
+	return b, c
+}
+
+func fetchRoundTrip(t *testing.T, c Clients) *AccountData {
+	f := AwsFetcher{Clients: c}
+	data, err := f.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func writeRoundTripFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPullEditPushPullConverges(t *testing.T) {
+	b, c := newRoundTripBackend(t)
+
+	pulled := fetchRoundTrip(t, c)
+	y := YamlLoadDumper{Dir: t.TempDir()}
+	if err := y.Dump(pulled, true); err != nil {
+		t.Fatal(err)
+	}
+
+	accountDir := filepath.Join(y.Dir, "example-123456789012")
+	if _, err := os.Stat(filepath.Join(accountDir, "iam", "role", "stack-role.yaml")); !os.IsNotExist(err) {
+		t.Fatal("Expected the role managed by CloudFormation not to be pulled")
+	}
+
+	// add a user to the group, change the managed policy, delete the
+	// role and add a bucket policy
+	writeRoundTripFile(t, filepath.Join(accountDir, "iam", "user", "bob.yaml"), `Groups:
+- Developers
+Tags:
+  team: platform
+`)
+	writeRoundTripFile(t, filepath.Join(accountDir, "iam", "policy", "ReadObjects.yaml"), `Policy:
+  Statement:
+  - Action:
+    - s3:GetObject
+    - s3:ListBucket
+    Effect: Allow
+    Resource: '*'
+  Version: 2012-10-17
+`)
+	if err := os.Remove(filepath.Join(accountDir, "iam", "role", "worker.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	writeRoundTripFile(t, filepath.Join(accountDir, "s3", "example-bucket.yaml"), `Policy:
+  Statement:
+  - Action: s3:GetObject
+    Effect: Allow
+    Principal:
+      AWS: arn:aws:iam::123456789012:root
+    Resource: arn:aws:s3:::example-bucket/*
+  Version: 2012-10-17
+`)
+
+	loaded, err := y.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 {
+		t.Fatalf("Expected 1 account, got %d", len(loaded))
+	}
+
+	plan := planForSync(t, fetchRoundTrip(t, c), &loaded[0])
+	if plan.Count() == 0 {
+		t.Fatal("Expected the edits to need pushing")
+	}
+	executor := NewExecutorWithClients(nil, c)
+	for _, step := range plan.Steps {
+		if err := executor.Exec(step.Cmd); err != nil {
+			t.Fatalf("Step %d failed: %s", step.ID, err)
+		}
+	}
+
+	if plan := planForSync(t, fetchRoundTrip(t, c), &loaded[0]); plan.Count() != 0 {
+		t.Errorf("Expected no changes after pushing, got:\n%s", plan)
+	}
+
+	// pulling again writes the same files
+	repulled := YamlLoadDumper{Dir: t.TempDir()}
+	if err := repulled.Dump(fetchRoundTrip(t, c), true); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := repulled.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := planForSync(t, &reloaded[0], &loaded[0]); plan.Count() != 0 {
+		t.Errorf("Expected pulling again to match the pushed files, got:\n%s", plan)
+	}
+
+	if _, err := c.IAM.GetRole(&iam.GetRoleInput{RoleName: aws.String("stack-role")}); err != nil {
+		t.Errorf("Expected the role managed by CloudFormation to be left alone: %s", err)
+	}
+	if len(b.Calls()) == 0 {
+		t.Error("Expected calls to be recorded")
+	}
+}
@@ -2,6 +2,7 @@ package iamy
 
 import (
 	"fmt"
+	"log"
 	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -9,10 +10,16 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"github.com/aws/aws-sdk-go/service/s3control/s3controliface"
 	"github.com/pkg/errors"
 )
 
 const NoSuchBucketPolicyErrCode = "NoSuchBucketPolicy"
+const NoSuchPublicAccessBlockErrCode = "NoSuchPublicAccessBlockConfiguration"
+
+// accessDeniedErrCode is the error code of S3 and S3 Control without permission
+const accessDeniedErrCode = "AccessDenied"
 
 func newRegionClientMap(s *session.Session) *regionClientMap {
 	return &regionClientMap{
@@ -59,13 +66,18 @@ func newS3Client(s *session.Session) *s3Client {
 }
 
 type bucket struct {
-	name       string
-	policyJson string
-	exists     bool
+	name              string
+	policyJson        string
+	publicAccessBlock *PublicAccessBlock
+	exists            bool
+
+	// publicAccessBlockUnknown is true when the Block Public Access
+	// settings couldn't be fetched for lack of permission
+	publicAccessBlockUnknown bool
 }
 
 func (c *s3Client) withRegion(region string) s3iface.S3API {
-	if region == "" {
+	if region == "" || c.regionClients == nil {
 		return c.S3API
 	}
 
@@ -86,11 +98,68 @@ func (c *s3Client) populateBucket(b *bucket) error {
 	}
 
 	region := s3.NormalizeBucketLocation(normaliseString(r.LocationConstraint))
-	b.policyJson, err = c.GetBucketPolicyDoc(b.name, region)
+	if b.policyJson, err = c.GetBucketPolicyDoc(b.name, region); err != nil {
+		return err
+	}
+	b.publicAccessBlock, err = c.getBucketPublicAccessBlock(b.name, region)
+	if isAccessDenied(err) {
+		log.Printf("Block Public Access settings of bucket %s are unknown: %s", b.name, err)
+		b.publicAccessBlockUnknown = true
+		return nil
+	}
 
 	return err
 }
 
+// isAccessDenied is true if err is S3 or S3 Control denying permission
+func isAccessDenied(err error) bool {
+	return isAwsErrorCode(errors.Cause(err), accessDeniedErrCode)
+}
+
+// getBucketPublicAccessBlock returns the Block Public Access settings of
+// a bucket, or nil if the bucket doesn't have any
+func (c *s3Client) getBucketPublicAccessBlock(name, region string) (*PublicAccessBlock, error) {
+	resp, err := c.withRegion(region).GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{
+		Bucket: aws.String(name),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == NoSuchPublicAccessBlockErrCode {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "GetPublicAccessBlock for %s", name)
+	}
+
+	config := resp.PublicAccessBlockConfiguration
+	return &PublicAccessBlock{
+		BlockPublicAcls:       aws.BoolValue(config.BlockPublicAcls),
+		IgnorePublicAcls:      aws.BoolValue(config.IgnorePublicAcls),
+		BlockPublicPolicy:     aws.BoolValue(config.BlockPublicPolicy),
+		RestrictPublicBuckets: aws.BoolValue(config.RestrictPublicBuckets),
+	}, nil
+}
+
+// getAccountPublicAccessBlock returns the account's Block Public Access
+// settings, or nil if the account doesn't have any
+func getAccountPublicAccessBlock(c s3controliface.S3ControlAPI, accountId string) (*AccountPublicAccessBlock, error) {
+	resp, err := c.GetPublicAccessBlock(&s3control.GetPublicAccessBlockInput{
+		AccountId: aws.String(accountId),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == NoSuchPublicAccessBlockErrCode {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	config := resp.PublicAccessBlockConfiguration
+	return &AccountPublicAccessBlock{PublicAccessBlock{
+		BlockPublicAcls:       aws.BoolValue(config.BlockPublicAcls),
+		IgnorePublicAcls:      aws.BoolValue(config.IgnorePublicAcls),
+		BlockPublicPolicy:     aws.BoolValue(config.BlockPublicPolicy),
+		RestrictPublicBuckets: aws.BoolValue(config.RestrictPublicBuckets),
+	}}, nil
+}
+
 func (c *s3Client) listAllBuckets() ([]*bucket, error) {
 	bucketListResp, err := c.ListBuckets(&s3.ListBucketsInput{})
 	if err != nil {
@@ -98,6 +167,7 @@ func (c *s3Client) listAllBuckets() ([]*bucket, error) {
 	}
 
 	var wg sync.WaitGroup
+	var mu sync.Mutex
 	var oneOfTheErrorsDuringPopulation error
 	buckets := []*bucket{}
 
@@ -110,10 +180,12 @@ func (c *s3Client) listAllBuckets() ([]*bucket, error) {
 			defer wg.Done()
 			err := c.populateBucket(&b)
 			if err != nil {
-				if awsErr, ok := err.(awserr.Error); ok {
-					if awsErr.Code() != s3.ErrCodeNoSuchBucket {
-						oneOfTheErrorsDuringPopulation = errors.New(fmt.Sprintf("Error while getting details for S3 bucket %s: %s", b.name, err))
-					}
+				// only a bucket deleted since it was listed is skipped, any other
+				// error like AccessDenied would leave the bucket out of the plan
+				if awsErr, ok := errors.Cause(err).(awserr.Error); !ok || awsErr.Code() != s3.ErrCodeNoSuchBucket {
+					mu.Lock()
+					oneOfTheErrorsDuringPopulation = errors.New(fmt.Sprintf("Error while getting details for S3 bucket %s: %s", b.name, err))
+					mu.Unlock()
 				}
 			} else {
 				b.exists = true
@@ -148,7 +220,7 @@ func (c *s3Client) GetBucketPolicyDoc(name, region string) (string, error) {
 				return "", nil
 			}
 		}
-		return "", fmt.Errorf("GetBucketPolicyDoc for %s: %s", name, err.Error())
+		return "", errors.Wrapf(err, "GetBucketPolicyDoc for %s", name)
 	}
 
 	return *resp.Policy, nil
@@ -2,22 +2,14 @@ package iamy
 
 import "reflect"
 
-// inlinePolicySetDifference is the set of elements in aa but not in bb
-func inlinePolicySetDifference(aa, bb []InlinePolicy) []InlinePolicy {
-	rr := []InlinePolicy{}
-
-LoopInlinePolicies:
-	for _, a := range aa {
-		for _, b := range bb {
-			if reflect.DeepEqual(a, b) {
-				continue LoopInlinePolicies
-			}
+// findInlinePolicyByName finds the inline policy in ip with the name
+func findInlinePolicyByName(ip []InlinePolicy, name string) (bool, InlinePolicy) {
+	for _, p := range ip {
+		if p.Name == name {
+			return true, p
 		}
-
-		rr = append(rr, a)
 	}
-
-	return rr
+	return false, InlinePolicy{}
 }
 
 // stringSetDifference is the set of elements in aa but not in bb
@@ -0,0 +1,154 @@
+package iamy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotVersion is the version of the snapshot file format
+const SnapshotVersion = 1
+
+// A Snapshot is the account data fetched from AWS, saved so that plans can be
+// generated against it without AWS credentials. Each resource is kept as it's
+// written to yaml, along with its state in AWS that isn't written to yaml
+type Snapshot struct {
+	Version            int                `json:"version"`
+	Account            *Account           `json:"account"`
+	OrganizationRootId string             `json:"organizationRootId,omitempty"`
+	Resources          []SnapshotResource `json:"resources"`
+}
+
+// A SnapshotResource is a resource in a Snapshot, with the file it's written to
+type SnapshotResource struct {
+	File        string          `json:"file"`
+	Resource    json.RawMessage `json:"resource"`
+	RemoteState json.RawMessage `json:"remoteState,omitempty"`
+}
+
+// remoteStateSetter is implemented by resources with state in AWS
+// that isn't written to yaml, to restore it from a Snapshot
+type remoteStateSetter interface {
+	setRemoteState(data []byte) error
+}
+
+// NewSnapshot creates a Snapshot of the account data fetched from AWS
+func NewSnapshot(a *AccountData) (*Snapshot, error) {
+	s := Snapshot{
+		Version:            SnapshotVersion,
+		Account:            a.Account,
+		OrganizationRootId: a.organizationRootId,
+		Resources:          []SnapshotResource{},
+	}
+
+	for _, r := range a.Resources() {
+		sr := SnapshotResource{
+			File: ResourceFile(a.Account, r),
+		}
+
+		var err error
+		if sr.Resource, err = json.Marshal(r); err != nil {
+			return nil, err
+		}
+		if rs, ok := r.(remoteStateful); ok {
+			if sr.RemoteState, err = json.Marshal(rs.remoteState()); err != nil {
+				return nil, err
+			}
+		}
+
+		s.Resources = append(s.Resources, sr)
+	}
+
+	return &s, nil
+}
+
+// ReadSnapshot reads a Snapshot from path
+func ReadSnapshot(path string) (*Snapshot, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Snapshot
+	if err = json.Unmarshal(b, &s); err != nil {
+		return nil, errors.Wrapf(err, "Error reading snapshot %s", path)
+	}
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("Unsupported snapshot version %d in %s", s.Version, path)
+	}
+
+	return &s, nil
+}
+
+// Write writes the Snapshot to path
+func (s *Snapshot) Write(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0666)
+}
+
+// AccountData restores the account data in the Snapshot, the same
+// as it was when it was fetched from AWS
+func (s *Snapshot) AccountData() (*AccountData, error) {
+	accountDir := s.Account.String()
+	accounts := map[string]*AccountData{
+		accountDir: NewAccountData(accountDir),
+	}
+
+	for _, sr := range s.Resources {
+		sr := sr
+		unmarshal := func(entity interface{}) error {
+			return json.Unmarshal(sr.Resource, entity)
+		}
+
+		r, err := loadResource(accounts, sr.File, unmarshal)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading %s from snapshot", sr.File)
+		}
+		if r == nil {
+			return nil, fmt.Errorf("Unexpected resource file %s in snapshot", sr.File)
+		}
+
+		if len(sr.RemoteState) > 0 {
+			setter, ok := r.(remoteStateSetter)
+			if !ok {
+				return nil, fmt.Errorf("Unexpected state for %s in snapshot", sr.File)
+			}
+			if err = setter.setRemoteState(sr.RemoteState); err != nil {
+				return nil, errors.Wrapf(err, "Error reading state of %s from snapshot", sr.File)
+			}
+		}
+	}
+
+	if len(accounts) != 1 {
+		return nil, fmt.Errorf("Snapshot has resources outside of account %s", accountDir)
+	}
+
+	data := accounts[accountDir]
+	data.Account = s.Account
+	data.organizationRootId = s.OrganizationRootId
+	data.noPasswordPolicy = data.PasswordPolicy == nil
+	data.noAccountAlias = data.Account.Alias == ""
+
+	return data, nil
+}
+
+// InRegions returns a copy of the account data with only the resource policies
+// in regions, the same as if only those regions had been fetched
+func (a *AccountData) InRegions(regions []string) *AccountData {
+	filtered := *a
+	filtered.ResourcePolicies = nil
+	for _, rp := range a.ResourcePolicies {
+		for _, region := range regions {
+			if rp.Region == region {
+				filtered.ResourcePolicies = append(filtered.ResourcePolicies, rp)
+			}
+		}
+	}
+	return &filtered
+}
@@ -0,0 +1,54 @@
+package iamy
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotRestoresAccountDataWithRemoteState(t *testing.T) {
+	y := YamlLoadDumper{Dir: filepath.Join("testdata")}
+	accountData, err := y.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &accountData[0]
+	data.organizationRootId = "r-root"
+	data.Users[0].credentials = userCredentials{AccessKeyIds: []string{"AKIAEXAMPLE"}, HasLoginProfile: true}
+	data.Policies[0].numberOfVersions = 5
+	data.Policies[0].oldestVersionId = "v1"
+	data.OrganizationalUnits[0].id = "ou-example"
+
+	snapshot, err := NewSnapshot(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "account.json")
+	if err = snapshot.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot, err = ReadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := snapshot.AccountData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := data.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual, _ := restored.Fingerprint(); actual != expected {
+		t.Error("Expected the restored account data to have the same fingerprint")
+	}
+	if restored.organizationRootId != "r-root" {
+		t.Errorf("Expected organization root r-root, got %s", restored.organizationRootId)
+	}
+	if !reflect.DeepEqual(restored.Account, data.Account) {
+		t.Errorf("Expected account %#v, got %#v", data.Account, restored.Account)
+	}
+	if plan := planForSync(t, restored, data); plan.Count() != 0 {
+		t.Errorf("Expected no changes, got:\n%s", plan)
+	}
+}
@@ -0,0 +1,168 @@
+package iamy
+
+import (
+	"github.com/99designs/iamy/iamy/memaws"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// A Verification is the result of applying a plan to a simulated copy of an
+// account and planning again. Once the plan is applied there should be no
+// changes left, otherwise pushing and then pulling doesn't converge
+type Verification struct {
+	// Plan is the plan applied to the simulated account
+	Plan *Plan
+	// Failures are the steps of Plan that failed in the simulated account
+	Failures []StepFailure
+	// Unconverged are the changes still needed after applying Plan
+	Unconverged *Plan
+	// Unverified are the kinds of resources the simulation leaves out,
+	// whose changes aren't verified
+	Unverified []string
+}
+
+// A StepFailure is a step that failed in the simulated account
+type StepFailure struct {
+	Step *Step
+	Err  error
+}
+
+// Converged is true if every step of the plan succeeded and no changes are left
+func (v *Verification) Converged() bool {
+	return len(v.Failures) == 0 && v.Unconverged.Count() == 0
+}
+
+// StepsFor returns the steps of the applied plan that changed the
+// same resource as s, which were expected to make s unnecessary
+func (v *Verification) StepsFor(s *Step) []*Step {
+	steps := []*Step{}
+	for _, planned := range v.Plan.Steps {
+		if planned.ResourceType == s.ResourceType && planned.ResourceName == s.ResourceName && planned.ResourcePath == s.ResourcePath {
+			steps = append(steps, planned)
+		}
+	}
+	return steps
+}
+
+// Verify copies the account data in from to a simulated account, applies the
+// plan to sync it to to, then fetches it again and checks nothing is left to sync
+func Verify(from, to *AccountData) (*Verification, error) {
+	v := Verification{Unverified: []string{}}
+	from, to = v.simulatable(from), v.simulatable(to)
+
+	sim, err := newSimulation(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// plan against the simulated copy rather than from, as the simulation
+	// doesn't have state that isn't fetched, like old policy versions
+	current, err := sim.fetch()
+	if err != nil {
+		return nil, err
+	}
+	if v.Plan, err = PlanForSync(current, to); err != nil {
+		return nil, err
+	}
+	for _, step := range v.Plan.Steps {
+		if err := sim.executor.Exec(step.Cmd); err != nil {
+			v.Failures = append(v.Failures, StepFailure{step, err})
+		}
+	}
+
+	after, err := sim.fetch()
+	if err != nil {
+		return nil, err
+	}
+	if v.Unconverged, err = PlanForSync(after, to); err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// simulatable returns a copy of the account data without the
+// resources the simulation leaves out, noting them as unverified
+func (v *Verification) simulatable(a *AccountData) *AccountData {
+	unverified := func(kind string) {
+		if !containsString(v.Unverified, kind) {
+			v.Unverified = append(v.Unverified, kind)
+		}
+	}
+
+	copied := *a
+	if len(a.ResourcePolicies) > 0 {
+		unverified("resource policies")
+		copied.ResourcePolicies = nil
+	}
+	if len(a.OrganizationalUnits) > 0 || len(a.ServiceControlPolicies) > 0 {
+		unverified("organizations")
+		copied.OrganizationalUnits = nil
+		copied.ServiceControlPolicies = nil
+	}
+	copied.organizationRootId = ""
+
+	return &copied
+}
+
+// A simulation is an in-memory fake of an AWS account
+type simulation struct {
+	clients  Clients
+	executor *Executor
+}
+
+// newSimulation creates a simulated account with the resources in from, and
+// the buckets of from and to, as iamy expects buckets to exist already
+func newSimulation(from, to *AccountData) (*simulation, error) {
+	b := memaws.New(from.Account.Id)
+	s := simulation{
+		clients: Clients{
+			IAM:            b.IAM(),
+			S3:             b.S3(),
+			CloudFormation: b.CloudFormation(),
+			S3Control:      b.S3Control(),
+			Organizations:  b.Organizations(),
+			STS:            b.STS(),
+		},
+	}
+	s.executor = NewExecutorWithClients(nil, s.clients)
+
+	buckets := []string{}
+	for _, a := range []*AccountData{from, to} {
+		for _, bp := range a.BucketPolicies {
+			if containsString(buckets, bp.BucketName) {
+				continue
+			}
+			buckets = append(buckets, bp.BucketName)
+			if _, err := b.S3().CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bp.BucketName)}); err != nil {
+				return nil, errors.Wrapf(err, "Error creating bucket %s in the simulated account", bp.BucketName)
+			}
+		}
+	}
+
+	empty := AccountData{Account: &Account{Id: from.Account.Id}}
+	copyPlan, err := PlanForSync(&empty, from)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range copyPlan.Steps {
+		if err := s.executor.Exec(step.Cmd); err != nil {
+			return nil, errors.Wrapf(err, "Error copying %s %s to the simulated account", step.ResourceType, step.ResourceName)
+		}
+	}
+
+	return &s, nil
+}
+
+func (s *simulation) fetch() (*AccountData, error) {
+	f := AwsFetcher{
+		SkipFetchingPolicyDescriptions: true,
+		Clients:                        s.clients,
+	}
+	data, err := f.Fetch()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error fetching the simulated account")
+	}
+	return data, nil
+}
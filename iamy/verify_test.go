@@ -0,0 +1,87 @@
+package iamy
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func loadVerifyTestdata(t *testing.T) (from, to *AccountData) {
+	y := YamlLoadDumper{Dir: filepath.Join("testdata")}
+	accountData, err := y.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	to = &accountData[0]
+	return &AccountData{Account: to.Account}, to
+}
+
+func TestVerifyConvergesFromEmptyAccount(t *testing.T) {
+	from, to := loadVerifyTestdata(t)
+	to.Users[0].Policies = []string{"arn:aws:iam::aws:policy/AmazonEC2ReadOnlyAccess"}
+
+	v, err := Verify(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range v.Failures {
+		t.Errorf("Step %d failed: %s", f.Step.ID, f.Err)
+	}
+	if v.Unconverged.Count() != 0 {
+		t.Errorf("Expected no changes after applying the plan, got:\n%s", v.Unconverged)
+	}
+	if !v.Converged() {
+		t.Error("Expected the plan to converge")
+	}
+	if !containsString(v.Unverified, "organizations") {
+		t.Errorf("Expected organizations to be unverified, got %v", v.Unverified)
+	}
+}
+
+func TestVerifyReportsStepsThatDontConverge(t *testing.T) {
+	from, to := loadVerifyTestdata(t)
+
+	// a policy name that isn't an arn is a customer managed policy, which doesn't exist
+	to.Users[0].Policies = []string{"AmazonEC2ReadOnlyAccess"}
+
+	v, err := Verify(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Converged() {
+		t.Fatal("Expected the plan not to converge")
+	}
+	if len(v.Failures) != 1 || v.Failures[0].Step.Cmd.Args[1] != "attach-user-policy" {
+		t.Errorf("Expected attach-user-policy to fail, got %v", v.Failures)
+	}
+	if v.Unconverged.Count() != 1 {
+		t.Fatalf("Expected 1 unconverged step, got:\n%s", v.Unconverged)
+	}
+	if steps := v.StepsFor(v.Unconverged.Steps[0]); len(steps) == 0 {
+		t.Error("Expected the unconverged step to be traced to the applied plan")
+	}
+}
+
+func TestVerifyReportsPrincipalsThatAwsRewrites(t *testing.T) {
+	from, to := loadVerifyTestdata(t)
+	to.Users[0].Policies = []string{"arn:aws:iam::aws:policy/AmazonEC2ReadOnlyAccess"}
+
+	// AWS stores an account id principal as the arn of the account's root user
+	trust, err := NewPolicyDocumentFromJson(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":"210987654321"},"Action":"sts:AssumeRole"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	role := newOrderTestRole(t, "cross-account", "/")
+	role.AssumeRolePolicyDocument = trust
+	to.Roles = append(to.Roles, role)
+
+	v, err := Verify(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Failures) != 0 {
+		t.Fatalf("Expected no failures, got %v", v.Failures)
+	}
+	if v.Unconverged.Count() != 1 || v.Unconverged.Steps[0].Cmd.Args[1] != "update-assume-role-policy" {
+		t.Errorf("Expected the rewritten trust policy to be updated again, got:\n%s", v.Unconverged)
+	}
+}
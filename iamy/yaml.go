@@ -13,10 +13,18 @@ import (
 )
 
 const pathTemplateBlob = "{{.Account}}/{{.Resource.Service}}/{{.Resource.ResourceType}}{{.Resource.ResourcePath}}{{.Resource.ResourceName}}.yaml"
-const pathRegexBlob = `^(?P<account>[^/]+)/(?P<entity>(iam/instance-profile|iam/user|iam/group|iam/policy|iam/role|s3))(?P<resourcepath>.*/)(?P<resourcename>[^/]+)\.yaml$`
+const pathRegexBlob = `^(?P<account>[^/]+)/(?P<entity>(iam/instance-profile|iam/saml-provider|iam/oidc-provider|iam/user|iam/group|iam/policy|iam/role|s3|organizations/ou|organizations/policy))(?P<resourcepath>.*/)(?P<resourcename>[^/]+)\.yaml$`
+
+// accountPathRegexBlob matches the files of account-level resources, of which there is one per account
+const accountPathRegexBlob = `^(?P<account>[^/]+)/(?P<entity>(iam/account-password-policy|iam/account-alias|s3control/public-access-block))\.yaml$`
+
+// regionalPathRegexBlob matches the files of resource policies, which are in a directory per region
+const regionalPathRegexBlob = `^(?P<account>[^/]+)/(?P<service>(sqs|sns|kms|ecr|lambda|secretsmanager))/(?P<region>[a-z0-9-]+)/(?P<resourcename>.+)\.yaml$`
 
 var pathTemplate = template.Must(template.New("").Parse(pathTemplateBlob))
 var pathRegex = regexp.MustCompile(pathRegexBlob)
+var accountPathRegex = regexp.MustCompile(accountPathRegexBlob)
+var regionalPathRegex = regexp.MustCompile(regionalPathRegexBlob)
 
 type pathTemplateData struct {
 	Account  *Account
@@ -74,63 +82,161 @@ func (a *YamlLoadDumper) Load() ([]AccountData, error) {
 	}
 
 	for _, fp := range allFiles {
-		if matched, result := namedMatch(pathRegex, fp); matched {
-			log.Println("Loading", fp)
+		unmarshal := func(entity interface{}) error {
+			return a.unmarshalYamlFile(fp, entity)
+		}
+		if _, err := loadResource(accounts, fp, unmarshal); err != nil {
+			return nil, err
+		}
+	}
 
-			accountid := result["account"]
-			entity := result["entity"]
-			path := result["resourcepath"]
-			name := result["resourcename"]
+	// an organizations directory manages its resources even when it's empty
+	for accountDir, data := range accounts {
+		data.hasOrganizationalUnitsDir = a.isDir(filepath.Join(accountDir, "organizations", "ou"))
+		data.hasServiceControlPoliciesDir = a.isDir(filepath.Join(accountDir, "organizations", "policy"))
+	}
 
-			if _, ok := accounts[accountid]; !ok {
-				accounts[accountid] = NewAccountData(accountid)
-			}
+	return accountMapToSlice(accounts), nil
+}
 
-			var err error
-			nameAndPath := iamService{Name: name, Path: path}
-
-			switch entity {
-			case "iam/user":
-				u := User{
-					iamService: nameAndPath,
-					Tags:       make(map[string]string),
-				}
-				err = a.unmarshalYamlFile(fp, &u)
-				accounts[accountid].addUser(&u)
-			case "iam/group":
-				g := Group{iamService: nameAndPath}
-				err = a.unmarshalYamlFile(fp, &g)
-				accounts[accountid].addGroup(&g)
-			case "iam/role":
-				r := Role{iamService: nameAndPath}
-				err = a.unmarshalYamlFile(fp, &r)
-				accounts[accountid].addRole(&r)
-			case "iam/policy":
-				p := Policy{iamService: nameAndPath}
-				err = a.unmarshalYamlFile(fp, &p)
-				accounts[accountid].addPolicy(&p)
-			case "iam/instance-profile":
-				profile := InstanceProfile{iamService: nameAndPath}
-				err = a.unmarshalYamlFile(fp, &profile)
-				accounts[accountid].addInstanceProfile(&profile)
-			case "s3":
-				bp := BucketPolicy{BucketName: name}
-				err = a.unmarshalYamlFile(fp, &bp)
-				accounts[accountid].addBucketPolicy(&bp)
-			default:
-				panic("Unexpected entity")
-			}
+// loadResource adds the resource in the file at path fp to its account, using
+// unmarshal to read the file. It returns the resource loaded, or nil if fp isn't
+// the path of a resource
+func loadResource(accounts map[string]*AccountData, fp string, unmarshal func(entity interface{}) error) (AwsResource, error) {
+	var r AwsResource
+	var err error
+
+	if matched, result := namedMatch(accountPathRegex, fp); matched {
+		log.Println("Loading", fp)
+
+		accountid := result["account"]
+		if _, ok := accounts[accountid]; !ok {
+			accounts[accountid] = NewAccountData(accountid)
+		}
 
-			if err != nil {
-				return nil, err
+		switch result["entity"] {
+		case "iam/account-password-policy":
+			// an empty file declares the account has no password policy
+			var pp *PasswordPolicy
+			err = unmarshal(&pp)
+			accounts[accountid].PasswordPolicy = pp
+			accounts[accountid].noPasswordPolicy = pp == nil
+			if pp != nil {
+				r = pp
 			}
+		case "s3control/public-access-block":
+			pab := AccountPublicAccessBlock{}
+			err = unmarshal(&pab)
+			accounts[accountid].PublicAccessBlock = &pab
+			r = &pab
+		case "iam/account-alias":
+			aa := AccountAlias{}
+			err = unmarshal(&aa)
+			// an empty alias declares the account has none
+			accounts[accountid].Account.Alias = aa.Alias
+			accounts[accountid].noAccountAlias = aa.Alias == ""
+			r = &aa
+		default:
+			panic("Unexpected entity")
+		}
+
+	} else if matched, result := namedMatch(regionalPathRegex, fp); matched {
+		log.Println("Loading", fp)
+
+		accountid := result["account"]
+		if _, ok := accounts[accountid]; !ok {
+			accounts[accountid] = NewAccountData(accountid)
+		}
 
-		} else {
-			log.Println("Skipping", fp)
+		rp := ResourcePolicy{
+			ServiceName: result["service"],
+			Region:      result["region"],
+			Name:        result["resourcename"],
 		}
+		err = unmarshal(&rp)
+		accounts[accountid].addResourcePolicy(&rp)
+		r = &rp
+
+	} else if matched, result := namedMatch(pathRegex, fp); matched {
+		log.Println("Loading", fp)
+
+		accountid := result["account"]
+		entity := result["entity"]
+		path := result["resourcepath"]
+		name := result["resourcename"]
+
+		if _, ok := accounts[accountid]; !ok {
+			accounts[accountid] = NewAccountData(accountid)
+		}
+
+		nameAndPath := iamService{Name: name, Path: path}
+
+		switch entity {
+		case "iam/user":
+			u := User{
+				iamService: nameAndPath,
+				Tags:       make(map[string]string),
+			}
+			err = unmarshal(&u)
+			accounts[accountid].addUser(&u)
+			r = &u
+		case "iam/group":
+			g := Group{iamService: nameAndPath}
+			err = unmarshal(&g)
+			accounts[accountid].addGroup(&g)
+			r = &g
+		case "iam/role":
+			role := Role{iamService: nameAndPath}
+			err = unmarshal(&role)
+			accounts[accountid].addRole(&role)
+			r = &role
+		case "iam/policy":
+			p := Policy{iamService: nameAndPath}
+			err = unmarshal(&p)
+			accounts[accountid].addPolicy(&p)
+			r = &p
+		case "iam/instance-profile":
+			profile := InstanceProfile{iamService: nameAndPath}
+			err = unmarshal(&profile)
+			accounts[accountid].addInstanceProfile(&profile)
+			r = &profile
+		case "iam/saml-provider":
+			p := SamlProvider{iamService: nameAndPath}
+			err = unmarshal(&p)
+			accounts[accountid].addSamlProvider(&p)
+			r = &p
+		case "iam/oidc-provider":
+			p := OidcProvider{iamService: nameAndPath}
+			err = unmarshal(&p)
+			accounts[accountid].addOidcProvider(&p)
+			r = &p
+		case "organizations/ou":
+			ou := OrganizationalUnit{organizationsService: organizationsService{Name: name, Path: path}}
+			err = unmarshal(&ou)
+			accounts[accountid].addOrganizationalUnit(&ou)
+			r = &ou
+		case "organizations/policy":
+			p := ServiceControlPolicy{organizationsService: organizationsService{Name: name, Path: path}}
+			err = unmarshal(&p)
+			accounts[accountid].addServiceControlPolicy(&p)
+			r = &p
+		case "s3":
+			bp := BucketPolicy{BucketName: name}
+			err = unmarshal(&bp)
+			accounts[accountid].addBucketPolicy(&bp)
+			r = &bp
+		default:
+			panic("Unexpected entity")
+		}
+
+	} else {
+		log.Println("Skipping", fp)
 	}
 
-	return accountMapToSlice(accounts), nil
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 func accountMapToSlice(accounts map[string]*AccountData) (aa []AccountData) {
@@ -146,50 +252,55 @@ func (f *YamlLoadDumper) Dump(accountData *AccountData, canDelete bool) error {
 	log.Println("Dumping YAML IAM data to", f.Dir)
 
 	if canDelete {
-		if err := os.RemoveAll(destDir); err != nil {
+		if err := removeFetchedFiles(destDir, accountData.fetchedRegions); err != nil {
 			return err
 		}
 	}
 
-	for _, u := range accountData.Users {
-		if err := f.writeResource(accountData.Account, u); err != nil {
+	for _, r := range accountData.Resources() {
+		if err := f.writeResource(accountData.Account, r); err != nil {
 			return err
 		}
 	}
 
-	for _, policy := range accountData.Policies {
-		if err := f.writeResource(accountData.Account, policy); err != nil {
-			return err
-		}
-	}
-
-	for _, group := range accountData.Groups {
-		if err := f.writeResource(accountData.Account, group); err != nil {
-			return err
-		}
-	}
+	return nil
+}
 
-	for _, role := range accountData.Roles {
-		if err := f.writeResource(accountData.Account, role); err != nil {
-			return err
-		}
+// removeFetchedFiles removes the files of an account before it's dumped,
+// keeping the resource policies of regions that weren't fetched
+func removeFetchedFiles(destDir string, regions []string) error {
+	entries, err := ioutil.ReadDir(destDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
 	}
 
-	for _, profile := range accountData.InstanceProfiles {
-		if err := f.writeResource(accountData.Account, profile); err != nil {
-			return err
+	for _, entry := range entries {
+		dir := filepath.Join(destDir, entry.Name())
+		if !entry.IsDir() || !containsString(ResourcePolicyServices, entry.Name()) {
+			if err = os.RemoveAll(dir); err != nil {
+				return err
+			}
+			continue
 		}
-	}
 
-	for _, bucketPolicy := range accountData.BucketPolicies {
-		if err := f.writeResource(accountData.Account, bucketPolicy); err != nil {
-			return err
+		for _, region := range regions {
+			if err = os.RemoveAll(filepath.Join(dir, region)); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+// isDir is true if relativePath is a directory in the yaml directory
+func (f *YamlLoadDumper) isDir(relativePath string) bool {
+	info, err := os.Stat(filepath.Join(f.Dir, relativePath))
+	return err == nil && info.IsDir()
+}
+
 func (f *YamlLoadDumper) unmarshalYamlFile(relativePath string, entity interface{}) error {
 	path := filepath.Join(f.Dir, relativePath)
 	data, err := ioutil.ReadFile(path)
@@ -204,8 +315,13 @@ func (f *YamlLoadDumper) unmarshalYamlFile(relativePath string, entity interface
 	return nil
 }
 
+// ResourceFile is the path of the yaml file for a resource, relative to the yaml directory
+func ResourceFile(a *Account, r AwsResource) string {
+	return mustExecutePathTemplate(pathTemplateData{a, r})
+}
+
 func (f *YamlLoadDumper) writeResource(a *Account, r AwsResource) error {
-	path := mustExecutePathTemplate(pathTemplateData{a, r})
+	path := ResourceFile(a, r)
 
 	return writeYamlFile(filepath.Join(f.Dir, path), r)
 }
@@ -64,3 +64,203 @@ func TestRoundTrip(t *testing.T) {
 		t.Error("Directory contents are not equal")
 	}
 }
+
+func TestLoadAccountPasswordPolicy(t *testing.T) {
+	y := YamlLoadDumper{Dir: filepath.Join("testdata")}
+	accountData, err := y.Load()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	pp := accountData[0].PasswordPolicy
+	if pp == nil {
+		t.Fatal("Expected a password policy")
+	}
+	if pp.MinimumPasswordLength != 14 || !pp.RequireSymbols || pp.HardExpiry {
+		t.Errorf("Unexpected password policy %#v", pp)
+	}
+	if f := ResourceFile(accountData[0].Account, pp); f != "myalias-123/iam/account-password-policy.yaml" {
+		t.Errorf("Unexpected resource file %s", f)
+	}
+}
+
+func TestEmptyAccountPasswordPolicyFileDeclaresNoPolicy(t *testing.T) {
+	dir := newTmpDir()
+	defer os.RemoveAll(dir)
+
+	fp := filepath.Join(dir, "123", "iam", "account-password-policy.yaml")
+	if err := os.MkdirAll(filepath.Dir(fp), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fp, []byte{}, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	accountData, err := (&YamlLoadDumper{Dir: dir}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accountData[0].PasswordPolicy != nil || !accountData[0].noPasswordPolicy {
+		t.Errorf("Expected no password policy, got %#v", accountData[0].PasswordPolicy)
+	}
+}
+
+func TestLoadOidcProviderWithPath(t *testing.T) {
+	y := YamlLoadDumper{Dir: filepath.Join("testdata")}
+	accountData, err := y.Load()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(accountData[0].OidcProviders) != 1 {
+		t.Fatalf("Expected 1 OIDC provider, got %d", len(accountData[0].OidcProviders))
+	}
+	p := accountData[0].OidcProviders[0]
+	if url := p.Url(); url != "https://oidc.eks.us-east-1.amazonaws.com/id/EXAMPLED539D4633E53DE1B71EXAMPLE" {
+		t.Errorf("Unexpected url %s", url)
+	}
+	if arn := Arn(p, accountData[0].Account); arn != "arn:aws:iam::123:oidc-provider/oidc.eks.us-east-1.amazonaws.com/id/EXAMPLED539D4633E53DE1B71EXAMPLE" {
+		t.Errorf("Unexpected arn %s", arn)
+	}
+	if n := oidcProviderNameAndPath(p.Url()); n != p.iamService {
+		t.Errorf("Expected %#v, got %#v", p.iamService, n)
+	}
+}
+
+func TestAccountAliasFileOverridesDirectoryName(t *testing.T) {
+	dir := newTmpDir()
+	defer os.RemoveAll(dir)
+
+	if err := writeYamlFile(filepath.Join(dir, "oldalias-123", "iam", "account-alias.yaml"), AccountAlias{"newalias"}); err != nil {
+		t.Fatal(err)
+	}
+
+	accountData, err := (&YamlLoadDumper{Dir: dir}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias := accountData[0].Account.Alias; alias != "newalias" {
+		t.Errorf("Expected alias newalias, got %s", alias)
+	}
+}
+
+func TestEmptyAccountAliasFileDeclaresNoAlias(t *testing.T) {
+	dir := newTmpDir()
+	defer os.RemoveAll(dir)
+
+	if err := writeYamlFile(filepath.Join(dir, "oldalias-123", "iam", "account-alias.yaml"), AccountAlias{}); err != nil {
+		t.Fatal(err)
+	}
+
+	accountData, err := (&YamlLoadDumper{Dir: dir}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias := accountData[0].Account.Alias; alias != "" || !accountData[0].noAccountAlias {
+		t.Errorf("Expected no alias, got %q", alias)
+	}
+}
+
+func TestPullWithDeleteKeepsTheAccountAliasFile(t *testing.T) {
+	dir := newTmpDir()
+	defer os.RemoveAll(dir)
+
+	y := YamlLoadDumper{Dir: dir}
+	data := NewAccountData("myalias-123")
+	for i := 0; i < 2; i++ {
+		if err := y.Dump(data, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "myalias-123", "iam", "account-alias.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	accountData, err := y.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias := accountData[0].Account.Alias; alias != "myalias" {
+		t.Errorf("Expected alias myalias, got %q", alias)
+	}
+}
+
+func TestLoadOrganization(t *testing.T) {
+	y := YamlLoadDumper{Dir: filepath.Join("testdata")}
+	accountData, err := y.Load()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if found, ou := accountData[0].FindOrganizationalUnitByPath("Engineering/Prod"); !found {
+		t.Error("Expected to find organizational unit Engineering/Prod")
+	} else if !reflect.DeepEqual(ou.Accounts, []string{"222222222222"}) {
+		t.Errorf("Unexpected accounts %#v", ou.Accounts)
+	}
+
+	found, p := accountData[0].FindServiceControlPolicyByName("DenyLeavingOrganization")
+	if !found {
+		t.Fatal("Expected to find service control policy DenyLeavingOrganization")
+	}
+	if !reflect.DeepEqual(p.Targets, []string{"Engineering/Prod", "Root"}) {
+		t.Errorf("Unexpected targets %#v", p.Targets)
+	}
+}
+
+func TestEmptyOrganizationsDirectoriesAreLoaded(t *testing.T) {
+	dir := newTmpDir()
+	defer os.RemoveAll(dir)
+
+	if err := writeYamlFile(filepath.Join(dir, "123", "iam", "user", "alice.yaml"), User{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "123", "organizations", "policy"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	accountData, err := (&YamlLoadDumper{Dir: dir}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !accountData[0].managesServiceControlPolicies() {
+		t.Error("Expected an empty organizations/policy directory to manage service control policies")
+	}
+	if accountData[0].managesOrganizationalUnits() {
+		t.Error("Expected organizational units not to be managed without organizations/ou")
+	}
+}
+
+func TestDumpWithDeleteKeepsResourcePoliciesOfRegionsNotFetched(t *testing.T) {
+	dir := newTmpDir()
+	defer os.RemoveAll(dir)
+
+	doc, err := NewPolicyDocumentFromJson(`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"sqs:SendMessage","Resource":"*"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := &Account{Id: "123", Alias: "myalias"}
+	y := YamlLoadDumper{Dir: dir}
+	stale := []AwsResource{
+		&User{iamService: iamService{Name: "gone", Path: "/"}},
+		&ResourcePolicy{ServiceName: "sqs", Region: "us-east-1", Name: "gone", Policy: doc},
+		&ResourcePolicy{ServiceName: "sqs", Region: "eu-west-1", Name: "kept", Policy: doc},
+	}
+	for _, r := range stale {
+		if err := y.writeResource(account, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := NewAccountData("myalias-123")
+	data.fetchedRegions = []string{"us-east-1"}
+	if err := y.Dump(data, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range stale {
+		_, err := os.Stat(filepath.Join(dir, ResourceFile(account, r)))
+		if kept := !os.IsNotExist(err); kept != (r.ResourceName() == "kept") {
+			t.Errorf("Expected %s to be kept only if its region wasn't fetched", ResourceFile(account, r))
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/99designs/iamy/iamy"
+)
+
+const (
+	outputText = "text"
+	outputJson = "json"
+)
+
+type stepOutput struct {
+	ID              int                  `json:"id"`
+	ResourceType    string               `json:"resourceType"`
+	ResourceName    string               `json:"resourceName"`
+	ResourcePath    string               `json:"resourcePath,omitempty"`
+	Action          iamy.Action          `json:"action"`
+	Target          string               `json:"target,omitempty"`
+	Destructive     bool                 `json:"destructive"`
+	Destructiveness iamy.Destructiveness `json:"destructiveness"`
+	Command         string               `json:"command"`
+	DependsOn       []int                `json:"dependsOn,omitempty"`
+	PolicyDiff      *iamy.PolicyDiff     `json:"policyDiff,omitempty"`
+}
+
+type planOutput struct {
+	Account     string       `json:"account"`
+	Count       int          `json:"count"`
+	Destructive int          `json:"destructive"`
+	Changes     []stepOutput `json:"changes"`
+	Warnings    []string     `json:"warnings,omitempty"`
+}
+
+func newPlanOutput(account *iamy.Account, plan *iamy.Plan) planOutput {
+	o := planOutput{
+		Account:     account.String(),
+		Count:       plan.Count(),
+		Destructive: plan.CountDestructive(),
+		Changes:     []stepOutput{},
+		Warnings:    plan.Warnings,
+	}
+	for _, s := range plan.Steps {
+		o.Changes = append(o.Changes, newStepOutput(s))
+	}
+	return o
+}
+
+func newStepOutput(s *iamy.Step) stepOutput {
+	return stepOutput{
+		ID:              s.ID,
+		ResourceType:    s.ResourceType,
+		ResourceName:    s.ResourceName,
+		ResourcePath:    s.ResourcePath,
+		Action:          s.Action,
+		Target:          s.Target,
+		Destructive:     s.IsDestructive(),
+		Destructiveness: s.Destructiveness,
+		Command:         s.String(),
+		DependsOn:       s.DependsOn,
+		PolicyDiff:      s.PolicyDiff(),
+	}
+}
+
+type resourceOutput struct {
+	ResourceType string `json:"resourceType"`
+	ResourceName string `json:"resourceName"`
+	ResourcePath string `json:"resourcePath,omitempty"`
+	File         string `json:"file"`
+}
+
+type pullOutput struct {
+	Account   string           `json:"account"`
+	Dir       string           `json:"dir"`
+	Counts    map[string]int   `json:"counts"`
+	Resources []resourceOutput `json:"resources"`
+}
+
+func newPullOutput(dir string, data *iamy.AccountData) pullOutput {
+	o := pullOutput{
+		Account:   data.Account.String(),
+		Dir:       dir,
+		Counts:    map[string]int{},
+		Resources: []resourceOutput{},
+	}
+	for _, r := range data.Resources() {
+		resourceType := iamy.ResourceTypeOf(r)
+		o.Counts[resourceType]++
+		o.Resources = append(o.Resources, resourceOutput{
+			ResourceType: resourceType,
+			ResourceName: r.ResourceName(),
+			ResourcePath: r.ResourcePath(),
+			File:         iamy.ResourceFile(data.Account, r),
+		})
+	}
+	return o
+}
+
+func printJson(ui Ui, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		ui.Fatal(err)
+	}
+	ui.Println(string(b))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/99designs/iamy/iamy"
+	"github.com/99designs/iamy/iamy/memaws"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// newTestUi returns a Ui writing stdout to a buffer
+func newTestUi() (Ui, *bytes.Buffer) {
+	stdout := &bytes.Buffer{}
+	return Ui{
+		Logger: log.New(stdout, "", 0),
+		Error:  log.New(ioutil.Discard, "", 0),
+		Debug:  log.New(ioutil.Discard, "", 0),
+		Exit:   func(code int) { panic(code) },
+	}, stdout
+}
+
+// setFlags sets the --output and --dry-run flags for the test
+func setFlags(t *testing.T, format string, dry bool) {
+	oldOutput, oldDryRun := output, dryRun
+	output, dryRun = &format, &dry
+	t.Cleanup(func() { output, dryRun = oldOutput, oldDryRun })
+}
+
+func loadTestData(t *testing.T, dir string) *iamy.AccountData {
+	data, err := (&iamy.YamlLoadDumper{Dir: filepath.Join("iamy", "testdata", dir)}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &data[0]
+}
+
+// decodeOnlyJson decodes stdout into v, failing if stdout has anything else
+func decodeOnlyJson(t *testing.T, stdout *bytes.Buffer, v interface{}) {
+	dec := json.NewDecoder(stdout)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		t.Fatalf("Expected stdout to be json, got %s", err)
+	}
+	var extra interface{}
+	if err := dec.Decode(&extra); err != io.EOF {
+		t.Fatalf("Expected only json on stdout, got more after it: %v", extra)
+	}
+}
+
+func TestPlanOutputDescribesEachStep(t *testing.T) {
+	local := loadTestData(t, "awsdiff/testcase1-local")
+	remote := loadTestData(t, "awsdiff/testcase1-remote")
+
+	plan, err := iamy.PlanForSync(remote, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := newPlanOutput(remote.Account, plan)
+
+	expected := planOutput{
+		Account:     "myalias-123",
+		Count:       2,
+		Destructive: 2,
+		Changes: []stepOutput{
+			{
+				ID:              1,
+				ResourceType:    "iam/role",
+				ResourceName:    "testrole",
+				ResourcePath:    "/",
+				Action:          iamy.ActionDetach,
+				Target:          "arn:aws:iam::123:policy/test",
+				Destructive:     true,
+				Destructiveness: iamy.Revokes,
+				Command:         "aws iam detach-role-policy --role-name testrole --policy-arn arn:aws:iam::123:policy/test",
+			},
+			{
+				ID:              2,
+				ResourceType:    "iam/policy",
+				ResourceName:    "test",
+				ResourcePath:    "/",
+				Action:          iamy.ActionDelete,
+				Destructive:     true,
+				Destructiveness: iamy.Deletes,
+				Command:         "aws iam delete-policy --policy-arn arn:aws:iam::123:policy/test",
+				DependsOn:       []int{1},
+			},
+		},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestPlanOutputOfNoChanges(t *testing.T) {
+	local := loadTestData(t, "awsdiff/testcase1-local")
+
+	plan, err := iamy.PlanForSync(local, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := newPlanOutput(local.Account, plan)
+
+	expected := planOutput{Account: "myalias-123", Changes: []stepOutput{}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestPushDryRunWithJsonOutputOnlyPrintsJson(t *testing.T) {
+	setFlags(t, outputJson, true)
+	ui, stdout := newTestUi()
+	local := loadTestData(t, "awsdiff/testcase1-local")
+	remote := loadTestData(t, "awsdiff/testcase1-remote")
+
+	plan := sync(*local, remote, ui)
+
+	var actual planOutput
+	decodeOnlyJson(t, stdout, &actual)
+	if expected := newPlanOutput(remote.Account, plan); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestPushDryRunWithTextOutputPrintsCommands(t *testing.T) {
+	setFlags(t, outputText, true)
+	ui, stdout := newTestUi()
+	local := loadTestData(t, "awsdiff/testcase1-local")
+	remote := loadTestData(t, "awsdiff/testcase1-remote")
+
+	sync(*local, remote, ui)
+
+	if !bytes.Contains(stdout.Bytes(), []byte("aws iam delete-policy --policy-arn arn:aws:iam::123:policy/test")) ||
+		!bytes.Contains(stdout.Bytes(), []byte("Dry-run mode not running aws commands")) {
+		t.Errorf("Expected the commands as text, got:\n%s", stdout)
+	}
+}
+
+func TestPullWithJsonOutputSummarisesTheFilesWritten(t *testing.T) {
+	setFlags(t, outputJson, false)
+	ui, stdout := newTestUi()
+
+	b := memaws.New("123456789012")
+	for _, err := range []error{
+		ignoreOutput(b.IAM().CreateAccountAlias(&iam.CreateAccountAliasInput{AccountAlias: aws.String("example")})),
+		ignoreOutput(b.IAM().CreateGroup(&iam.CreateGroupInput{GroupName: aws.String("Developers")})),
+		ignoreOutput(b.IAM().CreateUser(&iam.CreateUserInput{UserName: aws.String("alice"), Path: aws.String("/staff/")})),
+		ignoreOutput(b.IAM().CreateUser(&iam.CreateUserInput{UserName: aws.String("bob")})),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetcher := iamy.AwsFetcher{Clients: backendClients(b)}
+	data, err := fetcher.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	dumpAccount(ui, PullCommandInput{Dir: dir}, data)
+
+	var actual pullOutput
+	decodeOnlyJson(t, stdout, &actual)
+	expected := pullOutput{
+		Account: "example-123456789012",
+		Dir:     dir,
+		Counts:  map[string]int{"iam": 1, "iam/group": 1, "iam/user": 2},
+		Resources: []resourceOutput{
+			{ResourceType: "iam/user", ResourceName: "alice", ResourcePath: "/staff/", File: "example-123456789012/iam/user/staff/alice.yaml"},
+			{ResourceType: "iam/user", ResourceName: "bob", ResourcePath: "/", File: "example-123456789012/iam/user/bob.yaml"},
+			{ResourceType: "iam/group", ResourceName: "Developers", ResourcePath: "/", File: "example-123456789012/iam/group/Developers.yaml"},
+			{ResourceType: "iam", ResourceName: "account-alias", File: "example-123456789012/iam/account-alias.yaml"},
+		},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, actual)
+	}
+	for _, r := range actual.Resources {
+		if _, err := os.Stat(filepath.Join(dir, r.File)); err != nil {
+			t.Errorf("Expected %s to be written: %s", r.File, err)
+		}
+	}
+}
+
+func ignoreOutput(_ interface{}, err error) error {
+	return err
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/99designs/iamy/iamy"
+)
+
+type PlanCommandInput struct {
+	Dir     string
+	OutFile string
+}
+
+func PlanCommand(ui Ui, input PlanCommandInput) {
+	yamlData, awsData := loadAndFetch(ui, input.Dir, "")
+	if yamlData == nil {
+		ui.Fatal("No files found for AWS Account ID " + awsData.Account.Id)
+	}
+
+	plan, err := iamy.PlanForSync(awsData, yamlData)
+	if err != nil {
+		ui.Fatal(err)
+	}
+	if plan.Count() == 0 {
+		ui.Println("Already up to date")
+	} else {
+		ui.Println("Commands to push changes to AWS:")
+		printCommands("      ", plan, ui)
+	}
+	printWarnings(plan, ui)
+
+	planFile, err := iamy.NewPlanFile(plan, awsData)
+	if err != nil {
+		ui.Fatal(err)
+	}
+	if err = planFile.Write(input.OutFile); err != nil {
+		ui.Fatal(err)
+	}
+
+	ui.Printf("\nPlan saved to %s. To apply exactly these commands, run:\n      iamy apply %s", input.OutFile, input.OutFile)
+}
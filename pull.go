@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"os"
 
 	"github.com/99designs/iamy/iamy"
 )
@@ -10,20 +10,62 @@ type PullCommandInput struct {
 	Dir                  string
 	CanDelete            bool
 	HeuristicCfnMatching bool
+	Regions              []string
+	AllAccounts          *AllAccountsInput
 }
 
 func PullCommand(ui Ui, input PullCommandInput) {
-	aws := iamy.AwsFetcher{Debug: ui.Debug, HeuristicCfnMatching: input.HeuristicCfnMatching}
+	if input.AllAccounts != nil {
+		PullAllAccounts(ui, input)
+		return
+	}
+
+	if len(input.Regions) == 0 {
+		input.Regions = []string{iamy.DefaultRegion()}
+	}
+
+	declared := loadDeclared(ui, input.Dir)
+	aws := iamy.AwsFetcher{
+		Debug:                ui.Debug,
+		HeuristicCfnMatching: input.HeuristicCfnMatching,
+		Regions:              input.Regions,
+		CredentialsNeeded:    iamy.CredentialsNeededToPull(declared),
+		SSHPublicKeysNeeded:  iamy.SSHPublicKeysNeededFor(declared),
+	}
 	data, err := aws.Fetch()
 	if err != nil {
-		ui.Error.Fatal(fmt.Printf("%s", err))
+		ui.Error.Fatal(err)
 	}
+	data.KeepCredentialExpectations(declared)
+
+	dumpAccount(ui, input, data)
+}
 
+// loadDeclared loads the yaml already in dir, so that pulling keeps the
+// expectations of users' credentials it declares
+func loadDeclared(ui Ui, dir string) []iamy.AccountData {
+	yaml := iamy.YamlLoadDumper{
+		Dir: dir,
+	}
+	declared, err := yaml.Load()
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		ui.Error.Fatal(err)
+	}
+	return declared
+}
+
+// dumpAccount writes the fetched account data to yaml files in the directory
+func dumpAccount(ui Ui, input PullCommandInput, data *iamy.AccountData) {
 	yaml := iamy.YamlLoadDumper{
 		Dir: input.Dir,
 	}
-	err = yaml.Dump(data, input.CanDelete)
-	if err != nil {
+	if err := yaml.Dump(data, input.CanDelete); err != nil {
 		ui.Error.Fatal(err)
 	}
+
+	if *output == outputJson {
+		printJson(ui, newPullOutput(input.Dir, data))
+	}
 }
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 
 	"github.com/99designs/iamy/iamy"
@@ -12,98 +11,221 @@ import (
 )
 
 type PushCommandInput struct {
-	Dir string
+	Dir              string
+	DetailedExitCode bool
+	AllAccounts      *AllAccountsInput
+	SnapshotFile     string
 }
 
 func PushCommand(ui Ui, input PushCommandInput) {
+	if input.AllAccounts != nil {
+		PushAllAccounts(ui, input)
+		return
+	}
+	if input.DetailedExitCode {
+		defer exitOnPanic(ui)
+	}
+
+	yamlData, awsData := loadAndFetch(ui, input.Dir, input.SnapshotFile)
+	if yamlData == nil {
+		if *output == outputJson || input.DetailedExitCode {
+			ui.Error.Fatal("No files found for AWS Account ID " + awsData.Account.Id)
+		}
+		ui.Println("No files found for AWS Account ID " + awsData.Account.Id)
+		return
+	}
+
+	plan := sync(*yamlData, awsData, ui)
+	if input.DetailedExitCode && plan.Count() > 0 {
+		ui.Exit(exitCodeChanges)
+	}
+}
+
+// loadAndFetch loads the yaml account data in dir, and fetches the data for the
+// current AWS account, or loads it from snapshotFile if it's set. yamlData is
+// nil if there are no files for the AWS account
+func loadAndFetch(ui Ui, dir, snapshotFile string) (yamlData, awsData *iamy.AccountData) {
 	yaml := iamy.YamlLoadDumper{
-		Dir: input.Dir,
+		Dir: dir,
 	}
 	aws := iamy.AwsFetcher{
-		SkipFetchingPolicyAndRoleDescriptions: true,
-		Debug:                                 ui.Debug,
+		SkipFetchingPolicyDescriptions: true,
+		Debug:                          ui.Debug,
 	}
 
 	allDataFromYaml, err := yaml.Load()
 	if err != nil {
 		ui.Fatal(err)
-		return
 	}
+	aws.CredentialsNeeded = iamy.CredentialsNeededFor(allDataFromYaml)
+	aws.SSHPublicKeysNeeded = iamy.SSHPublicKeysNeededFor(allDataFromYaml)
 
-	dataFromAws, err := aws.Fetch()
-	if err != nil {
+	// only fetch resource policies from the regions that are in yaml, so that
+	// resources in other regions aren't deleted
+	for _, dataFromYaml := range allDataFromYaml {
+		for _, region := range dataFromYaml.Regions() {
+			if !containsString(aws.Regions, region) {
+				aws.Regions = append(aws.Regions, region)
+			}
+		}
+	}
+
+	var dataFromAws *iamy.AccountData
+	if snapshotFile != "" {
+		dataFromAws = loadSnapshot(ui, snapshotFile, aws.Regions)
+	} else if dataFromAws, err = aws.Fetch(); err != nil {
 		ui.Fatal(err)
-		return
 	}
 
 	// find the yaml account data that matches the aws account
 	for _, dataFromYaml := range allDataFromYaml {
 		if dataFromYaml.Account.Id == dataFromAws.Account.Id {
-			sync(dataFromYaml, dataFromAws, ui)
-			return
+			return &dataFromYaml, dataFromAws
 		}
 	}
 
-	ui.Println("No files found for AWS Account ID " + dataFromAws.Account.Id)
+	return nil, dataFromAws
 }
 
-func printCommands(prefix string, awsCmds iamy.CmdList, ui Ui) {
-	for _, cmd := range awsCmds {
-		cmdStr := cmd.String()
-		if cmd.IsDestructive() {
+func printCommands(prefix string, plan *iamy.Plan, ui Ui) {
+	for _, step := range plan.Steps {
+		cmdStr := step.String()
+		diff := step.PolicyDiff()
+		if diff != nil && diff.IsEmpty() {
+			diff = nil
+		}
+		if diff != nil {
+			cmdStr = step.Cmd.Abbreviated()
+		}
+		if step.IsDestructive() {
 			cmdStr = color.RedString(cmdStr)
 		}
 		ui.Println(prefix + cmdStr)
+
+		if diff != nil {
+			for _, line := range strings.Split(diff.String(), "\n") {
+				ui.Println(prefix + "    " + colorDiffLine(line))
+			}
+		}
 	}
 }
 
-func sync(yamlData iamy.AccountData, awsData *iamy.AccountData, ui Ui) {
-	ui.Debug.Printf("Generating sync commands for %s", awsData.Account.String())
+func colorDiffLine(line string) string {
+	switch strings.TrimLeft(line, " ")[:1] {
+	case "+":
+		return color.GreenString(line)
+	case "-":
+		return color.RedString(line)
+	case "~":
+		return color.YellowString(line)
+	}
+	return line
+}
 
-	awsCmds := iamy.AwsCliCmdsForSync(awsData, &yamlData)
-	if len(awsCmds) == 0 {
+func printPlan(account *iamy.Account, plan *iamy.Plan, ui Ui) {
+	if *output == outputJson {
+		printJson(ui, newPlanOutput(account, plan))
+		return
+	}
+	if plan.Count() == 0 {
 		ui.Println("Already up to date")
+	} else {
+		ui.Println("Commands to push changes to AWS:")
+		printCommands("      ", plan, ui)
+	}
+
+	printWarnings(plan, ui)
+}
+
+func printWarnings(plan *iamy.Plan, ui Ui) {
+	if len(plan.Warnings) == 0 {
 		return
 	}
 
-	ui.Println("Commands to push changes to AWS:")
+	ui.Println("\nWarnings:")
+	for _, w := range plan.Warnings {
+		ui.Println("      " + color.YellowString(w))
+	}
+}
+
+func sync(yamlData iamy.AccountData, awsData *iamy.AccountData, ui Ui) *iamy.Plan {
+	ui.Debug.Printf("Generating sync commands for %s", awsData.Account.String())
 
-	printCommands("      ", awsCmds, ui)
+	plan, err := iamy.PlanForSync(awsData, &yamlData)
+	if err != nil {
+		ui.Error.Fatal(err)
+	}
+	printPlan(awsData.Account, plan, ui)
+	if plan.Count() == 0 {
+		return plan
+	}
 
 	if *dryRun {
-		ui.Println("Dry-run mode not running aws commands")
-		return
+		if *output == outputText {
+			ui.Println("Dry-run mode not running aws commands")
+		}
+		return plan
 	}
-	r, err := prompt(fmt.Sprintf("\nRun %d aws commands (%d destructive)? (y/N) ", awsCmds.Count(), awsCmds.CountDestructive()))
-	if err != nil {
+
+	// fetch the same regions again after pushing
+	fetcher := rollbackFetcher(ui, yamlData.Regions(), iamy.CredentialsNeededFor([]iamy.AccountData{yamlData}), iamy.SSHPublicKeysNeededFor([]iamy.AccountData{yamlData}))
+	rb := newRollback(plan, awsData, &yamlData, fetcher, false)
+	if err := promptAndExec(plan, iamy.NewExecutor(), rb, ui); err != nil {
 		ui.Fatal(err)
-		return
 	}
-	if r == "y" {
-		for _, c := range awsCmds {
-			execCmd(c, ui)
-		}
-	} else {
+
+	return plan
+}
+
+// promptAndExec runs the plan if confirmed, saving the plan to undo it first,
+// and returns the error if it couldn't be run or a step failed
+func promptAndExec(plan *iamy.Plan, executor *iamy.Executor, rb *rollback, ui Ui) error {
+	r, err := prompt(fmt.Sprintf("\nRun %d aws commands (%d destructive)? (y/N) ", plan.Count(), plan.CountDestructive()))
+	if err != nil {
+		return err
+	}
+	if r != "y" {
 		ui.Println("Not running aws commands")
+		return nil
+	}
+
+	if err = rb.savePending(); err != nil {
+		return err
+	}
+	for _, step := range plan.Steps {
+		if err = execStep(executor, step, ui); err != nil {
+			break
+		}
+	}
+	rb.save(ui)
+
+	return err
+}
+
+func execStep(executor *iamy.Executor, step *iamy.Step, ui Ui) error {
+	ui.Println("\n>", step)
+	if err := executor.Exec(step.Cmd); err != nil {
+		return fmt.Errorf("Step %d (%s %s %s) failed: %s", step.ID, step.Action, step.ResourceType, step.ResourceName, err)
 	}
+	return nil
 }
 
-func execCmd(c iamy.Cmd, ui Ui) {
-	ui.Println("\n>", c)
-	cmd := exec.Command(c.Name, c.Args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		ui.Fatal(err)
-		return
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
 	}
+	return false
 }
 
+// stdin is read by prompt, and shared so that answers piped to more than
+// one prompt aren't lost to a reader's buffer
+var stdin = bufio.NewReader(os.Stdin)
+
 func prompt(prompt string) (string, error) {
-	reader := bufio.NewReader(os.Stdin)
 	fmt.Print(prompt)
-	text, err := reader.ReadString('\n')
+	text, err := stdin.ReadString('\n')
 	if err != nil {
 		return "", err
 	}
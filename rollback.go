@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/99designs/iamy/iamy"
+)
+
+// A rollback saves the plan that undoes a push or apply. A push's rollback
+// is saved before the push runs, from the account data fetched before it and
+// the yaml account data pushed. Only when that can't undo everything the push
+// does, or when applying a plan file, is the account fetched again after it
+type rollback struct {
+	path       string
+	plan       *iamy.Plan
+	before     *iamy.AccountData
+	expected   *iamy.AccountData
+	newFetcher func() *iamy.AwsFetcher
+	// complete is true once the rollback saved before the push undoes all of it
+	complete bool
+}
+
+// newRollback creates a rollback for a plan from before to the yaml account
+// data in expected, or nil expected when applying a saved plan, saved to
+// rollback-<timestamp>.json, or rollback-<account id>-<timestamp>.json when
+// pushing more than one account. newFetcher creates the fetchers that fetch
+// the account again
+func newRollback(plan *iamy.Plan, before, expected *iamy.AccountData, newFetcher func() *iamy.AwsFetcher, withAccountId bool) *rollback {
+	name := "rollback-"
+	if withAccountId {
+		name += before.Account.Id + "-"
+	}
+	name += time.Now().UTC().Format("20060102T150405Z") + ".json"
+
+	return &rollback{
+		path:       name,
+		plan:       plan,
+		before:     before,
+		expected:   expected,
+		newFetcher: newFetcher,
+	}
+}
+
+// savePending fetches the descriptions of the policies the plan deletes,
+// so that the rollback creates them again as they were, and saves the
+// rollback before the plan runs. Without yaml account data there's nothing
+// to save until the plan has run
+func (r *rollback) savePending() error {
+	if err := r.newFetcher().FetchPolicyDescriptions(r.plan.DeletedPolicies(r.before)); err != nil {
+		return fmt.Errorf("Not running aws commands, as the rollback plan couldn't be saved: %s", err)
+	}
+	if r.expected == nil {
+		return nil
+	}
+
+	pf, complete, err := iamy.NewPendingRollbackPlanFile(r.before, r.expected)
+	if err == nil {
+		err = pf.Write(r.path)
+	}
+	if err != nil {
+		return fmt.Errorf("Not running aws commands, as the rollback plan couldn't be saved: %s", err)
+	}
+	r.complete = complete
+	return nil
+}
+
+// save saves the rollback after the plan runs. Unless the rollback saved
+// before it is complete, the account is fetched again to save the rollback
+// from the account as it is now. That's a best effort, whatever the plan's
+// result: if the account can't be fetched, the rollback saved before is kept
+func (r *rollback) save(ui Ui) {
+	if !r.complete {
+		if err := r.saveFetched(); err != nil && r.expected == nil {
+			ui.Error.Printf("Rollback plan couldn't be saved after running the commands: %s", err)
+			return
+		} else if err != nil {
+			ui.Error.Printf("Rollback plan is from the changes expected before pushing, as it couldn't be updated after pushing: %s", err)
+		}
+	}
+	ui.Printf("\nRollback plan saved to %s. To undo these changes, run:\n      iamy apply %s", r.path, r.path)
+}
+
+// saveFetched saves the rollback from the account data fetched again
+func (r *rollback) saveFetched() error {
+	after, err := r.newFetcher().Fetch()
+	if err != nil {
+		return err
+	}
+	pf, err := iamy.NewRollbackPlanFile(r.before, after)
+	if err != nil {
+		return err
+	}
+	return pf.Write(r.path)
+}
+
+// rollbackFetcher creates the fetchers for a rollback, fetching the resource
+// policies in regions, and the credentials and ssh public keys of the users
+// that credentialsNeeded and sshPublicKeysNeeded are true for
+func rollbackFetcher(ui Ui, regions []string, credentialsNeeded, sshPublicKeysNeeded func(*iamy.Account, *iamy.User) bool) func() *iamy.AwsFetcher {
+	return func() *iamy.AwsFetcher {
+		return &iamy.AwsFetcher{
+			SkipFetchingPolicyDescriptions: true,
+			Debug:                          ui.Debug,
+			Regions:                        regions,
+			CredentialsNeeded:              credentialsNeeded,
+			SSHPublicKeysNeeded:            sshPublicKeysNeeded,
+		}
+	}
+}
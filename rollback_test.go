@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/99designs/iamy/iamy"
+	"github.com/99designs/iamy/iamy/memaws"
+)
+
+func TestPushRollbackIsSavedWithoutFetchingAgain(t *testing.T) {
+	inTempDir(t)
+	ui, stdout := newTestUi()
+	b := memaws.New("123456789012")
+	fetches := 0
+	newFetcher := func() *iamy.AwsFetcher {
+		fetches++
+		return &iamy.AwsFetcher{Clients: backendClients(b)}
+	}
+	before, err := newFetcher().Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &iamy.User{}
+	alice.Name, alice.Path = "alice", "/"
+	expected := &iamy.AccountData{Account: &iamy.Account{Id: "123456789012"}, Users: []*iamy.User{alice}}
+	plan, err := iamy.PlanForSync(before, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches = 0
+
+	rb := newRollback(plan, before, expected, newFetcher, false)
+	if err = rb.savePending(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range plan.Steps {
+		if err = execStep(iamy.NewExecutorWithClients(nil, backendClients(b)), s, ui); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rb.save(ui)
+
+	// only to fetch the descriptions of deleted policies before the push
+	if fetches != 1 {
+		t.Errorf("Expected the account not to be fetched after the push, got %d fetchers", fetches)
+	}
+	pf, err := iamy.ReadPlanFile(rb.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "aws iam delete-user --user-name alice"; pf.Plan.String() != expected {
+		t.Errorf("Expected the rollback:\n%s\nActual:\n%s", expected, pf.Plan)
+	}
+	if !bytes.Contains(stdout.Bytes(), []byte("Rollback plan saved to "+rb.path)) {
+		t.Errorf("Expected the rollback to be reported, got:\n%s", stdout)
+	}
+}
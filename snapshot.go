@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/99designs/iamy/iamy"
+)
+
+type SnapshotCommandInput struct {
+	OutFile string
+	Regions []string
+}
+
+// SnapshotCommand saves the account data fetched from AWS to a file, for
+// push --from-snapshot and diff --from-snapshot to use without AWS
+func SnapshotCommand(ui Ui, input SnapshotCommandInput) {
+	if len(input.Regions) == 0 {
+		input.Regions = []string{iamy.DefaultRegion()}
+	}
+
+	aws := iamy.AwsFetcher{
+		SkipFetchingPolicyDescriptions: true,
+		Debug:                          ui.Debug,
+		Regions:                        input.Regions,
+	}
+	data, err := aws.Fetch()
+	if err != nil {
+		ui.Fatal(err)
+	}
+
+	snapshot, err := iamy.NewSnapshot(data)
+	if err != nil {
+		ui.Fatal(err)
+	}
+	if err = snapshot.Write(input.OutFile); err != nil {
+		ui.Fatal(err)
+	}
+
+	ui.Printf("Snapshot of %s saved to %s", data.Account, input.OutFile)
+}
+
+// loadSnapshot loads the account data in a snapshot, with only the resource
+// policies in regions, the same as fetching those regions from AWS
+func loadSnapshot(ui Ui, snapshotFile string, regions []string) *iamy.AccountData {
+	snapshot, err := iamy.ReadSnapshot(snapshotFile)
+	if err != nil {
+		ui.Fatal(err)
+	}
+	data, err := snapshot.AccountData()
+	if err != nil {
+		ui.Fatal(err)
+	}
+
+	return data.InRegions(regions)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/99designs/iamy/iamy"
+	"github.com/fatih/color"
+)
+
+type VerifyCommandInput struct {
+	Dir          string
+	SnapshotFile string
+}
+
+type stepFailureOutput struct {
+	Step  stepOutput `json:"step"`
+	Error string     `json:"error"`
+}
+
+type unconvergedOutput struct {
+	Step     stepOutput `json:"step"`
+	AfterIDs []int      `json:"afterIds"`
+}
+
+type verifyOutput struct {
+	Account     string              `json:"account"`
+	Converged   bool                `json:"converged"`
+	Count       int                 `json:"count"`
+	Failures    []stepFailureOutput `json:"failures"`
+	Unconverged []unconvergedOutput `json:"unconverged"`
+	Unverified  []string            `json:"unverified"`
+}
+
+// VerifyCommand applies the plan push would run to a simulated copy of the
+// AWS account, and reports the steps that leave changes behind. It exits with
+// 0 when the plan converges, 2 when it doesn't and 1 on error
+func VerifyCommand(ui Ui, input VerifyCommandInput) {
+	defer exitOnPanic(ui)
+
+	yamlData, awsData := loadAndFetch(ui, input.Dir, input.SnapshotFile)
+	if yamlData == nil {
+		ui.Error.Fatal("No files found for AWS Account ID " + awsData.Account.Id)
+	}
+
+	v, err := iamy.Verify(awsData, yamlData)
+	if err != nil {
+		ui.Fatal(err)
+	}
+
+	if *output == outputJson {
+		printJson(ui, newVerifyOutput(awsData.Account, v))
+	} else {
+		printVerification(v, ui)
+	}
+
+	if !v.Converged() {
+		ui.Exit(exitCodeChanges)
+	}
+}
+
+func newVerifyOutput(account *iamy.Account, v *iamy.Verification) verifyOutput {
+	o := verifyOutput{
+		Account:     account.String(),
+		Converged:   v.Converged(),
+		Count:       v.Plan.Count(),
+		Failures:    []stepFailureOutput{},
+		Unconverged: []unconvergedOutput{},
+		Unverified:  v.Unverified,
+	}
+	for _, f := range v.Failures {
+		o.Failures = append(o.Failures, stepFailureOutput{newStepOutput(f.Step), f.Err.Error()})
+	}
+	for _, s := range v.Unconverged.Steps {
+		u := unconvergedOutput{Step: newStepOutput(s), AfterIDs: []int{}}
+		for _, planned := range v.StepsFor(s) {
+			u.AfterIDs = append(u.AfterIDs, planned.ID)
+		}
+		o.Unconverged = append(o.Unconverged, u)
+	}
+	return o
+}
+
+func printVerification(v *iamy.Verification, ui Ui) {
+	ui.Printf("Applied %d commands to a simulated copy of the account\n", v.Plan.Count())
+
+	if len(v.Failures) > 0 {
+		ui.Println("\nCommands that failed:")
+		for _, f := range v.Failures {
+			ui.Println("      " + color.RedString(f.Step.String()))
+			ui.Println("          " + f.Err.Error())
+		}
+	}
+
+	if v.Unconverged.Count() > 0 {
+		ui.Println("\nChanges still needed after applying the plan:")
+		for _, s := range v.Unconverged.Steps {
+			ui.Println("      " + color.RedString(s.String()))
+			for _, planned := range v.StepsFor(s) {
+				ui.Println("          after " + planned.String())
+			}
+		}
+	}
+
+	switch {
+	case !v.Converged():
+		ui.Println("\nThe plan doesn't converge")
+	case len(v.Unverified) > 0:
+		ui.Println("\nThe plan converges, except for what isn't simulated")
+	default:
+		ui.Println("\nThe plan converges")
+	}
+
+	if len(v.Unverified) > 0 {
+		ui.Println("\nWarnings:")
+		ui.Println("      " + color.YellowString(fmt.Sprintf("Changes to %s aren't verified, as they aren't simulated", strings.Join(v.Unverified, " and "))))
+	}
+}